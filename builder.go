@@ -2,12 +2,15 @@ package lifecycle
 
 import (
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"sort"
+	"strings"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/pkg/errors"
@@ -21,14 +24,41 @@ type Builder struct {
 	AppDir        string
 	LayersDir     string
 	PlatformDir   string
+	SecretsDir    string // if set, the secrets in it are given to each buildpack's /bin/build as files in a private directory
 	BuildpacksDir string
 	PlatformAPI   *api.Version
 	Env           BuildEnv
 	Group         BuildpackGroup
 	Plan          BuildPlan
 	Out, Err      *log.Logger
+	Logger        Logger
+	Metrics       *Metrics
+	LogsDir       string // if set, the output of each buildpack is also written to <LogsDir>/<buildpack ID>/build.log
+	PrefixOutput  bool   // if set, each line of output is prefixed with the buildpack ID
+	Timeout       time.Duration
+	GracePeriod   time.Duration
+
+	// PlanFulfillment is PlanFulfillmentWarn or PlanFulfillmentStrict to check, after all buildpacks ran,
+	// that every build plan entry was claimed by one of its providers. Plan fulfillment is not checked if empty.
+	PlanFulfillment string
+
+	// TOMLValidation is TOMLValidationWarn or TOMLValidationStrict to check the TOML files written by
+	// each buildpack for unknown keys and missing required keys. Only value types are checked if empty.
+	TOMLValidation string
+
+	// CheckpointPath is where a checkpoint is written after each buildpack completes, if set.
+	// If Resume is also set, buildpacks that completed in the checkpointed build are skipped,
+	// as long as their layers directories are unchanged. The build is not resumed at all if the app
+	// directory changed after the last buildpack completed, e.g. if the buildpack that failed wrote to it.
+	CheckpointPath string
+	Resume         bool
 }
 
+const (
+	PlanFulfillmentWarn   = "warn"
+	PlanFulfillmentStrict = "strict"
+)
+
 type BuildEnv interface {
 	AddRootDir(baseDir string) error
 	AddEnvDir(envDir string) error
@@ -56,6 +86,48 @@ type BuildpackPlan struct {
 	Entries []Require `toml:"entries"`
 }
 
+// terminateStrayProcesses terminates any processes, such as daemons, that the buildpack left running
+// so that they do not keep writing to the layers directory after the build.
+func (b *Builder) terminateStrayProcesses(cmd *exec.Cmd, bp Buildpack) error {
+	if cmd.Process == nil {
+		return nil
+	}
+	gracePeriod := b.GracePeriod
+	if gracePeriod == 0 {
+		gracePeriod = defaultGracePeriod
+	}
+	stray, err := terminateProcessGroup(cmd, gracePeriod)
+	if len(stray) > 0 {
+		var procs []string
+		for _, p := range stray {
+			procs = append(procs, p.String())
+		}
+		b.Logger.Warnf("Buildpack %s left processes running after it exited, terminated: %s", bp, strings.Join(procs, ", "))
+	}
+	if err != nil {
+		return errors.Wrapf(err, "terminate processes left running by buildpack %s", bp)
+	}
+	return nil
+}
+
+// buildpackOutput returns the writers for the stdout and stderr of the buildpack's /bin/build
+// and the log file that also captures them, if any, which must be closed after the build.
+func (b *Builder) buildpackOutput(bp Buildpack) (io.Writer, io.Writer, *os.File, error) {
+	var stdout, stderr io.Writer = b.Out.Writer(), b.Err.Writer()
+	if b.PrefixOutput {
+		prefix := "[" + bp.ID + "] "
+		stdout, stderr = newPrefixWriter(stdout, prefix), newPrefixWriter(stderr, prefix)
+	}
+	if b.LogsDir == "" {
+		return stdout, stderr, nil, nil
+	}
+	logFile, err := createBuildpackLog(buildpackLogPath(b.LogsDir, bp.ID, "build"))
+	if err != nil {
+		return nil, nil, nil, errors.Wrapf(err, "create log for buildpack %s", bp)
+	}
+	return io.MultiWriter(stdout, logFile), io.MultiWriter(stderr, logFile), logFile, nil
+}
+
 func (b *Builder) Build() (*BuildMetadata, error) {
 	platformDir, err := filepath.Abs(b.PlatformDir)
 	if err != nil {
@@ -81,13 +153,39 @@ func (b *Builder) Build() (*BuildMetadata, error) {
 	var slices []layers.Slice
 	var labels []Label
 
-	for _, bp := range b.Group.Group {
+	checkpoint, completed, err := b.readCheckpoint(appDir)
+	if err != nil {
+		return nil, err
+	}
+	secrets, err := ReadSecrets(b.SecretsDir)
+	if err != nil {
+		return nil, err
+	}
+	resuming := checkpoint != nil
+
+	for i, bp := range b.Group.Group {
 		bpInfo, err := bp.Lookup(b.BuildpacksDir)
 		if err != nil {
 			return nil, err
 		}
 		bpDirName := launch.EscapeID(bp.ID)
 		bpLayersDir := filepath.Join(layersDir, bpDirName)
+		if resuming {
+			if cbp, ok := checkpoint.completed(i, bp, completed.PlanDigest, bpLayersDir); ok {
+				b.Logger.Infof("Skipping buildpack %s, which completed in the checkpointed build", bp)
+				if err := cbp.restoreEnv(b.Env); err != nil {
+					return nil, err
+				}
+				plan = cbp.Plan
+				bom = append(bom, cbp.BOM...)
+				procMap.add(cbp.Processes)
+				slices = append(slices, cbp.Slices...)
+				labels = append(labels, cbp.Labels...)
+				completed.Buildpacks = append(completed.Buildpacks, cbp)
+				continue
+			}
+			resuming = false
+		}
 		bpPlanDir := filepath.Join(planDir, bpDirName)
 		if err := os.MkdirAll(bpLayersDir, 0777); err != nil {
 			return nil, err
@@ -115,9 +213,6 @@ func (b *Builder) Build() (*BuildMetadata, error) {
 			bpPlanPath,
 		)
 		cmd.Dir = appDir
-		cmd.Stdout = b.Out.Writer()
-		cmd.Stderr = b.Err.Writer()
-
 		if bpInfo.Buildpack.ClearEnv {
 			cmd.Env = b.Env.List()
 		} else {
@@ -128,14 +223,70 @@ func (b *Builder) Build() (*BuildMetadata, error) {
 		}
 		cmd.Env = append(cmd.Env, EnvBuildpackDir+"="+bpInfo.Path)
 
-		if err := cmd.Run(); err != nil {
-			return nil, NewLifecycleError(err, ErrTypeBuildpack)
+		stdout, stderr, logFile, err := b.buildpackOutput(bp)
+		if err != nil {
+			return nil, err
+		}
+		var redactors []*redactWriter
+		if len(secrets) > 0 {
+			redactedStdout, redactedStderr := newRedactWriter(stdout, secrets), newRedactWriter(stderr, secrets)
+			stdout, stderr = redactedStdout, redactedStderr
+			redactors = []*redactWriter{redactedStdout, redactedStderr}
+		}
+		cmd.Stdout, cmd.Stderr = stdout, stderr
+
+		var secretsDir string
+		if len(secrets) > 0 {
+			if secretsDir, err = secrets.stage(); err != nil {
+				if logFile != nil {
+					logFile.Close()
+				}
+				return nil, err
+			}
+			cmd.Env = append(cmd.Env, EnvSecretsDir+"="+secretsDir)
+		}
+
+		start := time.Now()
+		var strayErr error
+		runErr := runCmd(cmd, b.Timeout, func() {
+			b.Metrics.addBuild(newProcessMetrics(bp.ID, bp.Version, cmd, time.Since(start)))
+			strayErr = b.terminateStrayProcesses(cmd, bp)
+		})
+		if secretsDir != "" {
+			os.RemoveAll(secretsDir)
 		}
-		if err := setupEnv(b.Env, bpLayersDir); err != nil {
+		for _, redactor := range redactors {
+			redactor.Flush()
+		}
+		if logFile != nil {
+			logFile.Close()
+		}
+		if strayErr != nil {
+			return nil, strayErr
+		}
+		if runErr != nil {
+			errType, errContext := ErrTypeBuildpack, ""
+			if isTimeout(runErr) {
+				errType, errContext = ErrTypeBuildpackTimeout, "buildpack "+bp.String()
+			}
+			if logFile != nil {
+				errContext = fmt.Sprintf("buildpack %s (log: %s)", bp, logFile.Name())
+			}
+			if errContext != "" {
+				runErr = errors.Wrap(runErr, errContext)
+			}
+			return nil, NewLifecycleError(runErr, errType)
+		}
+		validator := tomlValidator{mode: b.TOMLValidation, logger: b.Logger}
+		if err := validator.validateLayerTOMLs(bpLayersDir); err != nil {
+			return nil, err
+		}
+		env := &envRecorder{BuildEnv: b.Env}
+		if err := setupEnv(env, bpLayersDir); err != nil {
 			return nil, err
 		}
 		var bpPlanOut BuildpackPlan
-		if _, err := toml.DecodeFile(bpPlanPath, &bpPlanOut); err != nil {
+		if err := validator.decode(bpPlanPath, &bpPlanOut, buildpackPlanSchema); err != nil {
 			return nil, err
 		}
 		var bpBOM []BOMEntry
@@ -144,9 +295,7 @@ func (b *Builder) Build() (*BuildMetadata, error) {
 
 		var launch LaunchTOML
 		tomlPath := filepath.Join(bpLayersDir, "launch.toml")
-		if _, err := toml.DecodeFile(tomlPath, &launch); os.IsNotExist(err) {
-			continue
-		} else if err != nil {
+		if err := validator.decode(tomlPath, &launch, launchTOMLSchema); err != nil && !os.IsNotExist(err) {
 			return nil, err
 		}
 		for i := range launch.Processes {
@@ -155,6 +304,24 @@ func (b *Builder) Build() (*BuildMetadata, error) {
 		procMap.add(launch.Processes)
 		slices = append(slices, launch.Slices...)
 		labels = append(labels, launch.Labels...)
+
+		if err := b.writeCheckpoint(completed, appDir, bpLayersDir, CheckpointBuildpack{
+			ID:        bp.ID,
+			Version:   bp.Version,
+			RootDirs:  env.rootDirs,
+			EnvDirs:   env.envDirs,
+			Plan:      plan,
+			BOM:       bpBOM,
+			Processes: launch.Processes,
+			Slices:    launch.Slices,
+			Labels:    launch.Labels,
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := b.checkPlanFulfillment(plan); err != nil {
+		return nil, err
 	}
 
 	if b.PlatformAPI.Compare(api.MustParse("0.4")) < 0 {
@@ -184,21 +351,109 @@ func (b *Builder) Build() (*BuildMetadata, error) {
 func (p BuildPlan) find(bp Buildpack) BuildpackPlan {
 	var out []Require
 	for _, entry := range p.Entries {
-		for _, provider := range entry.Providers {
-			if provider == bp {
-				out = append(out, entry.Requires...)
-				break
-			}
+		if entry.hasProvider(bp) {
+			out = append(out, entry.Requires...)
 		}
 	}
 	return BuildpackPlan{Entries: out}
 }
 
-// TODO: ensure at least one claimed entry of each name is provided by the BP
+func (e BuildPlanEntry) hasProvider(bp Buildpack) bool {
+	for _, provider := range e.Providers {
+		if provider.ID == bp.ID && provider.Version == bp.Version {
+			return true
+		}
+	}
+	return false
+}
+
+// readCheckpoint returns the checkpoint to resume from, if any, and an empty checkpoint for this build.
+func (b *Builder) readCheckpoint(appDir string) (*Checkpoint, *Checkpoint, error) {
+	if b.CheckpointPath == "" {
+		return nil, &Checkpoint{}, nil
+	}
+	digest, err := planDigest(b.Plan)
+	if err != nil {
+		return nil, nil, err
+	}
+	completed := &Checkpoint{PlanDigest: digest}
+	if !b.Resume {
+		return nil, completed, nil
+	}
+	checkpoint, err := ReadCheckpoint(b.CheckpointPath)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "read checkpoint")
+	}
+	if checkpoint != nil && len(checkpoint.Buildpacks) > 0 {
+		digest, err := fingerprintDirs(appDir)
+		if err != nil {
+			return nil, nil, err
+		}
+		if digest != checkpoint.Buildpacks[len(checkpoint.Buildpacks)-1].AppDigest {
+			b.Logger.Warn("Not resuming from the checkpoint, the app directory changed after the last buildpack completed")
+			return nil, completed, nil
+		}
+	}
+	return checkpoint, completed, nil
+}
+
+// writeCheckpoint adds the buildpack, which completed, to the checkpoint of this build and writes it.
+func (b *Builder) writeCheckpoint(completed *Checkpoint, appDir, bpLayersDir string, cbp CheckpointBuildpack) error {
+	if b.CheckpointPath == "" {
+		return nil
+	}
+	var err error
+	if cbp.LayersDigest, err = fingerprintDirs(bpLayersDir); err != nil {
+		return err
+	}
+	if cbp.AppDigest, err = fingerprintDirs(appDir); err != nil {
+		return err
+	}
+	completed.Buildpacks = append(completed.Buildpacks, cbp)
+	if err := WriteTOML(b.CheckpointPath, completed); err != nil {
+		return errors.Wrap(err, "write checkpoint")
+	}
+	return nil
+}
+
+// checkPlanFulfillment warns about or fails on the entries remaining in the plan after all buildpacks ran,
+// which none of their providers claimed in its buildpack plan.
+func (b *Builder) checkPlanFulfillment(plan BuildPlan) error {
+	if b.PlanFulfillment == "" || len(plan.Entries) == 0 {
+		return nil
+	}
+	var unmet []string
+	for _, entry := range plan.Entries {
+		unmet = append(unmet, entry.unmetMessage())
+	}
+	if b.PlanFulfillment == PlanFulfillmentStrict {
+		return NewLifecycleError(errors.Errorf("build plan not fulfilled: %s", strings.Join(unmet, "; ")), ErrTypeBuildpack)
+	}
+	for _, msg := range unmet {
+		b.Logger.Warnf("Warning: %s", msg)
+	}
+	return nil
+}
+
+// unmetMessage names the entry, after the requirements it groups, and its providers.
+func (e BuildPlanEntry) unmetMessage() string {
+	var name string
+	if len(e.Requires) > 0 {
+		name = e.Requires[0].Name
+	}
+	var providers []string
+	for _, bp := range e.Providers {
+		providers = append(providers, bp.noAPI().String())
+	}
+	return fmt.Sprintf("entry '%s' was not claimed by provider(s) %s", name, strings.Join(providers, ", "))
+}
+
+// filter removes the entries that the buildpack provides and claimed in its buildpack plan,
+// leaving entries of the same name provided by other buildpacks for them to claim.
 func (p BuildPlan) filter(bp Buildpack, plan BuildpackPlan) (BuildPlan, []BOMEntry) {
 	var out []BuildPlanEntry
 	for _, entry := range p.Entries {
-		if !plan.has(entry) {
+		if !entry.hasProvider(bp) || !plan.has(entry) {
 			out = append(out, entry)
 		}
 	}
@@ -8,10 +8,14 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/BurntSushi/toml"
+	alog "github.com/apex/log"
+	"github.com/apex/log/handlers/memory"
 	"github.com/golang/mock/gomock"
 	"github.com/google/go-cmp/cmp"
 	"github.com/pkg/errors"
@@ -37,6 +41,7 @@ func testBuilder(t *testing.T, when spec.G, it spec.S) {
 		mockCtrl       *gomock.Controller
 		env            *testmock.MockBuildEnv
 		stdout, stderr *bytes.Buffer
+		logHandler     *memory.Handler
 		tmpDir         string
 		platformDir    string
 		appDir         string
@@ -62,6 +67,7 @@ func testBuilder(t *testing.T, when spec.G, it spec.S) {
 		errLog := log.New(io.MultiWriter(stderr, it.Out()), "", 0)
 
 		buildpacksDir := filepath.Join("testdata", "by-id")
+		logHandler = memory.New()
 
 		builder = &lifecycle.Builder{
 			AppDir:        appDir,
@@ -76,8 +82,9 @@ func testBuilder(t *testing.T, when spec.G, it spec.S) {
 					{ID: "B", Version: "v2", API: "0.2"},
 				},
 			},
-			Out: outLog,
-			Err: errLog,
+			Out:    outLog,
+			Err:    errLog,
+			Logger: &alog.Logger{Handler: logHandler},
 		}
 	})
 
@@ -287,7 +294,313 @@ func testBuilder(t *testing.T, when spec.G, it spec.S) {
 				}
 			})
 
+			it("should prefix each line of output with the buildpack ID when configured", func() {
+				builder.PrefixOutput = true
+				if _, err := builder.Build(); err != nil {
+					t.Fatalf("Unexpected error:\n%s\n", err)
+				}
+				if s := cmp.Diff(cleanEndings(stdout.String()), "[A] build out: A@v1\n[B] build out: B@v2\n"); s != "" {
+					t.Fatalf("Unexpected stdout:\n%s\n", s)
+				}
+				if s := cmp.Diff(cleanEndings(stderr.String()), "[A] build err: A@v1\n[B] build err: B@v2\n"); s != "" {
+					t.Fatalf("Unexpected stderr:\n%s\n", s)
+				}
+			})
+
+			it("should capture the output of each buildpack in a log file when configured", func() {
+				builder.LogsDir = filepath.Join(tmpDir, "logs")
+				if _, err := builder.Build(); err != nil {
+					t.Fatalf("Unexpected error:\n%s\n", err)
+				}
+				for _, bp := range []string{"A@v1", "B@v2"} {
+					logs := cleanEndings(rdfile(t, filepath.Join(tmpDir, "logs", bp[:1], "build.log")))
+					if !strings.Contains(logs, "build out: "+bp+"\n") || !strings.Contains(logs, "build err: "+bp+"\n") {
+						t.Fatalf("Unexpected log for %s:\n%s\n", bp, logs)
+					}
+				}
+				if s := cmp.Diff(cleanEndings(stdout.String()), "build out: A@v1\nbuild out: B@v2\n"); s != "" {
+					t.Fatalf("Unexpected stdout:\n%s\n", s)
+				}
+			})
+
+			it("should provide secrets only as files in a private directory", func() {
+				builder.SecretsDir = filepath.Join(tmpDir, "secrets")
+				mkdir(t, builder.SecretsDir)
+				mkfile(t, "some-secret-value\n", filepath.Join(builder.SecretsDir, "SOME_TOKEN"))
+				if _, err := builder.Build(); err != nil {
+					t.Fatalf("Unexpected error:\n%s\n", err)
+				}
+				for _, bp := range []string{"A-v1", "B-v2"} {
+					if s := cmp.Diff(rdfile(t, filepath.Join(appDir, "build-secrets-"+bp, "SOME_TOKEN")), "some-secret-value"); s != "" {
+						t.Fatalf("Unexpected secret:\n%s\n", s)
+					}
+					if _, err := os.Stat(rdfile(t, filepath.Join(appDir, "build-secrets-dir-"+bp))); !os.IsNotExist(err) {
+						t.Fatalf("Expected secrets dir to be removed after the build: %v", err)
+					}
+					if _, err := os.Stat(filepath.Join(appDir, "build-env-"+bp, "SOME_TOKEN")); !os.IsNotExist(err) {
+						t.Fatalf("Expected secret not to be in the build env: %v", err)
+					}
+				}
+			})
+
+			it("should redact secret values in buildpack output", func() {
+				builder.SecretsDir = filepath.Join(tmpDir, "secrets")
+				mkdir(t, builder.SecretsDir)
+				mkfile(t, "some-secret-value", filepath.Join(builder.SecretsDir, "SOME_TOKEN"))
+				mkfile(t, "", filepath.Join(appDir, "build-print-secrets"))
+				builder.LogsDir = filepath.Join(tmpDir, "logs")
+				if _, err := builder.Build(); err != nil {
+					t.Fatalf("Unexpected error:\n%s\n", err)
+				}
+				if s := cmp.Diff(cleanEndings(stdout.String()), "build out: A@v1\n[redacted]\nbuild out: B@v2\n[redacted]\n"); s != "" {
+					t.Fatalf("Unexpected stdout:\n%s\n", s)
+				}
+				if logs := rdfile(t, filepath.Join(tmpDir, "logs", "A", "build.log")); strings.Contains(logs, "some-secret-value") {
+					t.Fatalf("Unexpected secret in log:\n%s\n", logs)
+				}
+			})
+
+			it("should terminate processes left running by a buildpack", func() {
+				if runtime.GOOS != "linux" {
+					t.Skip("stray processes are only listed on linux")
+				}
+				mkfile(t, "echo -n $$ > background-pid-A-v1; exec sleep 60", filepath.Join(appDir, "build-background-A-v1"))
+
+				if _, err := builder.Build(); err != nil {
+					t.Fatalf("Unexpected error:\n%s\n", err)
+				}
+
+				pid := rdfile(t, filepath.Join(appDir, "background-pid-A-v1"))
+				if s := allLogs(logHandler); !strings.Contains(s, "Buildpack A@v1 left processes running after it exited, terminated: "+pid+" (") {
+					t.Fatalf("Unexpected log:\n%s\n", s)
+				}
+				testProcessExited(t, pid)
+			})
+
+			it("should kill processes that ignore SIGTERM after the grace period", func() {
+				if runtime.GOOS != "linux" {
+					t.Skip("stray processes are only listed on linux")
+				}
+				mkfile(t, "trap '' TERM; echo -n $$ > background-pid-A-v1; exec sleep 60", filepath.Join(appDir, "build-background-A-v1"))
+				builder.GracePeriod = 100 * time.Millisecond
+
+				start := time.Now()
+				if _, err := builder.Build(); err != nil {
+					t.Fatalf("Unexpected error:\n%s\n", err)
+				}
+				if elapsed := time.Since(start); elapsed > 30*time.Second {
+					t.Fatalf("Expected stray process to be killed after the grace period, took %s", elapsed)
+				}
+
+				testProcessExited(t, rdfile(t, filepath.Join(appDir, "background-pid-A-v1")))
+			})
+
+			it("should record metrics for each build execution", func() {
+				builder.Metrics = &lifecycle.Metrics{}
+				if _, err := builder.Build(); err != nil {
+					t.Fatalf("Unexpected error:\n%s\n", err)
+				}
+
+				metrics := builder.Metrics.Build
+				if len(metrics) != 2 || metrics[0].ID != "A" || metrics[0].Version != "v1" || metrics[1].ID != "B" || metrics[1].Version != "v2" {
+					t.Fatalf("Unexpected metrics:\n%+v\n", metrics)
+				}
+				for _, m := range metrics {
+					if m.ExitCode != 0 || m.WallTime <= 0 || m.UserTime+m.SystemTime <= 0 {
+						t.Fatalf("Unexpected metrics for %s@%s:\n%+v\n", m.ID, m.Version, m)
+					}
+					if runtime.GOOS == "linux" && m.MaxRSS <= 0 {
+						t.Fatalf("Expected peak RSS for %s@%s:\n%+v\n", m.ID, m.Version, m)
+					}
+				}
+			})
+
+			it("should warn about build plan entries not claimed by their providers in warn mode", func() {
+				builder.PlanFulfillment = lifecycle.PlanFulfillmentWarn
+				builder.Plan = lifecycle.BuildPlan{
+					Entries: []lifecycle.BuildPlanEntry{
+						{
+							Providers: []lifecycle.Buildpack{{ID: "A", Version: "v1"}},
+							Requires:  []lifecycle.Require{{Name: "dep1"}},
+						},
+						{
+							Providers: []lifecycle.Buildpack{{ID: "A", Version: "v1"}, {ID: "B", Version: "v2"}},
+							Requires:  []lifecycle.Require{{Name: "dep2"}},
+						},
+					},
+				}
+				mkfile(t, "", filepath.Join(appDir, "build-plan-out-A-v1.toml"))
+
+				if _, err := builder.Build(); err != nil {
+					t.Fatalf("Unexpected error:\n%s\n", err)
+				}
+				if s := allLogs(logHandler); !strings.Contains(s, "Warning: entry 'dep1' was not claimed by provider(s) A@v1\n") ||
+					strings.Contains(s, "'dep2'") {
+					t.Fatalf("Unexpected log:\n%s\n", s)
+				}
+			})
+
+			it("should only count build plan entries as claimed by their providers", func() {
+				builder.PlanFulfillment = lifecycle.PlanFulfillmentWarn
+				builder.Plan = lifecycle.BuildPlan{
+					Entries: []lifecycle.BuildPlanEntry{
+						{
+							Providers: []lifecycle.Buildpack{{ID: "A", Version: "v1"}},
+							Requires:  []lifecycle.Require{{Name: "dep1"}},
+						},
+						{
+							Providers: []lifecycle.Buildpack{{ID: "B", Version: "v2"}},
+							Requires:  []lifecycle.Require{{Name: "dep1"}},
+						},
+					},
+				}
+				mkfile(t, "[[entries]]\n"+`name = "dep1"`+"\n", filepath.Join(appDir, "build-plan-out-A-v1.toml"))
+				mkfile(t, "", filepath.Join(appDir, "build-plan-out-B-v2.toml"))
+
+				if _, err := builder.Build(); err != nil {
+					t.Fatalf("Unexpected error:\n%s\n", err)
+				}
+				if s := allLogs(logHandler); !strings.Contains(s, "Warning: entry 'dep1' was not claimed by provider(s) B@v2\n") ||
+					strings.Contains(s, "provider(s) A@v1") {
+					t.Fatalf("Unexpected log:\n%s\n", s)
+				}
+			})
+
+			it("should warn about unknown keys in buildpack TOML files in warn mode", func() {
+				builder.TOMLValidation = lifecycle.TOMLValidationWarn
+				mkfile(t, "[[process]]\ntype = \"web\"\ncommand = \"run\"\n",
+					filepath.Join(appDir, "launch-A-v1.toml"),
+				)
+				mkdir(t, filepath.Join(appDir, "layers-A-v1"))
+				mkfile(t, "lauch = true\n[metadata]\nsome-key = \"some-value\"\n",
+					filepath.Join(appDir, "layers-A-v1", "layer.toml"),
+				)
+
+				if _, err := builder.Build(); err != nil {
+					t.Fatalf("Unexpected error:\n%s\n", err)
+				}
+				s := allLogs(logHandler)
+				for _, warning := range []string{
+					"Warning: " + filepath.Join(layersDir, "A", "launch.toml") + ": unknown key 'process'\n",
+					"Warning: " + filepath.Join(layersDir, "A", "layer.toml") + ": unknown key 'lauch'\n",
+				} {
+					if !strings.Contains(s, warning) {
+						t.Fatalf("Expected warning %q in log:\n%s\n", warning, s)
+					}
+				}
+				if strings.Contains(s, "some-key") || strings.Contains(s, "process.type") {
+					t.Fatalf("Unexpected warning:\n%s\n", s)
+				}
+			})
+
+			it("should only warn about layer TOML values with the wrong type by default", func() {
+				mkdir(t, filepath.Join(appDir, "layers-A-v1"))
+				mkfile(t, "build = \"yes\"\n", filepath.Join(appDir, "layers-A-v1", "layer.toml"))
+
+				if _, err := builder.Build(); err != nil {
+					t.Fatalf("Unexpected error:\n%s\n", err)
+				}
+				warning := "Warning: invalid " + filepath.Join(layersDir, "A", "layer.toml") + ": key 'build' must be a boolean\n"
+				if s := allLogs(logHandler); !strings.Contains(s, warning) {
+					t.Fatalf("Expected warning %q in log:\n%s\n", warning, s)
+				}
+			})
+
+			when("checkpoints are written", func() {
+				var checkpointPath string
+
+				it.Before(func() {
+					checkpointPath = filepath.Join(tmpDir, "checkpoint.toml")
+					builder.CheckpointPath = checkpointPath
+					builder.Plan = lifecycle.BuildPlan{
+						Entries: []lifecycle.BuildPlanEntry{{
+							Providers: []lifecycle.Buildpack{{ID: "A", Version: "v1"}},
+							Requires:  []lifecycle.Require{{Name: "dep1", Version: "v1"}},
+						}},
+					}
+					mkdir(t, filepath.Join(appDir, "layers-A-v1", "layer1"))
+					mkfile(t, "build = true", filepath.Join(appDir, "layers-A-v1", "layer1.toml"))
+					mkfile(t, "[[processes]]\ntype = \"web\"\ncommand = \"run\"\n",
+						filepath.Join(appDir, "launch-A-v1.toml"),
+					)
+					env.EXPECT().AddRootDir(filepath.Join(layersDir, "A", "layer1")).Times(2)
+					env.EXPECT().AddEnvDir(filepath.Join(layersDir, "A", "layer1", "env")).Times(2)
+					env.EXPECT().AddEnvDir(filepath.Join(layersDir, "A", "layer1", "env.build")).Times(2)
+				})
+
+				it("should resume after the last buildpack that completed", func() {
+					first, err := builder.Build()
+					if err != nil {
+						t.Fatalf("Unexpected error:\n%s\n", err)
+					}
+					checkpoint, err := lifecycle.ReadCheckpoint(checkpointPath)
+					if err != nil {
+						t.Fatalf("Unexpected error:\n%s\n", err)
+					}
+					if len(checkpoint.Buildpacks) != 2 || checkpoint.Buildpacks[0].ID != "A" || checkpoint.Buildpacks[1].ID != "B" {
+						t.Fatalf("Unexpected checkpoint:\n%+v\n", checkpoint)
+					}
+
+					// as if B failed without changing the app directory
+					checkpoint.Buildpacks[0].AppDigest = checkpoint.Buildpacks[1].AppDigest
+					checkpoint.Buildpacks = checkpoint.Buildpacks[:1]
+					if err := lifecycle.WriteTOML(checkpointPath, checkpoint); err != nil {
+						t.Fatalf("Unexpected error:\n%s\n", err)
+					}
+					env.EXPECT().WithPlatform(platformDir).Return(append(os.Environ(), "TEST_ENV=Bv2"), nil)
+					builder.Resume = true
+
+					second, err := builder.Build()
+					if err != nil {
+						t.Fatalf("Unexpected error:\n%s\n", err)
+					}
+					if s := allLogs(logHandler); !strings.Contains(s, "Skipping buildpack A@v1, which completed in the checkpointed build\n") {
+						t.Fatalf("Expected buildpack A@v1 to be skipped, got logs:\n%s\n", s)
+					}
+					if s := cmp.Diff(second, first); s != "" {
+						t.Fatalf("Unexpected metadata:\n%s\n", s)
+					}
+				})
+
+				it("should rerun buildpacks whose layers changed since the checkpoint", func() {
+					if _, err := builder.Build(); err != nil {
+						t.Fatalf("Unexpected error:\n%s\n", err)
+					}
+					mkfile(t, "changed", filepath.Join(layersDir, "A", "layer1", "some-file"))
+					env.EXPECT().WithPlatform(platformDir).Return(append(os.Environ(), "TEST_ENV=Av1"), nil)
+					env.EXPECT().WithPlatform(platformDir).Return(append(os.Environ(), "TEST_ENV=Bv2"), nil)
+					builder.Resume = true
+
+					if _, err := builder.Build(); err != nil {
+						t.Fatalf("Unexpected error:\n%s\n", err)
+					}
+					if s := allLogs(logHandler); strings.Contains(s, "Skipping buildpack") || strings.Contains(s, "Not resuming") {
+						t.Fatalf("Expected buildpack A@v1 to run again as its layers changed, got logs:\n%s\n", s)
+					}
+				})
+
+				it("should not resume if the app directory changed since the checkpoint", func() {
+					if _, err := builder.Build(); err != nil {
+						t.Fatalf("Unexpected error:\n%s\n", err)
+					}
+					mkfile(t, "changed", filepath.Join(appDir, "some-source-file"))
+					env.EXPECT().WithPlatform(platformDir).Return(append(os.Environ(), "TEST_ENV=Av1"), nil)
+					env.EXPECT().WithPlatform(platformDir).Return(append(os.Environ(), "TEST_ENV=Bv2"), nil)
+					builder.Resume = true
+
+					if _, err := builder.Build(); err != nil {
+						t.Fatalf("Unexpected error:\n%s\n", err)
+					}
+					s := allLogs(logHandler)
+					if !strings.Contains(s, "Not resuming from the checkpoint, the app directory changed after the last buildpack completed\n") ||
+						strings.Contains(s, "Skipping buildpack") {
+						t.Fatalf("Expected all buildpacks to run again, got logs:\n%s\n", s)
+					}
+				})
+			})
+
 			it("should provide a subset of the build plan to each buildpack", func() {
+
 				builder.Plan = lifecycle.BuildPlan{
 					Entries: []lifecycle.BuildPlanEntry{
 						{
@@ -545,6 +858,101 @@ func testBuilder(t *testing.T, when spec.G, it spec.S) {
 				}
 			})
 
+			it("should error when the command times out", func() {
+				env.EXPECT().WithPlatform(platformDir).Return(append(os.Environ(), "TEST_ENV=Av1"), nil)
+				mkfile(t, "60", filepath.Join(appDir, "build-sleep-A-v1"))
+				builder.Timeout = 100 * time.Millisecond
+				start := time.Now()
+				_, err := builder.Build()
+				if err, ok := err.(*lifecycle.Error); !ok || err.Type != lifecycle.ErrTypeBuildpackTimeout {
+					t.Fatalf("Incorrect error: %s\n", err)
+				}
+				if s := cmp.Diff(err.Error(), "buildpack A@v1: timed out after 100ms"); s != "" {
+					t.Fatalf("Unexpected error message:\n%s\n", s)
+				}
+				if elapsed := time.Since(start); elapsed > 30*time.Second {
+					t.Fatalf("Expected buildpack process group to be killed, took %s", elapsed)
+				}
+			})
+
+			it("should error when buildpack TOML files are missing required keys in strict mode", func() {
+				env.EXPECT().WithPlatform(platformDir).Return(append(os.Environ(), "TEST_ENV=Av1"), nil)
+				builder.TOMLValidation = lifecycle.TOMLValidationStrict
+				mkfile(t, "[[processes]]\ntype = \"web\"\ncommand = \"run\"\n[[processes]]\ntype = \"worker\"\n",
+					filepath.Join(appDir, "launch-A-v1.toml"),
+				)
+
+				_, err := builder.Build()
+				if s := cmp.Diff(err.Error(),
+					"invalid "+filepath.Join(layersDir, "A", "launch.toml")+": missing required key 'processes[1].command'",
+				); s != "" {
+					t.Fatalf("Unexpected error message:\n%s\n", s)
+				}
+			})
+
+			it("should error with the file and key when buildpack TOML values have the wrong type in strict mode", func() {
+				env.EXPECT().WithPlatform(platformDir).Return(append(os.Environ(), "TEST_ENV=Av1"), nil)
+				builder.TOMLValidation = lifecycle.TOMLValidationStrict
+				mkdir(t, filepath.Join(appDir, "layers-A-v1"))
+				mkfile(t, "build = \"yes\"\n", filepath.Join(appDir, "layers-A-v1", "layer.toml"))
+
+				_, err := builder.Build()
+				if s := cmp.Diff(err.Error(),
+					"invalid "+filepath.Join(layersDir, "A", "layer.toml")+": key 'build' must be a boolean",
+				); s != "" {
+					t.Fatalf("Unexpected error message:\n%s\n", s)
+				}
+			})
+
+			it("should error when build plan entries are not claimed by their providers in strict mode", func() {
+				env.EXPECT().WithPlatform(platformDir).Return(append(os.Environ(), "TEST_ENV=Av1"), nil)
+				env.EXPECT().WithPlatform(platformDir).Return(append(os.Environ(), "TEST_ENV=Bv2"), nil)
+				builder.PlanFulfillment = lifecycle.PlanFulfillmentStrict
+				builder.Plan = lifecycle.BuildPlan{
+					Entries: []lifecycle.BuildPlanEntry{
+						{
+							Providers: []lifecycle.Buildpack{{ID: "A", Version: "v1"}, {ID: "B", Version: "v2"}},
+							Requires:  []lifecycle.Require{{Name: "dep1"}},
+						},
+						{
+							Providers: []lifecycle.Buildpack{{ID: "B", Version: "v2"}},
+							Requires:  []lifecycle.Require{{Name: "dep2"}, {Name: "dep2", Version: "v1"}},
+						},
+					},
+				}
+				mkfile(t, "", filepath.Join(appDir, "build-plan-out-A-v1.toml"), filepath.Join(appDir, "build-plan-out-B-v2.toml"))
+
+				_, err := builder.Build()
+				if err, ok := err.(*lifecycle.Error); !ok || err.Type != lifecycle.ErrTypeBuildpack {
+					t.Fatalf("Incorrect error: %s\n", err)
+				}
+				if s := cmp.Diff(err.Error(), "build plan not fulfilled: "+
+					"entry 'dep1' was not claimed by provider(s) A@v1, B@v2; "+
+					"entry 'dep2' was not claimed by provider(s) B@v2",
+				); s != "" {
+					t.Fatalf("Unexpected error message:\n%s\n", s)
+				}
+			})
+
+			it("should refer to the log file when the command fails and its output is captured", func() {
+				env.EXPECT().WithPlatform(platformDir).Return(append(os.Environ(), "TEST_ENV=Av1"), nil)
+				if err := os.RemoveAll(platformDir); err != nil {
+					t.Fatalf("Error: %s\n", err)
+				}
+				builder.LogsDir = filepath.Join(tmpDir, "logs")
+				_, err := builder.Build()
+				if err, ok := err.(*lifecycle.Error); !ok || err.Type != lifecycle.ErrTypeBuildpack {
+					t.Fatalf("Incorrect error: %s\n", err)
+				}
+				logPath := filepath.Join(tmpDir, "logs", "A", "build.log")
+				if !strings.HasPrefix(err.Error(), "buildpack A@v1 (log: "+logPath+"): ") {
+					t.Fatalf("Unexpected error message: %s\n", err)
+				}
+				if logs := rdfile(t, logPath); !strings.Contains(logs, "build out: A@v1") {
+					t.Fatalf("Unexpected log:\n%s\n", logs)
+				}
+			})
+
 			when("modifying the env fails", func() {
 				var appendErr error
 
@@ -712,6 +1120,20 @@ func testPlan(t *testing.T, plan []lifecycle.Require, paths ...string) {
 	}
 }
 
+// testProcessExited fails if the process is still running; zombies waiting to be reaped by init count as exited
+func testProcessExited(t *testing.T, pid string) {
+	t.Helper()
+	stat, err := ioutil.ReadFile(filepath.Join("/proc", pid, "stat"))
+	if os.IsNotExist(err) {
+		return
+	} else if err != nil {
+		t.Fatalf("Error: %s\n", err)
+	}
+	if fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:])); fields[0] != "Z" {
+		t.Fatalf("Expected process %s to have exited, state: %s", pid, fields[0])
+	}
+}
+
 func each(it spec.S, befores []func(), text string, f func()) {
 	for i := range befores {
 		before := befores[i]
@@ -1,9 +1,13 @@
 package lifecycle
 
 import (
+	"io/ioutil"
+	"os"
 	"path/filepath"
 
 	"github.com/BurntSushi/toml"
+	"github.com/Masterminds/semver/v3"
+	"github.com/pkg/errors"
 
 	"github.com/buildpacks/lifecycle/launch"
 )
@@ -29,14 +33,103 @@ func (bp Buildpack) noAPI() Buildpack {
 	return bp
 }
 
-func (bp Buildpack) Lookup(buildpacksDir string) (*BuildpackTOML, error) {
-	bpTOML := BuildpackTOML{}
-	bpPath, err := filepath.Abs(filepath.Join(buildpacksDir, launch.EscapeID(bp.ID), bp.Version))
+// Lookup finds the buildpack in buildpacksPath, a list of buildpacks directories and buildpackage
+// archives separated by os.PathListSeparator. Buildpackages are unpacked to a scratch directory the
+// first time they are searched. If the version of the buildpack names a version directory, the first
+// entry with that directory is used. Otherwise the version is treated as a semver constraint, or as any
+// version if it is empty or "latest", and the highest matching version in any entry is used, from the
+// first entry with that version. If the buildpack is not found, the first error other than the buildpack
+// not being in an entry is returned, if any.
+func (bp Buildpack) Lookup(buildpacksPath string) (*BuildpackTOML, error) {
+	var (
+		lookupErr  error
+		resolved   string
+		highest    *semver.Version
+		constraint *semver.Constraints
+	)
+	setErr := func(err error) {
+		if lookupErr == nil || (os.IsNotExist(lookupErr) && !os.IsNotExist(err)) {
+			lookupErr = err
+		}
+	}
+	for _, entry := range filepath.SplitList(buildpacksPath) {
+		buildpacksDir, err := bp.entryDir(entry)
+		if err != nil {
+			setErr(err)
+			continue
+		}
+		bpDir := filepath.Join(buildpacksDir, launch.EscapeID(bp.ID))
+		if bp.Version != "" {
+			if _, err := os.Stat(filepath.Join(bpDir, bp.Version)); err == nil {
+				return readBuildpackTOML(filepath.Join(bpDir, bp.Version))
+			}
+		}
+		if constraint == nil {
+			if constraint, err = bp.versionConstraint(); err != nil {
+				setErr(err)
+				continue
+			}
+		}
+		fis, err := ioutil.ReadDir(bpDir)
+		if err != nil {
+			setErr(err)
+			continue
+		}
+		for _, fi := range fis {
+			if !fi.IsDir() {
+				continue
+			}
+			v, err := semver.NewVersion(fi.Name())
+			if err != nil || !constraint.Check(v) {
+				continue
+			}
+			if highest == nil || v.GreaterThan(highest) {
+				highest = v
+				resolved = filepath.Join(bpDir, fi.Name())
+			}
+		}
+	}
+	if resolved != "" {
+		return readBuildpackTOML(resolved)
+	}
+	if lookupErr == nil {
+		if buildpacksPath == "" {
+			return nil, errors.Errorf("no buildpacks directory provided to find buildpack '%s'", bp)
+		}
+		return nil, errors.Errorf("no version of buildpack '%s' matches '%s'", bp.ID, bp.Version)
+	}
+	return nil, lookupErr
+}
+
+// entryDir returns the buildpacks directory of an entry of the buildpacks path, unpacking it if it is a buildpackage.
+func (bp Buildpack) entryDir(entry string) (string, error) {
+	if fi, err := os.Stat(entry); err == nil && fi.Mode().IsRegular() {
+		return unpackBuildpackage(entry)
+	}
+	return entry, nil
+}
+
+// versionConstraint returns the version of the buildpack as a semver constraint.
+func (bp Buildpack) versionConstraint() (*semver.Constraints, error) {
+	constraint := bp.Version
+	if constraint == "" || constraint == "latest" {
+		constraint = "*"
+	}
+	c, err := semver.NewConstraint(constraint)
+	if err != nil {
+		// not a constraint, so the buildpack can only be found by its version directory
+		return nil, &os.PathError{Op: "lookup", Path: filepath.Join(launch.EscapeID(bp.ID), bp.Version), Err: os.ErrNotExist}
+	}
+	return c, nil
+}
+
+func readBuildpackTOML(bpPath string) (*BuildpackTOML, error) {
+	bpPath, err := filepath.Abs(bpPath)
 	if err != nil {
 		return nil, err
 	}
-	tomlPath := filepath.Join(bpPath, "buildpack.toml")
-	if _, err := toml.DecodeFile(tomlPath, &bpTOML); err != nil {
+	bpTOML := BuildpackTOML{}
+	if _, err := toml.DecodeFile(filepath.Join(bpPath, "buildpack.toml"), &bpTOML); err != nil {
 		return nil, err
 	}
 	bpTOML.Path = bpPath
@@ -44,17 +137,19 @@ func (bp Buildpack) Lookup(buildpacksDir string) (*BuildpackTOML, error) {
 }
 
 type BuildpackTOML struct {
-	API       string         `toml:"api"`
-	Buildpack BuildpackInfo  `toml:"buildpack"`
-	Order     BuildpackOrder `toml:"order"`
-	Path      string         `toml:"-"`
+	API       string           `toml:"api"`
+	Buildpack BuildpackInfo    `toml:"buildpack"`
+	Order     BuildpackOrder   `toml:"order"`
+	Stacks    []BuildpackStack `toml:"stacks"`
+	Path      string           `toml:"-"`
 }
 
 type BuildpackInfo struct {
-	ID       string `toml:"id"`
-	Version  string `toml:"version"`
-	Name     string `toml:"name"`
-	ClearEnv bool   `toml:"clear-env,omitempty"`
+	ID              string `toml:"id"`
+	Version         string `toml:"version"`
+	Name            string `toml:"name"`
+	ClearEnv        bool   `toml:"clear-env,omitempty"`
+	SkipDetectCache bool   `toml:"skip-detect-cache,omitempty"`
 }
 
 func (bp BuildpackTOML) String() string {
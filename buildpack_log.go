@@ -0,0 +1,82 @@
+package lifecycle
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/buildpacks/lifecycle/launch"
+)
+
+// buildpackLogPath returns the path of the log file that captures the output of a buildpack executable,
+// which is named after the executable, e.g. build.log.
+func buildpackLogPath(logsDir, bpID, name string) string {
+	return filepath.Join(logsDir, launch.EscapeID(bpID), name+".log")
+}
+
+// createBuildpackLog creates the log file at path, replacing any log from an earlier build.
+func createBuildpackLog(path string) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return nil, err
+	}
+	return os.Create(path)
+}
+
+// detectLogs writes the detect output of buildpacks to their log files. The first output written to a log
+// replaces any log from an earlier detection and later output is appended to it, as more than one version
+// of a buildpack may be detected.
+type detectLogs struct {
+	mu      sync.Mutex
+	written map[string]bool
+}
+
+func (l *detectLogs) write(path string, output []byte) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return err
+	}
+	flag := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if !l.written[path] {
+		flag |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(path, flag, 0666)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	l.written[path] = true
+	_, err = f.Write(output)
+	return err
+}
+
+// prefixWriter writes prefix at the start of every line written to w.
+type prefixWriter struct {
+	w       io.Writer
+	prefix  []byte
+	midLine bool
+}
+
+func newPrefixWriter(w io.Writer, prefix string) *prefixWriter {
+	return &prefixWriter{w: w, prefix: []byte(prefix)}
+}
+
+func (p *prefixWriter) Write(data []byte) (int, error) {
+	var buf bytes.Buffer
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		if !p.midLine {
+			buf.Write(p.prefix)
+		}
+		buf.Write(line)
+		p.midLine = line[len(line)-1] != '\n'
+	}
+	if _, err := p.w.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
@@ -0,0 +1,146 @@
+package lifecycle
+
+import (
+	"archive/tar"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/pkg/errors"
+
+	"github.com/buildpacks/lifecycle/archive"
+	"github.com/buildpacks/lifecycle/layers"
+)
+
+// buildpackages are the buildpackage archives unpacked by this process
+var buildpackages struct {
+	sync.Mutex
+	scratchDir string
+	unpacked   map[buildpackageFile]string
+}
+
+// buildpackageFile identifies a buildpackage archive, which is unpacked again if it changes
+type buildpackageFile struct {
+	path    string
+	modTime time.Time
+	size    int64
+}
+
+// unpackBuildpackage extracts the layers of the buildpackage archive at path into a scratch directory
+// and returns the buildpacks directory within it. Each archive is only unpacked once per process unless it changes.
+// The scratch directory is removed by RemoveUnpackedBuildpackages.
+func unpackBuildpackage(path string) (string, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	file := buildpackageFile{path: path, modTime: fi.ModTime(), size: fi.Size()}
+
+	buildpackages.Lock()
+	defer buildpackages.Unlock()
+	if bpsDir, ok := buildpackages.unpacked[file]; ok {
+		return bpsDir, nil
+	}
+	if buildpackages.scratchDir == "" {
+		if buildpackages.scratchDir, err = ioutil.TempDir("", "cnb-buildpackages."); err != nil {
+			return "", err
+		}
+		buildpackages.unpacked = map[buildpackageFile]string{}
+	}
+
+	unpackedDir, err := ioutil.TempDir(buildpackages.scratchDir, "buildpackage.")
+	if err != nil {
+		return "", err
+	}
+	bpsDir, err := unpackBuildpackageTo(path, unpackedDir)
+	if err != nil {
+		os.RemoveAll(unpackedDir)
+		return "", err
+	}
+	buildpackages.unpacked[file] = bpsDir
+	return bpsDir, nil
+}
+
+func unpackBuildpackageTo(path, unpackedDir string) (string, error) {
+	layoutDir := filepath.Join(unpackedDir, "layout")
+	if err := extractTar(path, layoutDir); err != nil {
+		return "", errors.Wrapf(err, "extract buildpackage '%s'", path)
+	}
+	contentsDir := filepath.Join(unpackedDir, "contents")
+	if err := extractLayoutLayers(layoutDir, contentsDir); err != nil {
+		return "", errors.Wrapf(err, "extract buildpackage '%s'", path)
+	}
+	if err := os.RemoveAll(layoutDir); err != nil {
+		return "", err
+	}
+	return filepath.Join(contentsDir, "cnb", "buildpacks"), nil
+}
+
+// RemoveUnpackedBuildpackages removes the buildpackage archives unpacked while looking up buildpacks.
+func RemoveUnpackedBuildpackages() error {
+	buildpackages.Lock()
+	defer buildpackages.Unlock()
+	if buildpackages.scratchDir == "" {
+		return nil
+	}
+	err := os.RemoveAll(buildpackages.scratchDir)
+	buildpackages.scratchDir = ""
+	buildpackages.unpacked = nil
+	return err
+}
+
+func extractTar(path, dest string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	tr := archive.NewNormalizingTarReader(tar.NewReader(f))
+	tr.PrependDir(dest)
+	return archive.Extract(tr)
+}
+
+func extractLayoutLayers(layoutDir, dest string) error {
+	index, err := layout.ImageIndexFromPath(layoutDir)
+	if err != nil {
+		return err
+	}
+	manifest, err := index.IndexManifest()
+	if err != nil {
+		return err
+	}
+	if len(manifest.Manifests) == 0 {
+		return errors.New("no image manifest found in OCI layout")
+	}
+	image, err := index.Image(manifest.Manifests[0].Digest)
+	if err != nil {
+		return err
+	}
+	imageLayers, err := image.Layers()
+	if err != nil {
+		return err
+	}
+	for _, layer := range imageLayers {
+		if err := extractLayer(layer.Uncompressed, dest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func extractLayer(open func() (io.ReadCloser, error), dest string) error {
+	rc, err := open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return layers.Extract(rc, dest)
+}
@@ -10,7 +10,11 @@ func (e *Exporter) Cache(layersDir string, cacheStore Cache) error {
 	if err != nil {
 		return errors.Wrap(err, "metadata for previous cache")
 	}
-	meta := CacheMetadata{}
+	meta := CacheMetadata{Detect: e.DetectCache}
+	if !e.DetectCacheEnabled {
+		// keep detect results cached by a previous build until detection runs with the cache again
+		meta.Detect = origMeta.Detect
+	}
 
 	for _, bp := range e.Buildpacks {
 		bpDir, err := readBuildpackLayersDir(layersDir, bp)
@@ -111,6 +111,38 @@ func testCache(t *testing.T, when spec.G, it spec.S) {
 					})
 				})
 
+				it("sets detect cache metadata", func() {
+					exporter.DetectCache = []lifecycle.DetectCacheEntry{{Key: "some-key", Code: 0, Plan: "some-plan"}}
+					exporter.DetectCacheEnabled = true
+					err := exporter.Cache(layersDir, testCache)
+					h.AssertNil(t, err)
+
+					metadata, err := testCache.RetrieveMetadata()
+					h.AssertNil(t, err)
+					h.AssertEq(t, metadata.Detect, []lifecycle.DetectCacheEntry{{Key: "some-key", Code: 0, Plan: "some-plan"}})
+
+					t.Log("keeps previous detect cache metadata when detection did not use the cache")
+					exporter.DetectCache = nil
+					exporter.DetectCacheEnabled = false
+					testCache, err = cache.NewVolumeCache(cacheDir)
+					h.AssertNil(t, err)
+					h.AssertNil(t, exporter.Cache(layersDir, testCache))
+
+					metadata, err = testCache.RetrieveMetadata()
+					h.AssertNil(t, err)
+					h.AssertEq(t, metadata.Detect, []lifecycle.DetectCacheEntry{{Key: "some-key", Code: 0, Plan: "some-plan"}})
+
+					t.Log("clears previous detect cache metadata when detection used the cache without storing any results")
+					exporter.DetectCacheEnabled = true
+					testCache, err = cache.NewVolumeCache(cacheDir)
+					h.AssertNil(t, err)
+					h.AssertNil(t, exporter.Cache(layersDir, testCache))
+
+					metadata, err = testCache.RetrieveMetadata()
+					h.AssertNil(t, err)
+					h.AssertEq(t, len(metadata.Detect), 0)
+				})
+
 				it("doesn't export uncached layers", func() {
 					err := exporter.Cache(layersDir, testCache)
 					h.AssertNil(t, err)
@@ -0,0 +1,106 @@
+package lifecycle
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"os"
+
+	"github.com/BurntSushi/toml"
+
+	"github.com/buildpacks/lifecycle/launch"
+	"github.com/buildpacks/lifecycle/layers"
+)
+
+// Checkpoint records the buildpacks that completed in a build, in order, so that a later build of the
+// same group and plan can resume from the first buildpack that did not complete.
+type Checkpoint struct {
+	PlanDigest string                `toml:"plan-digest"`
+	Buildpacks []CheckpointBuildpack `toml:"buildpacks"`
+}
+
+// CheckpointBuildpack is the state a buildpack added to the build. The build env directories are
+// those the buildpack's layers added to the build env, and the plan is what remained after it ran.
+// The app digest is of the app directory after the buildpack ran, which buildpacks may also write to.
+type CheckpointBuildpack struct {
+	ID           string           `toml:"id"`
+	Version      string           `toml:"version"`
+	LayersDigest string           `toml:"layers-digest"`
+	AppDigest    string           `toml:"app-digest"`
+	RootDirs     []string         `toml:"root-dirs"`
+	EnvDirs      []string         `toml:"env-dirs"`
+	Plan         BuildPlan        `toml:"plan"`
+	BOM          []BOMEntry       `toml:"bom"`
+	Processes    []launch.Process `toml:"processes"`
+	Slices       []layers.Slice   `toml:"slices"`
+	Labels       []Label          `toml:"labels"`
+}
+
+// ReadCheckpoint returns the checkpoint at path, or nil if there is none.
+func ReadCheckpoint(path string) (*Checkpoint, error) {
+	var checkpoint Checkpoint
+	if _, err := toml.DecodeFile(path, &checkpoint); os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &checkpoint, nil
+}
+
+// completed returns the checkpoint of the buildpack at index i in the group if it completed
+// in the checkpointed build, and its layers directory has not changed since.
+func (c *Checkpoint) completed(i int, bp Buildpack, planDigest, bpLayersDir string) (CheckpointBuildpack, bool) {
+	if c == nil || c.PlanDigest != planDigest || i >= len(c.Buildpacks) {
+		return CheckpointBuildpack{}, false
+	}
+	cbp := c.Buildpacks[i]
+	if cbp.ID != bp.ID || cbp.Version != bp.Version {
+		return CheckpointBuildpack{}, false
+	}
+	digest, err := fingerprintDirs(bpLayersDir)
+	if err != nil || digest != cbp.LayersDigest {
+		return CheckpointBuildpack{}, false
+	}
+	return cbp, true
+}
+
+// planDigest returns a digest of the build plan, so that checkpoints of builds with another plan are not resumed.
+func planDigest(plan BuildPlan) (string, error) {
+	buf := &bytes.Buffer{}
+	if err := toml.NewEncoder(buf).Encode(plan); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(buf.Bytes())), nil
+}
+
+// envRecorder records the directories added to a build env, so that they can be added again when resuming.
+type envRecorder struct {
+	BuildEnv
+	rootDirs []string
+	envDirs  []string
+}
+
+func (r *envRecorder) AddRootDir(baseDir string) error {
+	r.rootDirs = append(r.rootDirs, baseDir)
+	return r.BuildEnv.AddRootDir(baseDir)
+}
+
+func (r *envRecorder) AddEnvDir(envDir string) error {
+	r.envDirs = append(r.envDirs, envDir)
+	return r.BuildEnv.AddEnvDir(envDir)
+}
+
+// restoreEnv adds the directories that the checkpointed buildpack added to the build env.
+func (cbp CheckpointBuildpack) restoreEnv(env BuildEnv) error {
+	for _, dir := range cbp.RootDirs {
+		if err := env.AddRootDir(dir); err != nil {
+			return err
+		}
+	}
+	for _, dir := range cbp.EnvDirs {
+		if err := env.AddEnvDir(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -24,6 +24,9 @@ const (
 	// CodeFailedDetectWithErrors indicated that no buildpacks detected and at least one errored
 	CodeFailedDetectWithErrors = 101
 	CodeDetectError            = 102 // CodeDetectError indicates generic detect error
+	CodeDetectOrderCycle       = 103 // CodeDetectOrderCycle indicates that a buildpack order references itself
+	// CodeFailedDetectWithTimeout indicates that no buildpacks detected and at least one timed out
+	CodeFailedDetectWithTimeout = 104
 
 	// analyze phase errors: 200-299
 	CodeAnalyzeError = 202 // CodeAnalyzeError indicates generic analyze error
@@ -34,9 +37,11 @@ const (
 	// build phase errors: 400-499
 	CodeFailedBuildWithErrors = 401 // CodeFailedBuildWithErrors indicates buildpack error during /bin/build
 	CodeBuildError            = 402 // CodeBuildError indicates generic build error
+	CodeBuildTimeout          = 403 // CodeBuildTimeout indicates that a buildpack timed out during /bin/build
 
 	// export phase errors: 500-599
-	CodeExportError = 502 // CodeExportError indicates generic export error
+	CodeExportError  = 502 // CodeExportError indicates generic export error
+	CodeFailedVerify = 503 // CodeFailedVerify indicates that the export differs from the reference it was verified against
 
 	// rebase phase errors: 600-699
 	CodeRebaseError = 602 // CodeRebaseError indicates generic rebase error
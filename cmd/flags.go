@@ -6,6 +6,7 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"time"
 )
 
 var (
@@ -13,6 +14,8 @@ var (
 	DefaultAppDir              = filepath.Join(rootDir, "workspace")
 	DefaultBuildpacksDir       = filepath.Join(rootDir, "cnb", "buildpacks")
 	DefaultDeprecationMode     = DeprecationModeWarn
+	DefaultDetectCachePath     = filepath.Join(".", "detect-cache.toml")
+	DefaultDetectReportPath    = filepath.Join(".", "detect-report.toml")
 	DefaultGroupPath           = filepath.Join(".", "group.toml")
 	DefaultLauncherPath        = filepath.Join(rootDir, "cnb", "lifecycle", "launcher"+execExt)
 	DefaultLayersDir           = filepath.Join(rootDir, "layers")
@@ -30,31 +33,54 @@ var (
 const (
 	EnvAnalyzedPath        = "CNB_ANALYZED_PATH"
 	EnvAppDir              = "CNB_APP_DIR"
+	EnvBuildTimeout        = "CNB_BUILD_TIMEOUT" // defaults to no timeout
 	EnvBuildpacksDir       = "CNB_BUILDPACKS_DIR"
+	EnvBuildSecretsDir     = "CNB_BUILD_SECRETS_DIR" // defaults to <platform>/secrets
 	EnvCacheDir            = "CNB_CACHE_DIR"
 	EnvCacheImage          = "CNB_CACHE_IMAGE"
+	EnvCheckpointPath      = "CNB_CHECKPOINT_PATH" // defaults to no checkpoints
+	EnvCreationTime        = "CNB_CREATION_TIME"   // defaults to SOURCE_DATE_EPOCH, then the commit-time in the project metadata, then 1980-01-01
 	EnvDeprecationMode     = "CNB_DEPRECATION_MODE"
+	EnvDetectCache         = "CNB_DETECT_CACHE" // defaults to false
+	EnvDetectCachePath     = "CNB_DETECT_CACHE_PATH"
+	EnvDetectReportPath    = "CNB_DETECT_REPORT_PATH"
+	EnvDetectTimeout       = "CNB_DETECT_TIMEOUT" // defaults to no timeout
+	EnvDetectWorkers       = "CNB_DETECT_WORKERS" // defaults to no limit
+	EnvExportWorkers       = "CNB_EXPORT_WORKERS" // defaults to the number of CPUs
 	EnvGID                 = "CNB_GROUP_ID"
 	EnvGroupPath           = "CNB_GROUP_PATH"
 	EnvLaunchCacheDir      = "CNB_LAUNCH_CACHE_DIR"
+	EnvLayerCreationTime   = "CNB_LAYER_CREATION_TIME" // defaults to false
 	EnvLayersDir           = "CNB_LAYERS_DIR"
+	EnvLayoutDir           = "CNB_LAYOUT_DIR" // defaults to using a registry or the daemon
 	EnvLogLevel            = "CNB_LOG_LEVEL"
+	EnvLogsDir             = "CNB_LOGS_DIR"
+	EnvMaxLayers           = "CNB_MAX_LAYERS" // defaults to no limit
+	EnvMetricsPath         = "CNB_METRICS_PATH"
 	EnvNoColor             = "CNB_NO_COLOR" // defaults to false
 	EnvOrderPath           = "CNB_ORDER_PATH"
+	EnvPlanFulfillment     = "CNB_PLAN_FULFILLMENT" // defaults to not checking plan fulfillment
 	EnvPlanPath            = "CNB_PLAN_PATH"
 	EnvPlatformAPI         = "CNB_PLATFORM_API"
 	EnvPlatformDir         = "CNB_PLATFORM_DIR"
+	EnvPrefixOutput        = "CNB_PREFIX_OUTPUT" // defaults to false
 	EnvPreviousImage       = "CNB_PREVIOUS_IMAGE"
 	EnvProcessType         = "CNB_PROCESS_TYPE"
 	EnvProjectMetadataPath = "CNB_PROJECT_METADATA_PATH"
 	EnvRegistryAuth        = "CNB_REGISTRY_AUTH"
 	EnvReportPath          = "CNB_REPORT_PATH"
+	EnvResume              = "CNB_RESUME" // defaults to false
 	EnvRunImage            = "CNB_RUN_IMAGE"
+	EnvSBOMDir             = "CNB_SBOM_DIR"            // defaults to only exporting SBOM documents in the image
 	EnvSkipLayers          = "CNB_ANALYZE_SKIP_LAYERS" // defaults to false
 	EnvSkipRestore         = "CNB_SKIP_RESTORE"        // defaults to false
+	EnvSourceDateEpoch     = "SOURCE_DATE_EPOCH"
+	EnvStackID             = "CNB_STACK_ID"
 	EnvStackPath           = "CNB_STACK_PATH"
+	EnvTOMLValidation      = "CNB_TOML_VALIDATION" // defaults to only checking value types
 	EnvUID                 = "CNB_USER_ID"
 	EnvUseDaemon           = "CNB_USE_DAEMON" // defaults to false
+	EnvVerify              = "CNB_VERIFY"     // defaults to saving the image without verifying it
 )
 
 var flagSet = flag.NewFlagSet("lifecycle", flag.ExitOnError)
@@ -68,7 +94,15 @@ func FlagAppDir(dir *string) {
 }
 
 func FlagBuildpacksDir(dir *string) {
-	flagSet.StringVar(dir, "buildpacks", EnvOrDefault(EnvBuildpacksDir, DefaultBuildpacksDir), "path to buildpacks directory")
+	flagSet.StringVar(dir, "buildpacks", EnvOrDefault(EnvBuildpacksDir, DefaultBuildpacksDir), "path to buildpacks directories or buildpackages, separated by the OS path list separator")
+}
+
+func FlagBuildTimeout(timeout *time.Duration) {
+	flagSet.DurationVar(timeout, "build-timeout", durationEnv(EnvBuildTimeout), "maximum duration of each buildpack's /bin/build")
+}
+
+func FlagBuildSecretsDir(dir *string) {
+	flagSet.StringVar(dir, "build-secrets", os.Getenv(EnvBuildSecretsDir), "path to a directory of secrets to give each buildpack's /bin/build, defaults to <platform>/secrets")
 }
 
 func FlagCacheDir(dir *string) {
@@ -79,6 +113,42 @@ func FlagCacheImage(image *string) {
 	flagSet.StringVar(image, "cache-image", os.Getenv(EnvCacheImage), "cache image tag name")
 }
 
+func FlagCheckpointPath(path *string) {
+	flagSet.StringVar(path, "checkpoint", os.Getenv(EnvCheckpointPath), "path to write a checkpoint to after each buildpack completes")
+}
+
+func FlagCreationTime(creationTime *string) {
+	flagSet.StringVar(creationTime, "creation-time", os.Getenv(EnvCreationTime), "creation time of the image, in seconds since the Unix epoch or in RFC 3339 format")
+}
+
+func FlagDetectCache(use *bool) {
+	flagSet.BoolVar(use, "detect-cache", BoolEnv(EnvDetectCache), "reuse cached detect results when the app and buildpacks are unchanged")
+}
+
+func FlagDetectCachePath(path *string) {
+	flagSet.StringVar(path, "detect-cache-path", EnvOrDefault(EnvDetectCachePath, DefaultDetectCachePath), "path to detect-cache.toml, the detect results passed from the detector to the exporter to cache")
+}
+
+func FlagDetectReportPath(path *string) {
+	flagSet.StringVar(path, "detect-report", EnvOrDefault(EnvDetectReportPath, DefaultDetectReportPath), "path to detect-report.toml")
+}
+
+func FlagDetectTimeout(timeout *time.Duration) {
+	flagSet.DurationVar(timeout, "detect-timeout", durationEnv(EnvDetectTimeout), "maximum duration of each buildpack's /bin/detect")
+}
+
+func FlagDetectWorkers(workers *int) {
+	flagSet.IntVar(workers, "detect-workers", intEnv(EnvDetectWorkers), "maximum number of concurrent /bin/detect executions")
+}
+
+func FlagExplain(explain *bool) {
+	flagSet.BoolVar(explain, "explain", false, "print the selected group and build plan, or the trial that failed in each group, without writing any files")
+}
+
+func FlagExportWorkers(workers *int) {
+	flagSet.IntVar(workers, "export-workers", intEnv(EnvExportWorkers), "maximum number of layers to tar and hash concurrently")
+}
+
 func FlagGID(gid *int) {
 	flagSet.IntVar(gid, "gid", intEnv(EnvGID), "GID of user's group in the stack's build and run images")
 }
@@ -95,18 +165,42 @@ func FlagLauncherPath(path *string) {
 	flagSet.StringVar(path, "launcher", DefaultLauncherPath, "path to launcher binary")
 }
 
+func FlagLayerCreationTime(use *bool) {
+	flagSet.BoolVar(use, "layer-creation-time", BoolEnv(EnvLayerCreationTime), "set the modification time of files in layers to the creation time of the image")
+}
+
 func FlagLayersDir(dir *string) {
 	flagSet.StringVar(dir, "layers", EnvOrDefault(EnvLayersDir, DefaultLayersDir), "path to layers directory")
 }
 
+func FlagLayoutDir(dir *string) {
+	flagSet.StringVar(dir, "layout", os.Getenv(EnvLayoutDir), "path to OCI image layout directory to read and write images in")
+}
+
 func FlagNoColor(skip *bool) {
 	flagSet.BoolVar(skip, "no-color", BoolEnv(EnvNoColor), "disable color output")
 }
 
+func FlagLogsDir(dir *string) {
+	flagSet.StringVar(dir, "logs-dir", os.Getenv(EnvLogsDir), "path to directory to capture the output of each buildpack in")
+}
+
+func FlagMaxLayers(max *int) {
+	flagSet.IntVar(max, "max-layers", intEnv(EnvMaxLayers), "maximum number of layers to add on top of the run image, not counting its own layers, merging launch layers to stay within it")
+}
+
+func FlagMetricsPath(path *string) {
+	flagSet.StringVar(path, "metrics", os.Getenv(EnvMetricsPath), "path to write metrics.toml to, metrics are not recorded if empty")
+}
+
 func FlagOrderPath(path *string) {
 	flagSet.StringVar(path, "order", EnvOrDefault(EnvOrderPath, DefaultOrderPath), "path to order.toml")
 }
 
+func FlagPlanFulfillment(mode *string) {
+	flagSet.StringVar(mode, "plan-fulfillment", os.Getenv(EnvPlanFulfillment), "check that buildpacks claim their build plan entries, either 'warn' or 'strict'")
+}
+
 func FlagPlanPath(path *string) {
 	flagSet.StringVar(path, "plan", EnvOrDefault(EnvPlanPath, DefaultPlanPath), "path to plan.toml")
 }
@@ -115,6 +209,10 @@ func FlagPlatformDir(dir *string) {
 	flagSet.StringVar(dir, "platform", EnvOrDefault(EnvPlatformDir, DefaultPlatformDir), "path to platform directory")
 }
 
+func FlagPrefixOutput(prefix *bool) {
+	flagSet.BoolVar(prefix, "prefix-output", BoolEnv(EnvPrefixOutput), "prefix each line of buildpack output with the buildpack ID")
+}
+
 func FlagPreviousImage(image *string) {
 	flagSet.StringVar(image, "previous-image", os.Getenv(EnvPreviousImage), "reference to previous image")
 }
@@ -123,10 +221,18 @@ func FlagReportPath(path *string) {
 	flagSet.StringVar(path, "report", EnvOrDefault(EnvReportPath, DefaultReportPath), "path to report.toml")
 }
 
+func FlagResume(resume *bool) {
+	flagSet.BoolVar(resume, "resume", BoolEnv(EnvResume), "skip buildpacks that completed in the checkpointed build, unless the app directory changed since")
+}
+
 func FlagRunImage(image *string) {
 	flagSet.StringVar(image, "run-image", os.Getenv(EnvRunImage), "reference to run image")
 }
 
+func FlagSBOMDir(dir *string) {
+	flagSet.StringVar(dir, "sbom-dir", os.Getenv(EnvSBOMDir), "path to directory to write SBOM documents to")
+}
+
 func FlagSkipLayers(skip *bool) {
 	flagSet.BoolVar(skip, "skip-layers", BoolEnv(EnvSkipLayers), "do not provide layer metadata to buildpacks")
 }
@@ -143,6 +249,10 @@ func FlagTags(tags *StringSlice) {
 	flagSet.Var(tags, "tag", "additional tags")
 }
 
+func FlagTOMLValidation(mode *string) {
+	flagSet.StringVar(mode, "toml-validation", os.Getenv(EnvTOMLValidation), "check buildpack TOML files for unknown and missing keys, either 'warn' or 'strict'")
+}
+
 func FlagUID(uid *int) {
 	flagSet.IntVar(uid, "uid", intEnv(EnvUID), "UID of user in the stack's build and run images")
 }
@@ -151,6 +261,10 @@ func FlagUseDaemon(use *bool) {
 	flagSet.BoolVar(use, "daemon", BoolEnv(EnvUseDaemon), "export to docker daemon")
 }
 
+func FlagVerify(reference *string) {
+	flagSet.StringVar(reference, "verify", os.Getenv(EnvVerify), "image or export report to compare the export to, instead of saving the image")
+}
+
 func FlagVersion(version *bool) {
 	flagSet.BoolVar(version, "version", false, "show version")
 }
@@ -191,6 +305,15 @@ func intEnv(k string) int {
 	return d
 }
 
+func durationEnv(k string) time.Duration {
+	v := os.Getenv(k)
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0
+	}
+	return d
+}
+
 func BoolEnv(k string) bool {
 	v := os.Getenv(k)
 	b, err := strconv.ParseBool(v)
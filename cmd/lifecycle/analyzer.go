@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/buildpacks/imgutil"
 	"github.com/buildpacks/imgutil/local"
@@ -12,6 +13,7 @@ import (
 	"github.com/buildpacks/lifecycle"
 	"github.com/buildpacks/lifecycle/auth"
 	"github.com/buildpacks/lifecycle/cmd"
+	"github.com/buildpacks/lifecycle/image/layout"
 	"github.com/buildpacks/lifecycle/priv"
 )
 
@@ -25,12 +27,14 @@ type analyzeCmd struct {
 
 	//flags: paths to write data
 	analyzedPath string
+	metricsPath  string
 }
 
 type analyzeArgs struct {
 	//inputs needed when run by creator
 	imageName  string
 	layersDir  string
+	layoutDir  string
 	skipLayers bool
 	useDaemon  bool
 
@@ -43,7 +47,9 @@ func (a *analyzeCmd) Init() {
 	cmd.FlagCacheDir(&a.cacheDir)
 	cmd.FlagCacheImage(&a.cacheImageTag)
 	cmd.FlagGroupPath(&a.groupPath)
+	cmd.FlagMetricsPath(&a.metricsPath)
 	cmd.FlagLayersDir(&a.layersDir)
+	cmd.FlagLayoutDir(&a.layoutDir)
 	cmd.FlagSkipLayers(&a.skipLayers)
 	cmd.FlagUseDaemon(&a.useDaemon)
 	cmd.FlagUID(&a.uid)
@@ -57,6 +63,9 @@ func (a *analyzeCmd) Args(nargs int, args []string) error {
 	if args[0] == "" {
 		return cmd.FailErrCode(errors.New("image argument is required"), cmd.CodeInvalidArgs, "parse arguments")
 	}
+	if a.useDaemon && a.layoutDir != "" {
+		return cmd.FailErrCode(errors.New("supply only one of -daemon or -layout"), cmd.CodeInvalidArgs, "parse arguments")
+	}
 	if a.cacheImageTag == "" && a.cacheDir == "" {
 		cmd.DefaultLogger.Warn("Not restoring cached layer metadata, no cache flag specified.")
 	}
@@ -95,8 +104,10 @@ func (a *analyzeCmd) Exec() error {
 		return cmd.FailErr(err, "initialize cache")
 	}
 
+	metrics := readMetrics(a.metricsPath)
+	start := time.Now()
 	analyzedMD, err := a.analyze(group, cacheStore)
-	if err != nil {
+	if err := recordPhase(a.metricsPath, "analyze", metrics, start, err); err != nil {
 		return err
 	}
 
@@ -118,6 +129,12 @@ func (aa analyzeArgs) analyze(group lifecycle.BuildpackGroup, cacheStore lifecyc
 			aa.docker,
 			local.FromBaseImage(aa.imageName),
 		)
+	} else if aa.layoutDir != "" {
+		img, err = layout.NewImage(
+			aa.imageName,
+			aa.layoutDir,
+			layout.FromBaseImage(aa.imageName),
+		)
 	} else {
 		img, err = remote.NewImage(
 			aa.imageName,
@@ -2,8 +2,10 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"log"
 	"os"
+	"time"
 
 	"github.com/BurntSushi/toml"
 
@@ -17,18 +19,28 @@ import (
 
 type buildCmd struct {
 	// flags: inputs
-	groupPath string
-	planPath  string
+	groupPath   string
+	planPath    string
+	metricsPath string
 	buildArgs
 }
 
 type buildArgs struct {
 	// inputs needed when run by creator
-	buildpacksDir string
-	layersDir     string
-	appDir        string
-	platformDir   string
-	platformAPI   string
+	buildpacksDir   string
+	layersDir       string
+	appDir          string
+	platformDir     string
+	platformAPI     string
+	secretsDir      string
+	buildTimeout    time.Duration
+	metrics         *lifecycle.Metrics
+	logsDir         string
+	prefixOutput    bool
+	planFulfillment string
+	tomlValidation  string
+	checkpointPath  string
+	resume          bool
 }
 
 func (b *buildCmd) Init() {
@@ -38,12 +50,40 @@ func (b *buildCmd) Init() {
 	cmd.FlagLayersDir(&b.layersDir)
 	cmd.FlagAppDir(&b.appDir)
 	cmd.FlagPlatformDir(&b.platformDir)
+	cmd.FlagBuildSecretsDir(&b.secretsDir)
+	cmd.FlagBuildTimeout(&b.buildTimeout)
+	cmd.FlagMetricsPath(&b.metricsPath)
+	cmd.FlagLogsDir(&b.logsDir)
+	cmd.FlagPrefixOutput(&b.prefixOutput)
+	cmd.FlagPlanFulfillment(&b.planFulfillment)
+	cmd.FlagTOMLValidation(&b.tomlValidation)
+	cmd.FlagCheckpointPath(&b.checkpointPath)
+	cmd.FlagResume(&b.resume)
 }
 
 func (b *buildCmd) Args(nargs int, args []string) error {
 	if nargs != 0 {
 		return cmd.FailErrCode(errors.New("received unexpected arguments"), cmd.CodeInvalidArgs, "parse arguments")
 	}
+	if b.resume && b.checkpointPath == "" {
+		return cmd.FailErrCode(errors.New("-resume requires -checkpoint"), cmd.CodeInvalidArgs, "parse arguments")
+	}
+	b.secretsDir = buildSecretsDir(b.secretsDir, b.platformDir)
+	return verifyBuildModes(b.planFulfillment, b.tomlValidation)
+}
+
+// verifyBuildModes returns an error if the plan fulfillment or TOML validation mode is unknown
+func verifyBuildModes(planFulfillment, tomlValidation string) error {
+	switch planFulfillment {
+	case "", lifecycle.PlanFulfillmentWarn, lifecycle.PlanFulfillmentStrict:
+	default:
+		return cmd.FailErrCode(fmt.Errorf("unknown plan fulfillment mode '%s'", planFulfillment), cmd.CodeInvalidArgs, "parse arguments")
+	}
+	switch tomlValidation {
+	case "", lifecycle.TOMLValidationWarn, lifecycle.TOMLValidationStrict:
+	default:
+		return cmd.FailErrCode(fmt.Errorf("unknown TOML validation mode '%s'", tomlValidation), cmd.CodeInvalidArgs, "parse arguments")
+	}
 	return nil
 }
 
@@ -56,6 +96,7 @@ func (b *buildCmd) Privileges() error {
 }
 
 func (b *buildCmd) Exec() error {
+	defer lifecycle.RemoveUnpackedBuildpackages()
 	group, plan, err := b.readData()
 	if err != nil {
 		return err
@@ -63,28 +104,44 @@ func (b *buildCmd) Exec() error {
 	if err := verifyBuildpackApis(group); err != nil {
 		return err
 	}
-	return b.build(group, plan)
+	b.metrics = readMetrics(b.metricsPath)
+	start := time.Now()
+	err = b.build(group, plan)
+	return recordPhase(b.metricsPath, "build", b.metrics, start, err)
 }
 
 func (ba buildArgs) build(group lifecycle.BuildpackGroup, plan lifecycle.BuildPlan) error {
 	builder := &lifecycle.Builder{
-		AppDir:        ba.appDir,
-		LayersDir:     ba.layersDir,
-		PlatformDir:   ba.platformDir,
-		BuildpacksDir: ba.buildpacksDir,
-		PlatformAPI:   api.MustParse(ba.platformAPI),
-		Env:           env.NewBuildEnv(os.Environ()),
-		Group:         group,
-		Plan:          plan,
-		Out:           log.New(os.Stdout, "", 0),
-		Err:           log.New(os.Stderr, "", 0),
+		AppDir:          ba.appDir,
+		LayersDir:       ba.layersDir,
+		PlatformDir:     ba.platformDir,
+		SecretsDir:      ba.secretsDir,
+		BuildpacksDir:   ba.buildpacksDir,
+		PlatformAPI:     api.MustParse(ba.platformAPI),
+		Env:             env.NewBuildEnv(os.Environ()),
+		Group:           group,
+		Plan:            plan,
+		Out:             log.New(os.Stdout, "", 0),
+		Err:             log.New(os.Stderr, "", 0),
+		Logger:          cmd.DefaultLogger,
+		Metrics:         ba.metrics,
+		LogsDir:         ba.logsDir,
+		PrefixOutput:    ba.prefixOutput,
+		PlanFulfillment: ba.planFulfillment,
+		TOMLValidation:  ba.tomlValidation,
+		CheckpointPath:  ba.checkpointPath,
+		Resume:          ba.resume,
+		Timeout:         ba.buildTimeout,
 	}
 	md, err := builder.Build()
 
 	if err != nil {
 		if err, ok := err.(*lifecycle.Error); ok {
-			if err.Type == lifecycle.ErrTypeBuildpack {
+			switch err.Type {
+			case lifecycle.ErrTypeBuildpack:
 				return cmd.FailErrCode(err.Cause(), cmd.CodeFailedBuildWithErrors, "build")
+			case lifecycle.ErrTypeBuildpackTimeout:
+				return cmd.FailErrCode(err.Cause(), cmd.CodeBuildTimeout, "build")
 			}
 		}
 		return cmd.FailErrCode(err, cmd.CodeBuildError, "build")
@@ -2,9 +2,13 @@ package main
 
 import (
 	"fmt"
+	"time"
 
+	"github.com/BurntSushi/toml"
 	"github.com/docker/docker/client"
+	"github.com/google/go-containerregistry/pkg/name"
 
+	"github.com/buildpacks/lifecycle"
 	"github.com/buildpacks/lifecycle/cmd"
 	"github.com/buildpacks/lifecycle/image"
 	"github.com/buildpacks/lifecycle/priv"
@@ -14,13 +18,24 @@ type createCmd struct {
 	//flags: inputs
 	appDir              string
 	buildpacksDir       string
+	buildTimeout        time.Duration
 	cacheDir            string
 	cacheImageTag       string
+	creationTime        string
+	detectReportPath    string
+	detectTimeout       time.Duration
+	detectWorkers       int
+	exportWorkers       int
 	imageName           string
 	launchCacheDir      string
 	launcherPath        string
 	layersDir           string
+	layoutDir           string
+	logsDir             string
+	maxLayers           int
+	metricsPath         string
 	orderPath           string
+	planFulfillment     string
 	platformAPI         string
 	platformDir         string
 	previousImage       string
@@ -28,9 +43,16 @@ type createCmd struct {
 	projectMetadataPath string
 	reportPath          string
 	runImageRef         string
+	sbomDir             string
+	secretsDir          string
 	stackPath           string
+	tomlValidation      string
+	verifyRef           string
 	uid, gid            int
 	additionalTags      cmd.StringSlice
+	detectCache         bool
+	layerCreationTime   bool
+	prefixOutput        bool
 	skipRestore         bool
 	useDaemon           bool
 
@@ -40,22 +62,40 @@ type createCmd struct {
 
 func (c *createCmd) Init() {
 	cmd.FlagAppDir(&c.appDir)
+	cmd.FlagBuildSecretsDir(&c.secretsDir)
 	cmd.FlagBuildpacksDir(&c.buildpacksDir)
+	cmd.FlagBuildTimeout(&c.buildTimeout)
 	cmd.FlagCacheDir(&c.cacheDir)
 	cmd.FlagCacheImage(&c.cacheImageTag)
+	cmd.FlagCreationTime(&c.creationTime)
+	cmd.FlagDetectCache(&c.detectCache)
+	cmd.FlagDetectReportPath(&c.detectReportPath)
+	cmd.FlagDetectTimeout(&c.detectTimeout)
+	cmd.FlagDetectWorkers(&c.detectWorkers)
+	cmd.FlagExportWorkers(&c.exportWorkers)
 	cmd.FlagGID(&c.gid)
 	cmd.FlagLaunchCacheDir(&c.launchCacheDir)
 	cmd.FlagLauncherPath(&c.launcherPath)
+	cmd.FlagLayerCreationTime(&c.layerCreationTime)
 	cmd.FlagLayersDir(&c.layersDir)
+	cmd.FlagLayoutDir(&c.layoutDir)
+	cmd.FlagLogsDir(&c.logsDir)
+	cmd.FlagMaxLayers(&c.maxLayers)
+	cmd.FlagMetricsPath(&c.metricsPath)
 	cmd.FlagOrderPath(&c.orderPath)
+	cmd.FlagPlanFulfillment(&c.planFulfillment)
 	cmd.FlagPlatformDir(&c.platformDir)
+	cmd.FlagPrefixOutput(&c.prefixOutput)
 	cmd.FlagPreviousImage(&c.previousImage)
 	cmd.FlagReportPath(&c.reportPath)
 	cmd.FlagRunImage(&c.runImageRef)
+	cmd.FlagSBOMDir(&c.sbomDir)
 	cmd.FlagSkipRestore(&c.skipRestore)
 	cmd.FlagStackPath(&c.stackPath)
+	cmd.FlagTOMLValidation(&c.tomlValidation)
 	cmd.FlagUID(&c.uid)
 	cmd.FlagUseDaemon(&c.useDaemon)
+	cmd.FlagVerify(&c.verifyRef)
 	cmd.FlagTags(&c.additionalTags)
 	cmd.FlagProjectMetadataPath(&c.projectMetadataPath)
 	cmd.FlagProcessType(&c.processType)
@@ -67,6 +107,10 @@ func (c *createCmd) Args(nargs int, args []string) error {
 	}
 
 	c.imageName = args[0]
+	c.secretsDir = buildSecretsDir(c.secretsDir, c.platformDir)
+	if c.useDaemon && c.layoutDir != "" {
+		return cmd.FailErrCode(fmt.Errorf("supply only one of -daemon or -layout"), cmd.CodeInvalidArgs, "parse arguments")
+	}
 	if c.launchCacheDir != "" && !c.useDaemon {
 		cmd.DefaultLogger.Warn("Ignoring -launch-cache, only intended for use with -daemon")
 		c.launchCacheDir = ""
@@ -74,17 +118,21 @@ func (c *createCmd) Args(nargs int, args []string) error {
 
 	if c.cacheImageTag == "" && c.cacheDir == "" {
 		cmd.DefaultLogger.Warn("Not restoring or caching layer data, no cache flag specified.")
+		if c.detectCache {
+			cmd.DefaultLogger.Warn("Ignoring -detect-cache, no cache flag specified.")
+			c.detectCache = false
+		}
 	}
 
 	if c.previousImage == "" {
 		c.previousImage = c.imageName
 	}
 
-	if err := image.ValidateDestinationTags(c.useDaemon, append(c.additionalTags, c.imageName)...); err != nil {
+	if err := image.ValidateDestinationTags(c.useDaemon || c.layoutDir != "", append(c.additionalTags, c.imageName)...); err != nil {
 		return cmd.FailErrCode(err, cmd.CodeInvalidArgs, "validate image tag(s)")
 	}
 
-	return nil
+	return verifyBuildModes(c.planFulfillment, c.tomlValidation)
 }
 
 func (c *createCmd) Privileges() error {
@@ -95,7 +143,7 @@ func (c *createCmd) Privileges() error {
 			return cmd.FailErr(err, "initialize docker client")
 		}
 	}
-	if err := priv.EnsureOwner(c.uid, c.gid, c.cacheDir, c.launchCacheDir, c.layersDir); err != nil {
+	if err := priv.EnsureOwner(c.uid, c.gid, c.cacheDir, c.launchCacheDir, c.layersDir, c.layoutDir, c.logsDir); err != nil {
 		return cmd.FailErr(err, "chown volumes")
 	}
 	if err := priv.RunAs(c.uid, c.gid); err != nil {
@@ -108,69 +156,147 @@ func (c *createCmd) Privileges() error {
 }
 
 func (c *createCmd) Exec() error {
+	defer lifecycle.RemoveUnpackedBuildpackages()
 	cacheStore, err := initCache(c.cacheImageTag, c.cacheDir)
 	if err != nil {
 		return err
 	}
 
+	var detectCache *lifecycle.DetectCache
+	if c.detectCache {
+		if detectCache, err = readDetectCache(cacheStore); err != nil {
+			return err
+		}
+	}
+
+	runMixins, err := c.readRunMixins()
+	if err != nil {
+		return err
+	}
+
+	metrics := &lifecycle.Metrics{}
+
 	cmd.DefaultLogger.Phase("DETECTING")
+	start := time.Now()
 	group, plan, err := detectArgs{
 		buildpacksDir: c.buildpacksDir,
 		appDir:        c.appDir,
 		platformDir:   c.platformDir,
 		orderPath:     c.orderPath,
+		reportPath:    c.detectReportPath,
+		stackPath:     c.stackPath,
+		runMixins:     runMixins,
+		detectTimeout: c.detectTimeout,
+		detectWorkers: c.detectWorkers,
+		detectCache:   detectCache,
+		metrics:       metrics,
+		logsDir:       c.logsDir,
 	}.detect()
-	if err != nil {
+	if err := recordPhase(c.metricsPath, "detect", metrics, start, err); err != nil {
 		return err
 	}
 
 	cmd.DefaultLogger.Phase("ANALYZING")
+	start = time.Now()
 	analyzedMD, err := analyzeArgs{
 		imageName:  c.previousImage,
 		layersDir:  c.layersDir,
+		layoutDir:  c.layoutDir,
 		skipLayers: c.skipRestore,
 		useDaemon:  c.useDaemon,
 		docker:     c.docker,
 	}.analyze(group, cacheStore)
-	if err != nil {
+	if err := recordPhase(c.metricsPath, "analyze", metrics, start, err); err != nil {
 		return err
 	}
 
 	if !c.skipRestore {
 		cmd.DefaultLogger.Phase("RESTORING")
-		if err := restore(c.layersDir, group, cacheStore); err != nil {
+		start = time.Now()
+		err := restore(c.layersDir, group, cacheStore)
+		if err := recordPhase(c.metricsPath, "restore", metrics, start, err); err != nil {
 			return err
 		}
 	}
 
 	cmd.DefaultLogger.Phase("BUILDING")
+	start = time.Now()
 	err = buildArgs{
-		buildpacksDir: c.buildpacksDir,
-		layersDir:     c.layersDir,
-		appDir:        c.appDir,
-		platformAPI:   c.platformAPI,
-		platformDir:   c.platformDir,
+		buildpacksDir:   c.buildpacksDir,
+		layersDir:       c.layersDir,
+		appDir:          c.appDir,
+		platformAPI:     c.platformAPI,
+		platformDir:     c.platformDir,
+		secretsDir:      c.secretsDir,
+		buildTimeout:    c.buildTimeout,
+		metrics:         metrics,
+		logsDir:         c.logsDir,
+		prefixOutput:    c.prefixOutput,
+		planFulfillment: c.planFulfillment,
+		tomlValidation:  c.tomlValidation,
 	}.build(group, plan)
-	if err != nil {
+	if err := recordPhase(c.metricsPath, "build", metrics, start, err); err != nil {
 		return err
 	}
 
+	var detectCacheEntries []lifecycle.DetectCacheEntry
+	if detectCache != nil {
+		detectCacheEntries = detectCache.Entries()
+	}
+
 	cmd.DefaultLogger.Phase("EXPORTING")
-	return exportArgs{
+	start = time.Now()
+	err = exportArgs{
 		appDir:              c.appDir,
+		buildpacksDir:       c.buildpacksDir,
+		creationTime:        c.creationTime,
+		detectCache:         detectCacheEntries,
+		detectCacheEnabled:  c.detectCache,
 		docker:              c.docker,
+		exportWorkers:       c.exportWorkers,
 		gid:                 c.gid,
 		imageNames:          append([]string{c.imageName}, c.additionalTags...),
 		launchCacheDir:      c.launchCacheDir,
 		launcherPath:        c.launcherPath,
+		layerCreationTime:   c.layerCreationTime,
 		layersDir:           c.layersDir,
+		layoutDir:           c.layoutDir,
+		maxLayers:           c.maxLayers,
 		platformAPI:         c.platformAPI,
 		processType:         c.processType,
 		projectMetadataPath: c.projectMetadataPath,
 		reportPath:          c.reportPath,
 		runImageRef:         c.runImageRef,
+		sbomDir:             c.sbomDir,
+		secretsDir:          c.secretsDir,
 		stackPath:           c.stackPath,
 		uid:                 c.uid,
 		useDaemon:           c.useDaemon,
+		verifyRef:           c.verifyRef,
 	}.export(group, cacheStore, analyzedMD)
+	return recordPhase(c.metricsPath, "export", metrics, start, err)
+}
+
+// readRunMixins returns the mixins label of the run image the app image will be exported on,
+// or nil if there is no run image to read it from; export reports the missing run image.
+func (c *createCmd) readRunMixins() ([]string, error) {
+	runImageRef := c.runImageRef
+	if runImageRef == "" {
+		var stackMD lifecycle.StackMetadata
+		if _, err := toml.DecodeFile(c.stackPath, &stackMD); err != nil || stackMD.RunImage.Image == "" {
+			return nil, nil
+		}
+		ref, err := name.ParseReference(c.imageName, name.WeakValidation)
+		if err != nil {
+			return nil, cmd.FailErr(err, "failed to parse registry")
+		}
+		if runImageRef, err = stackMD.BestRunImageMirror(ref.Context().RegistryStr()); err != nil {
+			return nil, cmd.FailErr(err, "resolve run image")
+		}
+	}
+	mixins, err := readRunMixins(runImageRef, c.layoutDir, c.useDaemon, c.docker)
+	if err != nil {
+		return nil, cmd.FailErr(err, "read run image mixins")
+	}
+	return mixins, nil
 }
@@ -1,23 +1,45 @@
 package main
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/buildpacks/imgutil"
+	"github.com/buildpacks/imgutil/local"
+	"github.com/buildpacks/imgutil/remote"
+	"github.com/docker/docker/client"
 
 	"github.com/buildpacks/lifecycle"
+	"github.com/buildpacks/lifecycle/auth"
 	"github.com/buildpacks/lifecycle/cmd"
 	"github.com/buildpacks/lifecycle/env"
+	"github.com/buildpacks/lifecycle/image/layout"
 	"github.com/buildpacks/lifecycle/priv"
 )
 
 type detectCmd struct {
 	// flags: inputs
+	cacheDir       string
+	cacheImageTag  string
+	layoutDir      string
+	runImageRef    string
+	useDetectCache bool
 	detectArgs
 
 	// flags: paths to write outputs
-	groupPath string
-	planPath  string
+	detectCachePath string
+	groupPath       string
+	planPath        string
+	metricsPath     string
+
+	// flags: print results instead of writing outputs
+	explain bool
 }
 
 type detectArgs struct {
@@ -26,21 +48,46 @@ type detectArgs struct {
 	appDir        string
 	platformDir   string
 	orderPath     string
+	reportPath    string
+	stackPath     string
+	runMixins     []string // the mixins label of the run image, run image mixins are not checked if nil
+	detectTimeout time.Duration
+	detectWorkers int
+	detectCache   *lifecycle.DetectCache
+	metrics       *lifecycle.Metrics
+	logsDir       string
 }
 
 func (d *detectCmd) Init() {
 	cmd.FlagBuildpacksDir(&d.buildpacksDir)
 	cmd.FlagAppDir(&d.appDir)
+	cmd.FlagCacheDir(&d.cacheDir)
+	cmd.FlagCacheImage(&d.cacheImageTag)
+	cmd.FlagDetectCache(&d.useDetectCache)
+	cmd.FlagDetectCachePath(&d.detectCachePath)
 	cmd.FlagPlatformDir(&d.platformDir)
 	cmd.FlagOrderPath(&d.orderPath)
 	cmd.FlagGroupPath(&d.groupPath)
 	cmd.FlagPlanPath(&d.planPath)
+	cmd.FlagDetectReportPath(&d.reportPath)
+	cmd.FlagDetectTimeout(&d.detectTimeout)
+	cmd.FlagDetectWorkers(&d.detectWorkers)
+	cmd.FlagExplain(&d.explain)
+	cmd.FlagMetricsPath(&d.metricsPath)
+	cmd.FlagLogsDir(&d.logsDir)
+	cmd.FlagStackPath(&d.stackPath)
+	cmd.FlagLayoutDir(&d.layoutDir)
+	cmd.FlagRunImage(&d.runImageRef)
 }
 
 func (d *detectCmd) Args(nargs int, args []string) error {
 	if nargs != 0 {
 		return cmd.FailErrCode(errors.New("received unexpected arguments"), cmd.CodeInvalidArgs, "parse arguments")
 	}
+	if d.useDetectCache && d.cacheImageTag == "" && d.cacheDir == "" {
+		cmd.DefaultLogger.Warn("Ignoring -detect-cache, no cache flag specified.")
+		d.useDetectCache = false
+	}
 	return nil
 }
 
@@ -53,35 +100,110 @@ func (d *detectCmd) Privileges() error {
 }
 
 func (d *detectCmd) Exec() error {
+	defer lifecycle.RemoveUnpackedBuildpackages()
+	if d.useDetectCache {
+		cacheStore, err := initCache(d.cacheImageTag, d.cacheDir)
+		if err != nil {
+			return err
+		}
+		if d.detectCache, err = readDetectCache(cacheStore); err != nil {
+			return err
+		}
+	}
+	if d.runImageRef != "" {
+		var err error
+		if d.runMixins, err = readRunMixins(d.runImageRef, d.layoutDir, false, nil); err != nil {
+			return cmd.FailErr(err, "read run image mixins")
+		}
+	}
+	if d.explain {
+		// explain must not leave anything behind, so the output of each buildpack is not logged
+		d.logsDir = ""
+		group, plan, report, err := d.runDetect()
+		if err != nil {
+			if report != nil {
+				explainFailure(*report)
+			}
+			return err
+		}
+		return explain(group, plan, report)
+	}
+	d.metrics = &lifecycle.Metrics{}
+	start := time.Now()
 	group, plan, err := d.detect()
-	if err != nil {
+	if err := recordPhase(d.metricsPath, "detect", d.metrics, start, err); err != nil {
 		return err
 	}
-	return d.writeData(group, plan)
+	if err := d.writeData(group, plan); err != nil {
+		return err
+	}
+	if d.detectCache != nil {
+		if err := lifecycle.WriteDetectCache(d.detectCachePath, d.detectCache.Entries()); err != nil {
+			return cmd.FailErr(err, "write detect cache")
+		}
+	}
+	return nil
+}
+
+// readDetectCache returns the detect results cached by a previous build
+func readDetectCache(cacheStore lifecycle.Cache) (*lifecycle.DetectCache, error) {
+	cacheMD, err := cacheStore.RetrieveMetadata()
+	if err != nil {
+		return nil, cmd.FailErr(err, "retrieve cache metadata")
+	}
+	return lifecycle.NewDetectCache(cacheMD.Detect), nil
 }
 
 func (da detectArgs) detect() (lifecycle.BuildpackGroup, lifecycle.BuildPlan, error) {
+	group, plan, report, err := da.runDetect()
+	if da.reportPath != "" && report != nil {
+		if err := lifecycle.WriteTOML(da.reportPath, report); err != nil {
+			return lifecycle.BuildpackGroup{}, lifecycle.BuildPlan{}, cmd.FailErr(err, "write detect report")
+		}
+	}
+	return group, plan, err
+}
+
+// runDetect detects the order without writing any files and returns the detect report, if detection ran
+func (da detectArgs) runDetect() (lifecycle.BuildpackGroup, lifecycle.BuildPlan, *lifecycle.DetectReport, error) {
 	order, err := lifecycle.ReadOrder(da.orderPath)
 	if err != nil {
-		return lifecycle.BuildpackGroup{}, lifecycle.BuildPlan{}, cmd.FailErr(err, "read buildpack order file")
+		return lifecycle.BuildpackGroup{}, lifecycle.BuildPlan{}, nil, cmd.FailErr(err, "read buildpack order file")
 	}
 	if err := da.verifyBuildpackApis(order); err != nil {
-		return lifecycle.BuildpackGroup{}, lifecycle.BuildPlan{}, err
+		return lifecycle.BuildpackGroup{}, lifecycle.BuildPlan{}, nil, err
 	}
 
 	envv := env.NewBuildEnv(os.Environ())
 	fullEnv, err := envv.WithPlatform(da.platformDir)
 	if err != nil {
-		return lifecycle.BuildpackGroup{}, lifecycle.BuildPlan{}, cmd.FailErr(err, "read full env")
+		return lifecycle.BuildpackGroup{}, lifecycle.BuildPlan{}, nil, cmd.FailErr(err, "read full env")
+	}
+	platform, err := lifecycle.ReadPlatformBuildpacks(filepath.Join(da.platformDir, "buildpacks.toml"))
+	if err != nil {
+		return lifecycle.BuildpackGroup{}, lifecycle.BuildPlan{}, nil, cmd.FailErr(err, "read platform buildpacks")
+	}
+	stack, err := da.readStack()
+	if err != nil {
+		return lifecycle.BuildpackGroup{}, lifecycle.BuildPlan{}, nil, cmd.FailErr(err, "read stack metadata")
 	}
-	group, plan, err := order.Detect(&lifecycle.DetectConfig{
+	detectConfig := &lifecycle.DetectConfig{
 		FullEnv:       fullEnv,
 		ClearEnv:      envv.List(),
 		AppDir:        da.appDir,
 		PlatformDir:   da.platformDir,
 		BuildpacksDir: da.buildpacksDir,
 		Logger:        cmd.DefaultLogger,
-	})
+		Timeout:       da.detectTimeout,
+		Workers:       da.detectWorkers,
+		Platform:      platform,
+		Cache:         da.detectCache,
+		Stack:         stack,
+		Metrics:       da.metrics,
+		LogsDir:       da.logsDir,
+	}
+	group, plan, err := order.Detect(detectConfig)
+	report := detectConfig.Report()
 	if err != nil {
 		switch err := err.(type) {
 		case *lifecycle.Error:
@@ -89,19 +211,68 @@ func (da detectArgs) detect() (lifecycle.BuildpackGroup, lifecycle.BuildPlan, er
 			case lifecycle.ErrTypeFailedDetection:
 				cmd.DefaultLogger.Error("No buildpack groups passed detection.")
 				cmd.DefaultLogger.Error("Please check that you are running against the correct path.")
-				return lifecycle.BuildpackGroup{}, lifecycle.BuildPlan{}, cmd.FailErrCode(err, cmd.CodeFailedDetect, "detect")
+				return lifecycle.BuildpackGroup{}, lifecycle.BuildPlan{}, &report, cmd.FailErrCode(err, cmd.CodeFailedDetect, "detect")
 			case lifecycle.ErrTypeBuildpack:
 				cmd.DefaultLogger.Error("No buildpack groups passed detection.")
-				return lifecycle.BuildpackGroup{}, lifecycle.BuildPlan{}, cmd.FailErrCode(err, cmd.CodeFailedDetectWithErrors, "detect")
+				return lifecycle.BuildpackGroup{}, lifecycle.BuildPlan{}, &report, cmd.FailErrCode(err, cmd.CodeFailedDetectWithErrors, "detect")
+			case lifecycle.ErrTypeBuildpackTimeout:
+				cmd.DefaultLogger.Error("No buildpack groups passed detection.")
+				return lifecycle.BuildpackGroup{}, lifecycle.BuildPlan{}, &report, cmd.FailErrCode(err, cmd.CodeFailedDetectWithTimeout, "detect")
+			case lifecycle.ErrTypeOrderCycle:
+				return lifecycle.BuildpackGroup{}, lifecycle.BuildPlan{}, &report, cmd.FailErrCode(err, cmd.CodeDetectOrderCycle, "detect")
 			default:
-				return lifecycle.BuildpackGroup{}, lifecycle.BuildPlan{}, cmd.FailErrCode(err, cmd.CodeDetectError, "detect")
+				return lifecycle.BuildpackGroup{}, lifecycle.BuildPlan{}, &report, cmd.FailErrCode(err, cmd.CodeDetectError, "detect")
 			}
 		default:
-			return lifecycle.BuildpackGroup{}, lifecycle.BuildPlan{}, cmd.FailErrCode(err, cmd.CodeDetectError, "detect")
+			return lifecycle.BuildpackGroup{}, lifecycle.BuildPlan{}, &report, cmd.FailErrCode(err, cmd.CodeDetectError, "detect")
 		}
 	}
 
-	return group, plan, nil
+	return group, plan, &report, nil
+}
+
+// readStack returns the stack ID of the build image and the mixins of the build and run images.
+// The detector runs inside the build image and cannot inspect it, so its mixins label comes from stack.toml,
+// where the platform copies it.
+func (da detectArgs) readStack() (lifecycle.Stack, error) {
+	var stackMD lifecycle.StackMetadata
+	if _, err := toml.DecodeFile(da.stackPath, &stackMD); err != nil && !os.IsNotExist(err) {
+		return lifecycle.Stack{}, err
+	}
+	return lifecycle.Stack{
+		ID:          os.Getenv(cmd.EnvStackID),
+		BuildMixins: stackMD.BuildImage.Mixins,
+		RunMixins:   da.runMixins,
+	}, nil
+}
+
+// readRunMixins returns the io.buildpacks.stack.mixins label of the run image from the daemon, the layout dir or the registry.
+// A run image without the label has no mixins.
+func readRunMixins(runImageRef, layoutDir string, useDaemon bool, docker client.CommonAPIClient) ([]string, error) {
+	var runImage imgutil.Image
+	var err error
+	switch {
+	case useDaemon:
+		runImage, err = local.NewImage(runImageRef, docker, local.FromBaseImage(runImageRef))
+	case layoutDir != "":
+		runImage, err = layout.NewImage(runImageRef, layoutDir, layout.FromBaseImage(runImageRef))
+	default:
+		runImage, err = remote.NewImage(runImageRef, auth.NewKeychain(cmd.EnvRegistryAuth), remote.FromBaseImage(runImageRef))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !runImage.Found() {
+		return nil, fmt.Errorf("run image '%s' not found", runImageRef)
+	}
+	mixins, err := lifecycle.ReadMixins(runImage)
+	if err != nil {
+		return nil, err
+	}
+	if mixins == nil {
+		mixins = []string{}
+	}
+	return mixins, nil
 }
 
 func (da detectArgs) verifyBuildpackApis(order lifecycle.BuildpackOrder) error {
@@ -119,6 +290,68 @@ func (da detectArgs) verifyBuildpackApis(order lifecycle.BuildpackOrder) error {
 	return nil
 }
 
+// explain prints the selected group, its build plan and the buildpacks that provide each requirement of the plan
+func explain(group lifecycle.BuildpackGroup, plan lifecycle.BuildPlan, report *lifecycle.DetectReport) error {
+	cmd.DefaultLogger.Info("======== Selected group ========")
+	for _, bp := range group.Group {
+		cmd.DefaultLogger.Infof("%s (api %s)", bp, bp.API)
+	}
+	cmd.DefaultLogger.Info("======== Build plan ========")
+	buf := &bytes.Buffer{}
+	if err := toml.NewEncoder(buf).Encode(plan); err != nil {
+		return cmd.FailErr(err, "encode build plan")
+	}
+	cmd.DefaultLogger.Info(strings.TrimSpace(buf.String()))
+	cmd.DefaultLogger.Info("======== Providers ========")
+	for _, req := range report.Requires {
+		var providers []string
+		for _, p := range req.Providers {
+			providers = append(providers, p.String())
+		}
+		cmd.DefaultLogger.Infof("%s requires %s, provided by %s", req.Buildpack, withConstraint(req.Requires, req.Constraint), strings.Join(providers, ", "))
+	}
+	return nil
+}
+
+// explainFailure prints the detect result of each buildpack of each group and the last trial of the group that failed
+func explainFailure(report lifecycle.DetectReport) {
+	for i, group := range report.Groups {
+		cmd.DefaultLogger.Infof("======== Group #%d ========", i+1)
+		for _, bp := range group.Buildpacks {
+			cmd.DefaultLogger.Infof("%s: %s", bp.Buildpack, bp.Result)
+		}
+		if len(group.Trials) == 0 {
+			continue
+		}
+		trial := group.Trials[len(group.Trials)-1]
+		cmd.DefaultLogger.Info("Failed trial:")
+		for _, option := range trial.Options {
+			cmd.DefaultLogger.Infof("  %s requires [%s], provides [%s]", option.Buildpack, strings.Join(option.Requires, ", "), strings.Join(option.Provides, ", "))
+		}
+		for _, unmet := range trial.Unmet {
+			cmd.DefaultLogger.Infof("  %s", explainUnmet(unmet))
+		}
+	}
+}
+
+func explainUnmet(unmet lifecycle.DetectUnmetReport) string {
+	switch {
+	case unmet.Requires != "" && unmet.Provider != "":
+		return fmt.Sprintf("%s requires %s, %s provides %s", unmet.Buildpack, withConstraint(unmet.Requires, unmet.Constraint), unmet.Provider, withConstraint(unmet.Requires, unmet.ProvidedVersion))
+	case unmet.Requires != "":
+		return fmt.Sprintf("%s requires %s, which is not provided", unmet.Buildpack, withConstraint(unmet.Requires, unmet.Constraint))
+	default:
+		return fmt.Sprintf("%s provides %s, which is not required", unmet.Buildpack, unmet.Provides)
+	}
+}
+
+func withConstraint(name, version string) string {
+	if version == "" {
+		return name
+	}
+	return name + "@" + version
+}
+
 func (d *detectCmd) writeData(group lifecycle.BuildpackGroup, plan lifecycle.BuildPlan) error {
 	if err := lifecycle.WriteTOML(d.groupPath, group); err != nil {
 		return cmd.FailErr(err, "write buildpack group")
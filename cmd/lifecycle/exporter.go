@@ -4,6 +4,9 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"runtime"
+	"strconv"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/buildpacks/imgutil"
@@ -19,6 +22,7 @@ import (
 	"github.com/buildpacks/lifecycle/cache"
 	"github.com/buildpacks/lifecycle/cmd"
 	"github.com/buildpacks/lifecycle/image"
+	"github.com/buildpacks/lifecycle/image/layout"
 	"github.com/buildpacks/lifecycle/layers"
 	"github.com/buildpacks/lifecycle/priv"
 )
@@ -27,29 +31,43 @@ type exportCmd struct {
 	//flags: inputs
 	cacheDir              string
 	cacheImageTag         string
+	detectCachePath       string
 	groupPath             string
 	deprecatedRunImageRef string
 	exportArgs
 
 	//flags: paths to write outputs
 	analyzedPath string
+	metricsPath  string
 }
 
 type exportArgs struct {
 	// inputs needed when run by creator
 	appDir              string
+	buildpacksDir       string
+	creationTime        string
+	detectCache         []lifecycle.DetectCacheEntry
+	detectCacheEnabled  bool
+	exportWorkers       int
 	imageNames          []string
 	launchCacheDir      string
 	launcherPath        string
+	layerCreationTime   bool
 	layersDir           string
+	layoutDir           string
+	maxLayers           int
 	platformAPI         string
+	platformDir         string
 	processType         string
 	projectMetadataPath string
 	reportPath          string
 	runImageRef         string
+	sbomDir             string
+	secretsDir          string
 	stackPath           string
 	useDaemon           bool
 	uid, gid            int
+	verifyRef           string
 
 	//construct if necessary before dropping privileges
 	docker client.CommonAPIClient
@@ -58,20 +76,33 @@ type exportArgs struct {
 func (e *exportCmd) Init() {
 	cmd.FlagAnalyzedPath(&e.analyzedPath)
 	cmd.FlagAppDir(&e.appDir)
+	cmd.FlagBuildSecretsDir(&e.secretsDir)
+	cmd.FlagBuildpacksDir(&e.buildpacksDir)
 	cmd.FlagCacheDir(&e.cacheDir)
 	cmd.FlagCacheImage(&e.cacheImageTag)
+	cmd.FlagCreationTime(&e.creationTime)
+	cmd.FlagDetectCache(&e.detectCacheEnabled)
+	cmd.FlagDetectCachePath(&e.detectCachePath)
+	cmd.FlagExportWorkers(&e.exportWorkers)
 	cmd.FlagGID(&e.gid)
 	cmd.FlagGroupPath(&e.groupPath)
 	cmd.FlagLaunchCacheDir(&e.launchCacheDir)
 	cmd.FlagLauncherPath(&e.launcherPath)
+	cmd.FlagLayerCreationTime(&e.layerCreationTime)
 	cmd.FlagLayersDir(&e.layersDir)
+	cmd.FlagLayoutDir(&e.layoutDir)
+	cmd.FlagMaxLayers(&e.maxLayers)
+	cmd.FlagMetricsPath(&e.metricsPath)
+	cmd.FlagPlatformDir(&e.platformDir)
 	cmd.FlagProcessType(&e.processType)
 	cmd.FlagProjectMetadataPath(&e.projectMetadataPath)
 	cmd.FlagReportPath(&e.reportPath)
 	cmd.FlagRunImage(&e.runImageRef)
+	cmd.FlagSBOMDir(&e.sbomDir)
 	cmd.FlagStackPath(&e.stackPath)
 	cmd.FlagUID(&e.uid)
 	cmd.FlagUseDaemon(&e.useDaemon)
+	cmd.FlagVerify(&e.verifyRef)
 
 	cmd.DeprecatedFlagRunImage(&e.deprecatedRunImageRef)
 }
@@ -80,8 +111,12 @@ func (e *exportCmd) Args(nargs int, args []string) error {
 	if nargs == 0 {
 		return cmd.FailErrCode(errors.New("at least one image argument is required"), cmd.CodeInvalidArgs, "parse arguments")
 	}
+	e.secretsDir = buildSecretsDir(e.secretsDir, e.platformDir)
 
 	e.imageNames = args
+	if e.useDaemon && e.layoutDir != "" {
+		return cmd.FailErrCode(errors.New("supply only one of -daemon or -layout"), cmd.CodeInvalidArgs, "parse arguments")
+	}
 	if e.launchCacheDir != "" && !e.useDaemon {
 		cmd.DefaultLogger.Warn("Ignoring -launch-cache, only intended for use with -daemon")
 		e.launchCacheDir = ""
@@ -91,7 +126,7 @@ func (e *exportCmd) Args(nargs int, args []string) error {
 		cmd.DefaultLogger.Warn("Will not cache data, no cache flag specified.")
 	}
 
-	if err := image.ValidateDestinationTags(e.useDaemon, e.imageNames...); err != nil {
+	if err := image.ValidateDestinationTags(e.useDaemon || e.layoutDir != "", e.imageNames...); err != nil {
 		return cmd.FailErrCode(err, cmd.CodeInvalidArgs, "validate image tag(s)")
 	}
 
@@ -114,7 +149,7 @@ func (e *exportCmd) Privileges() error {
 			return cmd.FailErr(err, "initialize docker client")
 		}
 	}
-	if err := priv.EnsureOwner(e.uid, e.gid, e.cacheDir, e.launchCacheDir); err != nil {
+	if err := priv.EnsureOwner(e.uid, e.gid, e.cacheDir, e.launchCacheDir, e.layoutDir); err != nil {
 		return cmd.FailErr(err, "chown volumes")
 	}
 	if err := priv.RunAs(e.uid, e.gid); err != nil {
@@ -124,6 +159,7 @@ func (e *exportCmd) Privileges() error {
 }
 
 func (e *exportCmd) Exec() error {
+	defer lifecycle.RemoveUnpackedBuildpackages()
 	group, err := lifecycle.ReadGroup(e.groupPath)
 	if err != nil {
 		return cmd.FailErr(err, "read buildpack group")
@@ -142,7 +178,20 @@ func (e *exportCmd) Exec() error {
 		cmd.DefaultLogger.Infof("no stack metadata found at path '%s', stack metadata will not be exported\n", e.stackPath)
 	}
 
-	return e.export(group, cacheStore, analyzedMD)
+	if e.detectCacheEnabled {
+		e.detectCache, err = lifecycle.ReadDetectCache(e.detectCachePath)
+		if os.IsNotExist(err) {
+			cmd.DefaultLogger.Warnf("Keeping cached detect results, no detect cache found at path '%s'", e.detectCachePath)
+			e.detectCacheEnabled = false
+		} else if err != nil {
+			return cmd.FailErr(err, "read detect cache")
+		}
+	}
+
+	metrics := readMetrics(e.metricsPath)
+	start := time.Now()
+	err = e.export(group, cacheStore, analyzedMD)
+	return recordPhase(e.metricsPath, "export", metrics, start, err)
 }
 
 func (ea exportArgs) export(group lifecycle.BuildpackGroup, cacheStore lifecycle.Cache, analyzedMD lifecycle.AnalyzedMetadata) error {
@@ -157,6 +206,11 @@ func (ea exportArgs) export(group lifecycle.BuildpackGroup, cacheStore lifecycle
 		return err
 	}
 
+	requiredMixins, err := lifecycle.RequiredMixins(group.Group, ea.buildpacksDir, os.Getenv(cmd.EnvStackID))
+	if err != nil {
+		return cmd.FailErr(err, "read mixins required by buildpacks")
+	}
+
 	artifactsDir, err := ioutil.TempDir("", "lifecycle.exporter.layer")
 	if err != nil {
 		return cmd.FailErr(err, "create temp directory")
@@ -172,16 +226,40 @@ func (ea exportArgs) export(group lifecycle.BuildpackGroup, cacheStore lifecycle
 		cmd.DefaultLogger.Debugf("no project metadata found at path '%s', project metadata will not be exported\n", ea.projectMetadataPath)
 	}
 
+	secrets, err := lifecycle.ReadSecrets(ea.secretsDir)
+	if err != nil {
+		return cmd.FailErr(err, "read secrets")
+	}
+
+	createdAt, err := creationTime(ea.creationTime, projectMD)
+	if err != nil {
+		return cmd.FailErrCode(err, cmd.CodeInvalidArgs, "determine creation time")
+	}
+	layersModTime := time.Time{}
+	if ea.layerCreationTime {
+		layersModTime = createdAt
+	}
+
+	layerWorkers := ea.exportWorkers
+	if layerWorkers < 1 {
+		layerWorkers = runtime.NumCPU()
+	}
+
 	exporter := &lifecycle.Exporter{
 		Buildpacks: group.Group,
 		LayerFactory: &layers.Factory{
 			ArtifactsDir: artifactsDir,
 			UID:          ea.uid,
 			GID:          ea.gid,
+			ModTime:      layersModTime,
 			Logger:       cmd.DefaultLogger,
 		},
-		Logger:      cmd.DefaultLogger,
-		PlatformAPI: api.MustParse(ea.platformAPI),
+		LayerWorkers:       layerWorkers,
+		MaxLayers:          ea.maxLayers,
+		Logger:             cmd.DefaultLogger,
+		PlatformAPI:        api.MustParse(ea.platformAPI),
+		DetectCache:        ea.detectCache,
+		DetectCacheEnabled: ea.detectCacheEnabled,
 	}
 
 	var appImage imgutil.Image
@@ -194,6 +272,13 @@ func (ea exportArgs) export(group lifecycle.BuildpackGroup, cacheStore lifecycle
 			ea.launchCacheDir,
 			ea.docker,
 		)
+	} else if ea.layoutDir != "" {
+		appImage, runImageID, err = initLayoutImage(
+			ea.imageNames[0],
+			runImageRef,
+			analyzedMD,
+			ea.layoutDir,
+		)
 	} else {
 		appImage, runImageID, err = initRemoteImage(
 			ea.imageNames[0],
@@ -206,15 +291,28 @@ func (ea exportArgs) export(group lifecycle.BuildpackGroup, cacheStore lifecycle
 		return err
 	}
 
+	var reference *lifecycle.VerifyReference
+	if ea.verifyRef != "" {
+		reference, err = ea.initVerifyReference()
+		if err != nil {
+			return err
+		}
+	}
+
 	report, err := exporter.Export(lifecycle.ExportOptions{
 		AdditionalNames:    ea.imageNames[1:],
 		AppDir:             ea.appDir,
+		CreatedAt:          createdAt,
 		DefaultProcessType: ea.processType,
 		LauncherConfig:     launcherConfig(ea.launcherPath),
 		LayersDir:          ea.layersDir,
 		OrigMetadata:       analyzedMD.Metadata,
 		Project:            projectMD,
+		Reference:          reference,
+		RequiredMixins:     requiredMixins,
 		RunImageRef:        runImageID,
+		SBOMDir:            ea.sbomDir,
+		Secrets:            secrets,
 		Stack:              stackMD,
 		WorkingImage:       appImage,
 	})
@@ -224,6 +322,12 @@ func (ea exportArgs) export(group lifecycle.BuildpackGroup, cacheStore lifecycle
 	if err := lifecycle.WriteTOML(ea.reportPath, &report); err != nil {
 		return cmd.FailErrCode(err, cmd.CodeExportError, "write export report")
 	}
+	if report.Verify != nil {
+		if !report.Verify.Identical() {
+			return cmd.FailErrCode(fmt.Errorf("export differs from reference '%s'", reference.Name), cmd.CodeFailedVerify, "verify")
+		}
+		return nil
+	}
 
 	if cacheStore != nil {
 		if cacheErr := exporter.Cache(ea.layersDir, cacheStore); cacheErr != nil {
@@ -233,6 +337,55 @@ func (ea exportArgs) export(group lifecycle.BuildpackGroup, cacheStore lifecycle
 	return nil
 }
 
+// initVerifyReference returns the export report at the verify path if there is one, and otherwise the image
+// it references, from the same daemon, layout or registry that the image would be exported to.
+func (ea exportArgs) initVerifyReference() (*lifecycle.VerifyReference, error) {
+	if fi, err := os.Stat(ea.verifyRef); err == nil && !fi.IsDir() {
+		reference, err := lifecycle.ReadVerifyReference(ea.verifyRef)
+		if err != nil {
+			return nil, cmd.FailErrCode(err, cmd.CodeInvalidArgs, "read reference report")
+		}
+		return reference, nil
+	}
+
+	var refImage imgutil.Image
+	var err error
+	if ea.useDaemon {
+		refImage, err = local.NewImage(ea.verifyRef, ea.docker, local.FromBaseImage(ea.verifyRef))
+	} else if ea.layoutDir != "" {
+		refImage, err = layout.NewImage(ea.verifyRef, ea.layoutDir, layout.FromBaseImage(ea.verifyRef))
+	} else {
+		refImage, err = remote.NewImage(ea.verifyRef, auth.NewKeychain(cmd.EnvRegistryAuth), remote.FromBaseImage(ea.verifyRef))
+	}
+	if err != nil {
+		return nil, cmd.FailErr(err, "access reference image")
+	}
+	reference, err := lifecycle.NewImageVerifyReference(refImage)
+	if err != nil {
+		return nil, cmd.FailErrCode(err, cmd.CodeInvalidArgs, "read reference image")
+	}
+	return reference, nil
+}
+
+// creationTime returns the creation time of the image: the -creation-time flag if it is set, SOURCE_DATE_EPOCH
+// if it is set, and otherwise the commit time in the project metadata, which is zero if the project does not record it.
+func creationTime(value string, projectMD lifecycle.ProjectMetadata) (time.Time, error) {
+	if value == "" {
+		value = os.Getenv(cmd.EnvSourceDateEpoch)
+	}
+	if value == "" {
+		return projectMD.CommitTime()
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0).UTC(), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid creation time '%s', expected seconds since the Unix epoch or RFC 3339 format", value)
+	}
+	return t.UTC(), nil
+}
+
 func initDaemonImage(imagName string, runImageRef string, analyzedMD lifecycle.AnalyzedMetadata, launchCacheDir string, docker client.CommonAPIClient) (imgutil.Image, string, error) {
 	var opts = []local.ImageOption{
 		local.FromBaseImage(runImageRef),
@@ -305,6 +458,36 @@ func initRemoteImage(imageName string, runImageRef string, analyzedMD lifecycle.
 	return appImage, runImageID.String(), nil
 }
 
+func initLayoutImage(imageName string, runImageRef string, analyzedMD lifecycle.AnalyzedMetadata, layoutDir string) (imgutil.Image, string, error) {
+	runImage, err := layout.NewImage(runImageRef, layoutDir, layout.FromBaseImage(runImageRef))
+	if err != nil {
+		return nil, "", cmd.FailErr(err, "access run image")
+	}
+	if !runImage.Found() {
+		return nil, "", fmt.Errorf("run image '%s' not found in layout '%s'", runImageRef, layoutDir)
+	}
+
+	var opts = []layout.ImageOption{
+		layout.FromBaseImage(runImageRef),
+	}
+
+	if analyzedMD.Image != nil {
+		cmd.DefaultLogger.Infof("Reusing layers from image '%s'", analyzedMD.Image.Reference)
+		opts = append(opts, layout.WithPreviousImage(analyzedMD.Image.Reference))
+	}
+
+	appImage, err := layout.NewImage(imageName, layoutDir, opts...)
+	if err != nil {
+		return nil, "", cmd.FailErr(err, "new app image")
+	}
+
+	runImageID, err := runImage.Identifier()
+	if err != nil {
+		return nil, "", cmd.FailErr(err, "get run image reference")
+	}
+	return appImage, runImageID.String(), nil
+}
+
 func launcherConfig(launcherPath string) lifecycle.LauncherConfig {
 	return lifecycle.LauncherConfig{
 		Path: launcherPath,
@@ -4,6 +4,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/buildpacks/lifecycle"
 	"github.com/buildpacks/lifecycle/auth"
@@ -65,6 +66,42 @@ func subcommand() {
 	}
 }
 
+// recordPhase adds the wall time of a phase that started at start to metrics and writes them to path,
+// including when the phase failed. Metrics are only recorded if a path is given, and failing to write them
+// does not fail the phase.
+func recordPhase(path, phase string, metrics *lifecycle.Metrics, start time.Time, phaseErr error) error {
+	metrics.AddPhase(phase, time.Since(start))
+	if path == "" {
+		return phaseErr
+	}
+	if err := lifecycle.WriteTOML(path, metrics); err != nil {
+		cmd.DefaultLogger.Warnf("Failed to write metrics: %s", err)
+	}
+	return phaseErr
+}
+
+// buildSecretsDir returns the directory to read build secrets from, which defaults to the secrets directory
+// of the platform directory.
+func buildSecretsDir(secretsDir, platformDir string) string {
+	if secretsDir != "" {
+		return secretsDir
+	}
+	return filepath.Join(platformDir, "secrets")
+}
+
+// readMetrics returns the metrics written by earlier phases, or empty metrics if they cannot be read.
+func readMetrics(path string) *lifecycle.Metrics {
+	if path == "" {
+		return &lifecycle.Metrics{}
+	}
+	metrics, err := lifecycle.ReadMetrics(path)
+	if err != nil {
+		cmd.DefaultLogger.Warnf("Failed to read metrics, recording them from this phase on: %s", err)
+		return &lifecycle.Metrics{}
+	}
+	return metrics
+}
+
 func verifyBuildpackApis(group lifecycle.BuildpackGroup) error {
 	for _, bp := range group.Group {
 		if bp.API == "" {
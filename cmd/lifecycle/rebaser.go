@@ -14,6 +14,7 @@ import (
 	"github.com/buildpacks/lifecycle/auth"
 	"github.com/buildpacks/lifecycle/cmd"
 	"github.com/buildpacks/lifecycle/image"
+	"github.com/buildpacks/lifecycle/image/layout"
 	"github.com/buildpacks/lifecycle/priv"
 )
 
@@ -23,6 +24,7 @@ type rebaseCmd struct {
 	reportPath            string
 	runImageRef           string
 	deprecatedRunImageRef string
+	layoutDir             string
 	useDaemon             bool
 	uid, gid              int
 
@@ -32,6 +34,7 @@ type rebaseCmd struct {
 
 func (r *rebaseCmd) Init() {
 	cmd.FlagGID(&r.gid)
+	cmd.FlagLayoutDir(&r.layoutDir)
 	cmd.FlagReportPath(&r.reportPath)
 	cmd.FlagRunImage(&r.runImageRef)
 	cmd.FlagUID(&r.uid)
@@ -45,7 +48,10 @@ func (r *rebaseCmd) Args(nargs int, args []string) error {
 		return cmd.FailErrCode(errors.New("at least one image argument is required"), cmd.CodeInvalidArgs, "parse arguments")
 	}
 	r.imageNames = args
-	if err := image.ValidateDestinationTags(r.useDaemon, r.imageNames...); err != nil {
+	if r.useDaemon && r.layoutDir != "" {
+		return cmd.FailErrCode(errors.New("supply only one of -daemon or -layout"), cmd.CodeInvalidArgs, "parse arguments")
+	}
+	if err := image.ValidateDestinationTags(r.useDaemon || r.layoutDir != "", r.imageNames...); err != nil {
 		return cmd.FailErrCode(err, cmd.CodeInvalidArgs, "validate image tag(s)")
 	}
 
@@ -86,6 +92,12 @@ func (r *rebaseCmd) Exec() error {
 			r.docker,
 			local.FromBaseImage(r.imageNames[0]),
 		)
+	} else if r.layoutDir != "" {
+		appImage, err = layout.NewImage(
+			r.imageNames[0],
+			r.layoutDir,
+			layout.FromBaseImage(r.imageNames[0]),
+		)
 	} else {
 		appImage, err = remote.NewImage(
 			r.imageNames[0],
@@ -119,6 +131,12 @@ func (r *rebaseCmd) Exec() error {
 			r.docker,
 			local.FromBaseImage(r.runImageRef),
 		)
+	} else if r.layoutDir != "" {
+		newBaseImage, err = layout.NewImage(
+			r.runImageRef,
+			r.layoutDir,
+			layout.FromBaseImage(r.runImageRef),
+		)
 	} else {
 		newBaseImage, err = remote.NewImage(
 			r.imageNames[0],
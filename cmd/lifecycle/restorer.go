@@ -3,6 +3,7 @@ package main
 import (
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/buildpacks/lifecycle"
 	"github.com/buildpacks/lifecycle/cmd"
@@ -16,6 +17,9 @@ type restoreCmd struct {
 	groupPath     string
 	layersDir     string
 	uid, gid      int
+
+	// flags: paths to write outputs
+	metricsPath string
 }
 
 func (r *restoreCmd) Init() {
@@ -23,6 +27,7 @@ func (r *restoreCmd) Init() {
 	cmd.FlagCacheImage(&r.cacheImageTag)
 	cmd.FlagGroupPath(&r.groupPath)
 	cmd.FlagLayersDir(&r.layersDir)
+	cmd.FlagMetricsPath(&r.metricsPath)
 	cmd.FlagUID(&r.uid)
 	cmd.FlagGID(&r.gid)
 }
@@ -59,7 +64,10 @@ func (r *restoreCmd) Exec() error {
 	if err != nil {
 		return err
 	}
-	return restore(r.layersDir, group, cacheStore)
+	metrics := readMetrics(r.metricsPath)
+	start := time.Now()
+	err = restore(r.layersDir, group, cacheStore)
+	return recordPhase(r.metricsPath, "restore", metrics, start, err)
 }
 
 func restore(layersDir string, group lifecycle.BuildpackGroup, cacheStore lifecycle.Cache) error {
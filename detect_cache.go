@@ -0,0 +1,183 @@
+package lifecycle
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+)
+
+// DetectCacheEntry is a detect result stored in the cache metadata. Its key is a digest of the
+// buildpack ID, version and contents, the app directory and the platform env.
+type DetectCacheEntry struct {
+	Key    string `toml:"key" json:"key"`
+	Code   int    `toml:"code" json:"code"`
+	Output string `toml:"output,omitempty" json:"output,omitempty"`
+	Plan   string `toml:"plan,omitempty" json:"plan,omitempty"`
+}
+
+type detectCacheFile struct {
+	Entries []DetectCacheEntry `toml:"entries"`
+}
+
+// ReadDetectCache reads the detect cache entries written by the detector for the exporter to store in the cache.
+func ReadDetectCache(path string) ([]DetectCacheEntry, error) {
+	var detectCache detectCacheFile
+	_, err := toml.DecodeFile(path, &detectCache)
+	return detectCache.Entries, err
+}
+
+func WriteDetectCache(path string, entries []DetectCacheEntry) error {
+	return WriteTOML(path, detectCacheFile{Entries: entries})
+}
+
+// DetectCache reuses detect results from a previous build when neither the app nor the buildpack changed.
+// Buildpacks that set skip-detect-cache in buildpack.toml are always detected.
+type DetectCache struct {
+	mu       sync.Mutex
+	previous map[string]DetectCacheEntry
+	used     map[string]DetectCacheEntry
+
+	once        sync.Once
+	fingerprint string
+	err         error
+}
+
+func NewDetectCache(entries []DetectCacheEntry) *DetectCache {
+	previous := map[string]DetectCacheEntry{}
+	for _, entry := range entries {
+		previous[entry.Key] = entry
+	}
+	return &DetectCache{previous: previous, used: map[string]DetectCacheEntry{}}
+}
+
+// Entries returns the entries used or added by detection, which should be stored for the next build.
+func (dc *DetectCache) Entries() []DetectCacheEntry {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	var entries []DetectCacheEntry
+	for _, entry := range dc.used {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Key < entries[j].Key
+	})
+	return entries
+}
+
+// fingerprintApp fingerprints the app and the platform env, once, before detection starts.
+func (dc *DetectCache) fingerprintApp(c *DetectConfig) {
+	dc.once.Do(func() {
+		dc.fingerprint, dc.err = fingerprintDirs(c.AppDir, filepath.Join(c.PlatformDir, "env"))
+		if dc.err != nil {
+			c.Logger.Warnf("Not using detect cache: %s", dc.err)
+		}
+	})
+}
+
+// key returns the cache key for the buildpack, or an empty string if its result cannot be cached.
+func (dc *DetectCache) key(info *BuildpackTOML) (string, error) {
+	if info.Buildpack.SkipDetectCache || dc.err != nil {
+		return "", nil
+	}
+	bpDigest, err := fingerprintDirs(info.Path)
+	if err != nil {
+		return "", err
+	}
+	hasher := sha256.New()
+	fmt.Fprintf(hasher, "%s\x00%s\x00%s\x00%s", info.Buildpack.ID, info.Buildpack.Version, bpDigest, dc.fingerprint)
+	return fmt.Sprintf("%x", hasher.Sum(nil)), nil
+}
+
+func (dc *DetectCache) lookup(key string) (DetectRun, bool) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	entry, ok := dc.previous[key]
+	if !ok {
+		return DetectRun{}, false
+	}
+	run := DetectRun{Code: entry.Code, Output: []byte(entry.Output)}
+	if _, err := toml.Decode(entry.Plan, &run); err != nil {
+		return DetectRun{}, false
+	}
+	dc.used[key] = entry
+	return run, true
+}
+
+// store records the result of a detection, unless it errored or timed out.
+func (dc *DetectCache) store(key string, run DetectRun) {
+	if run.Code != CodeDetectPass && run.Code != CodeDetectFail {
+		return
+	}
+	plan := &bytes.Buffer{}
+	if err := toml.NewEncoder(plan).Encode(run); err != nil {
+		return
+	}
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	dc.used[key] = DetectCacheEntry{
+		Key:    key,
+		Code:   run.Code,
+		Output: string(run.Output),
+		Plan:   plan.String(),
+	}
+}
+
+// fingerprintDirs returns a digest of the names, modes and contents of everything in dirs.
+// Missing directories are treated as empty.
+func fingerprintDirs(dirs ...string) (string, error) {
+	hasher := sha256.New()
+	for _, dir := range dirs {
+		fmt.Fprintf(hasher, "%s\x00", filepath.Base(dir))
+		err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+			if err != nil {
+				if path == dir && os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(hasher, "%s\x00%s\x00", filepath.ToSlash(rel), fi.Mode())
+			if fi.Mode()&os.ModeSymlink != 0 {
+				target, err := os.Readlink(path)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(hasher, "%s\x00", target)
+			}
+			if fi.IsDir() {
+				return nil
+			}
+			return hashFile(hasher, path)
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("%x", hasher.Sum(nil)), nil
+}
+
+// hashFile writes the contents of the file at path to w, following symlinks.
+// Dangling symlinks and other files that cannot be read as regular files contribute nothing.
+func hashFile(w io.Writer, path string) error {
+	fi, err := os.Stat(path)
+	if err != nil || !fi.Mode().IsRegular() {
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
@@ -0,0 +1,115 @@
+package lifecycle
+
+// DetectReport describes every group tried during detection, why each one passed or failed,
+// which group was finally selected, and which buildpacks provide each requirement of its build plan.
+type DetectReport struct {
+	Groups   []DetectGroupReport   `toml:"groups" json:"groups"`
+	Selected []Buildpack           `toml:"selected,omitempty" json:"selected,omitempty"`
+	Requires []DetectRequireReport `toml:"requires,omitempty" json:"requires,omitempty"`
+}
+
+type DetectGroupReport struct {
+	Buildpacks []DetectBuildpackReport `toml:"buildpacks" json:"buildpacks"`
+	Trials     []DetectTrialReport     `toml:"trials,omitempty" json:"trials,omitempty"`
+	Pass       bool                    `toml:"pass" json:"pass"`
+}
+
+type DetectBuildpackReport struct {
+	Buildpack
+	Result   string `toml:"result" json:"result"`
+	Code     int    `toml:"code" json:"code"`
+	Output   string `toml:"output,omitempty" json:"output,omitempty"`
+	Err      string `toml:"error,omitempty" json:"error,omitempty"`
+	Platform string `toml:"platform,omitempty" json:"platform,omitempty"`
+}
+
+type DetectTrialReport struct {
+	Options []DetectOptionReport `toml:"options" json:"options"`
+	Unmet   []DetectUnmetReport  `toml:"unmet,omitempty" json:"unmet,omitempty"`
+	Pass    bool                 `toml:"pass" json:"pass"`
+}
+
+type DetectOptionReport struct {
+	Buildpack
+	Requires []string `toml:"requires,omitempty" json:"requires,omitempty"`
+	Provides []string `toml:"provides,omitempty" json:"provides,omitempty"`
+}
+
+// DetectUnmetReport records a buildpack that was skipped or failed during a trial
+// because one of its requires was not provided or one of its provides was not required.
+// If a require was provided with a version that does not match its constraint,
+// the provider and the provided version are also recorded.
+type DetectUnmetReport struct {
+	Buildpack       Buildpack `toml:"buildpack" json:"buildpack"`
+	Requires        string    `toml:"requires,omitempty" json:"requires,omitempty"`
+	Provides        string    `toml:"provides,omitempty" json:"provides,omitempty"`
+	Constraint      string    `toml:"constraint,omitempty" json:"constraint,omitempty"`
+	Provider        string    `toml:"provider,omitempty" json:"provider,omitempty"`
+	ProvidedVersion string    `toml:"provided-version,omitempty" json:"provided-version,omitempty"`
+}
+
+// DetectRequireReport records a requirement of the selected build plan and the buildpacks that provide it.
+type DetectRequireReport struct {
+	Buildpack  Buildpack   `toml:"buildpack" json:"buildpack"`
+	Requires   string      `toml:"requires" json:"requires"`
+	Constraint string      `toml:"constraint,omitempty" json:"constraint,omitempty"`
+	Providers  []Buildpack `toml:"providers" json:"providers"`
+}
+
+func (r *DetectReport) addGroup(group DetectGroupReport) {
+	r.Groups = append(r.Groups, group)
+}
+
+func (r *DetectReport) lastGroup() *DetectGroupReport {
+	return &r.Groups[len(r.Groups)-1]
+}
+
+func (r *DetectReport) addTrial(trial detectTrial) *DetectTrialReport {
+	var options []DetectOptionReport
+	for _, option := range trial {
+		o := DetectOptionReport{Buildpack: option.Buildpack.noAPI()}
+		for _, req := range option.Requires {
+			o.Requires = append(o.Requires, req.Name)
+		}
+		for _, p := range option.Provides {
+			o.Provides = append(o.Provides, p.Name)
+		}
+		options = append(options, o)
+	}
+	group := r.lastGroup()
+	group.Trials = append(group.Trials, DetectTrialReport{Options: options})
+	return &group.Trials[len(group.Trials)-1]
+}
+
+func (r *DetectReport) setSelected(trial detectTrial) {
+	providers := map[string][]Buildpack{}
+	for _, option := range trial {
+		bp := option.Buildpack.noOpt().noAPI()
+		r.Selected = append(r.Selected, bp)
+		for _, p := range option.Provides {
+			providers[p.Name] = append(providers[p.Name], bp)
+		}
+		for _, req := range option.Requires {
+			r.Requires = append(r.Requires, DetectRequireReport{
+				Buildpack:  bp,
+				Requires:   req.Name,
+				Constraint: req.versionConstraint(),
+				Providers:  append([]Buildpack(nil), providers[req.Name]...),
+			})
+		}
+	}
+}
+
+func detectResultName(run DetectRun, optional bool) string {
+	switch run.Code {
+	case CodeDetectPass:
+		return "pass"
+	case CodeDetectFail:
+		if optional {
+			return "skip"
+		}
+		return "fail"
+	default:
+		return "err"
+	}
+}
@@ -4,13 +4,18 @@ import (
 	"bytes"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/BurntSushi/toml"
+	"github.com/Masterminds/semver/v3"
 	"github.com/pkg/errors"
 
 	"github.com/buildpacks/lifecycle/api"
@@ -25,6 +30,7 @@ const (
 var (
 	errFailedDetection = errors.New("no buildpacks participating")
 	errBuildpack       = errors.New("buildpack(s) failed with err")
+	errTimeout         = errors.New("buildpack(s) timed out")
 )
 
 type BuildPlan struct {
@@ -85,8 +91,38 @@ func (r *Require) hasTopLevelVersions() bool {
 	return r.Version != ""
 }
 
+func (r *Require) versionConstraint() string {
+	if r.Version != "" {
+		return r.Version
+	}
+	if version, ok := r.Metadata["version"]; ok {
+		return fmt.Sprintf("%v", version)
+	}
+	return ""
+}
+
+// satisfiedBy returns true if the provided version matches the semver constraint of the requirement.
+// Requirements without a version and provides without a version always match. Versions that are equal
+// match, and otherwise the versions are not compared and an error says why if either is not semver.
+func (r *Require) satisfiedBy(version string) (bool, error) {
+	constraint := r.versionConstraint()
+	if constraint == "" || version == "" || constraint == version {
+		return true, nil
+	}
+	c, err := semver.NewConstraint(constraint)
+	if err != nil {
+		return true, errors.Errorf("version constraint '%s' is not semver", constraint)
+	}
+	v, err := semver.NewVersion(version)
+	if err != nil {
+		return true, errors.Errorf("version '%s' is not semver", version)
+	}
+	return c.Check(v), nil
+}
+
 type Provide struct {
-	Name string `toml:"name"`
+	Name    string `toml:"name"`
+	Version string `toml:"version,omitempty"`
 }
 
 type DetectConfig struct {
@@ -96,7 +132,146 @@ type DetectConfig struct {
 	PlatformDir   string
 	BuildpacksDir string
 	Logger        Logger
+	Timeout       time.Duration
+	Workers       int
+	Platform      PlatformBuildpacks
+	Cache         *DetectCache
+	Stack         Stack
+	Metrics       *Metrics
+	LogsDir       string // if set, the output of each buildpack is also written to <LogsDir>/<buildpack ID>/detect.log
 	runs          *sync.Map
+	started       *sync.Map // the *sync.Once of each buildpack whose detection has started
+	workers       chan struct{}
+	report        *DetectReport
+	logs          *detectLogs
+	warned        map[string]bool // the buildpacks whose warnings were logged
+}
+
+// PlatformBuildpacks lists buildpacks, by ID or by ID@version, that the platform excludes from
+// detection or requires to pass detection regardless of whether the order marks them optional.
+type PlatformBuildpacks struct {
+	Exclude []string `toml:"exclude"`
+	Require []string `toml:"require"`
+}
+
+func (p PlatformBuildpacks) excludes(bp Buildpack) bool {
+	return matchesBuildpack(p.Exclude, bp)
+}
+
+func (p PlatformBuildpacks) requires(bp Buildpack) bool {
+	return matchesBuildpack(p.Require, bp)
+}
+
+func matchesBuildpack(refs []string, bp Buildpack) bool {
+	for _, ref := range refs {
+		if ref == bp.ID || ref == bp.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// platformNote describes how the platform changed the detection of the buildpack, if at all.
+func (c *DetectConfig) platformNote(bp Buildpack) string {
+	switch {
+	case c.Platform.excludes(bp):
+		return " (excluded by platform)"
+	case c.Platform.requires(bp):
+		return " (required by platform)"
+	}
+	return ""
+}
+
+// logNote points to the log file capturing the output of the buildpack, if any.
+func (c *DetectConfig) logNote(bp Buildpack) string {
+	if c.LogsDir == "" {
+		return ""
+	}
+	return fmt.Sprintf(" (log: %s)", buildpackLogPath(c.LogsDir, bp.ID, "detect"))
+}
+
+func (c *DetectConfig) init() {
+	if c.runs == nil {
+		c.runs = &sync.Map{}
+	}
+	if c.started == nil {
+		c.started = &sync.Map{}
+	}
+	if c.workers == nil && c.Workers > 0 {
+		c.workers = make(chan struct{}, c.Workers)
+	}
+	c.report = &DetectReport{}
+	c.logs = &detectLogs{written: map[string]bool{}}
+	c.warned = map[string]bool{}
+	if c.Cache != nil {
+		c.Cache.fingerprintApp(c)
+	}
+}
+
+// Report returns a description of every group tried by the last call to Detect.
+func (c *DetectConfig) Report() DetectReport {
+	if c.report == nil {
+		return DetectReport{}
+	}
+	return *c.report
+}
+
+// detect runs the detection of the buildpack once, however many groups it is in.
+// Callers for the same buildpack wait until the first one has recorded the result.
+func (c *DetectConfig) detect(key string, info *BuildpackTOML) {
+	once, _ := c.started.LoadOrStore(key, &sync.Once{})
+	once.(*sync.Once).Do(func() {
+		if _, ok := c.runs.Load(key); !ok {
+			c.runs.Store(key, c.runDetect(key, info))
+		}
+	})
+}
+
+// writeCachedLog replaces the detect log of the buildpack from an earlier detection with a note that the cached
+// result was used, and returns a warning if the note cannot be written.
+func (c *DetectConfig) writeCachedLog(key string, info *BuildpackTOML) []string {
+	if c.LogsDir == "" {
+		return nil
+	}
+	note := fmt.Sprintf("Using the detect result of %s cached by an earlier build, /bin/detect was not run\n", key)
+	if err := c.logs.write(buildpackLogPath(c.LogsDir, info.Buildpack.ID, "detect"), []byte(note)); err != nil {
+		return []string{fmt.Sprintf("Failed to write detect log for buildpack %s: %s", info.Buildpack.ID, err)}
+	}
+	return nil
+}
+
+// runDetect runs the detection of the buildpack, or returns its cached result. Warnings are recorded in the result
+// instead of being logged, so that they are logged in group order once detection of the group is done.
+func (c *DetectConfig) runDetect(key string, info *BuildpackTOML) DetectRun {
+	if c.Stack.ID != "" {
+		if err := info.checkStack(c.Stack); err != nil {
+			return DetectRun{Code: CodeDetectFail, Err: err, Warnings: []string{fmt.Sprintf("Not detecting %s: %s", key, err)}}
+		}
+	}
+	var cacheKey string
+	var warnings []string
+	if c.Cache != nil {
+		var err error
+		if cacheKey, err = c.Cache.key(info); err != nil {
+			warnings = append(warnings, fmt.Sprintf("Not using detect cache for buildpack %s: %s", key, err))
+		} else if cacheKey != "" {
+			if run, ok := c.Cache.lookup(cacheKey); ok {
+				run.cached = true
+				run.Warnings = append(warnings, c.writeCachedLog(key, info)...)
+				return run
+			}
+		}
+	}
+	if c.workers != nil {
+		c.workers <- struct{}{}
+		defer func() { <-c.workers }()
+	}
+	run := info.Detect(c)
+	if cacheKey != "" {
+		c.Cache.store(cacheKey, run)
+	}
+	run.Warnings = append(warnings, run.Warnings...)
+	return run
 }
 
 func (c *DetectConfig) process(done []Buildpack) ([]Buildpack, []BuildPlanEntry, error) {
@@ -107,6 +282,15 @@ func (c *DetectConfig) process(done []Buildpack) ([]Buildpack, []BuildPlanEntry,
 			return nil, nil, errors.Errorf("missing detection of '%s'", bp)
 		}
 		run := t.(DetectRun)
+		if !c.warned[bp.String()] {
+			c.warned[bp.String()] = true
+			if run.cached {
+				c.Logger.Debugf("Using cached detect result for %s", bp)
+			}
+			for _, warning := range run.Warnings {
+				c.Logger.Warn(warning)
+			}
+		}
 		outputLogf := c.Logger.Debugf
 
 		switch run.Code {
@@ -128,44 +312,69 @@ func (c *DetectConfig) process(done []Buildpack) ([]Buildpack, []BuildPlanEntry,
 
 	c.Logger.Debugf("======== Results ========")
 
+	groupReport := DetectGroupReport{}
+	for i, bp := range done {
+		bpReport := DetectBuildpackReport{
+			Buildpack: bp.noAPI(),
+			Result:    detectResultName(runs[i], bp.Optional),
+			Code:      runs[i].Code,
+			Output:    string(runs[i].Output),
+		}
+		if c.Platform.excludes(bp) {
+			bpReport.Platform = "excluded"
+		} else if c.Platform.requires(bp) {
+			bpReport.Platform = "required"
+		}
+		if runs[i].Err != nil {
+			bpReport.Err = runs[i].Err.Error()
+		}
+		groupReport.Buildpacks = append(groupReport.Buildpacks, bpReport)
+	}
+	c.report.addGroup(groupReport)
+
 	results := detectResults{}
 	detected := true
 	buildpackErr := false
+	timedOut := false
 	for i, bp := range done {
 		run := runs[i]
 		switch run.Code {
 		case CodeDetectPass:
-			c.Logger.Debugf("pass: %s", bp)
+			c.Logger.Debugf("pass: %s%s", bp, c.platformNote(bp))
 			results = append(results, detectResult{bp, run})
 		case CodeDetectFail:
 			if bp.Optional {
-				c.Logger.Debugf("skip: %s", bp)
+				c.Logger.Debugf("skip: %s%s", bp, c.platformNote(bp))
 			} else {
-				c.Logger.Debugf("fail: %s", bp)
+				c.Logger.Debugf("fail: %s%s%s", bp, c.platformNote(bp), c.logNote(bp))
 			}
 			detected = detected && bp.Optional
 		case -1:
-			c.Logger.Infof("err:  %s", bp)
+			if isTimeout(run.Err) {
+				c.Logger.Infof("err:  %s (%s)%s", bp, run.Err, c.logNote(bp))
+				timedOut = true
+			} else {
+				c.Logger.Infof("err:  %s%s", bp, c.logNote(bp))
+			}
 			buildpackErr = true
 			detected = detected && bp.Optional
 		default:
-			c.Logger.Infof("err:  %s (%d)", bp, run.Code)
+			c.Logger.Infof("err:  %s (%d)%s", bp, run.Code, c.logNote(bp))
 			buildpackErr = true
 			detected = detected && bp.Optional
 		}
 	}
 	if !detected {
+		if timedOut {
+			return nil, nil, errTimeout
+		}
 		if buildpackErr {
 			return nil, nil, errBuildpack
 		}
 		return nil, nil, errFailedDetection
 	}
 
-	i := 0
-	deps, trial, err := results.runTrials(func(trial detectTrial) (depMap, detectTrial, error) {
-		i++
-		return c.runTrial(i, trial)
-	})
+	deps, trial, err := results.solve(c.runTrial, c.rejectTrial)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -196,11 +405,14 @@ func (c *DetectConfig) process(done []Buildpack) ([]Buildpack, []BuildPlanEntry,
 	for _, dep := range deps {
 		plan = append(plan, dep.BuildPlanEntry.noOpt())
 	}
+	c.report.lastGroup().Pass = true
+	c.report.setSelected(trial)
 	return found, plan, nil
 }
 
 func (c *DetectConfig) runTrial(i int, trial detectTrial) (depMap, detectTrial, error) {
 	c.Logger.Debugf("Resolving plan... (try #%d)", i)
+	trialReport := c.report.addTrial(trial)
 
 	var deps depMap
 	retry := true
@@ -210,6 +422,7 @@ func (c *DetectConfig) runTrial(i int, trial detectTrial) (depMap, detectTrial,
 
 		if err := deps.eachUnmetRequire(func(name string, bp Buildpack) error {
 			retry = true
+			trialReport.Unmet = append(trialReport.Unmet, DetectUnmetReport{Buildpack: bp.noAPI(), Requires: name})
 			if !bp.Optional {
 				c.Logger.Debugf("fail: %s requires %s", bp, name)
 				return errFailedDetection
@@ -221,8 +434,29 @@ func (c *DetectConfig) runTrial(i int, trial detectTrial) (depMap, detectTrial,
 			return nil, nil, err
 		}
 
+		if err := deps.eachMismatchedRequire(func(name string, m versionMismatch) error {
+			retry = true
+			trialReport.Unmet = append(trialReport.Unmet, DetectUnmetReport{
+				Buildpack:       m.bp.noAPI(),
+				Requires:        name,
+				Constraint:      m.constraint,
+				Provider:        m.provider.String(),
+				ProvidedVersion: m.version,
+			})
+			if !m.bp.Optional {
+				c.Logger.Debugf("fail: %s requires %s@%s, %s provides %s@%s", m.bp, name, m.constraint, m.provider, name, m.version)
+				return errFailedDetection
+			}
+			c.Logger.Debugf("skip: %s requires %s@%s, %s provides %s@%s", m.bp, name, m.constraint, m.provider, name, m.version)
+			trial = trial.remove(m.bp)
+			return nil
+		}); err != nil {
+			return nil, nil, err
+		}
+
 		if err := deps.eachUnmetProvide(func(name string, bp Buildpack) error {
 			retry = true
+			trialReport.Unmet = append(trialReport.Unmet, DetectUnmetReport{Buildpack: bp.noAPI(), Provides: name})
 			if !bp.Optional {
 				c.Logger.Debugf("fail: %s provides unused %s", bp, name)
 				return errFailedDetection
@@ -239,10 +473,39 @@ func (c *DetectConfig) runTrial(i int, trial detectTrial) (depMap, detectTrial,
 		c.Logger.Debugf("fail: no viable buildpacks in group")
 		return nil, nil, errFailedDetection
 	}
+	deps.eachUncheckedRequire(func(name string, m versionMismatch) {
+		c.Logger.Warnf("Not checking that %s@%s provided by %s meets the requirement %s@%s of %s: %s", name, m.version, m.provider, name, m.constraint, m.bp, m.err)
+	})
+	trialReport.Pass = true
 	return deps, trial, nil
 }
 
-func (bp *BuildpackTOML) Detect(c *DetectConfig) DetectRun {
+// rejectTrial records a partial trial that conflicts, which stands for the complete trials numbered first to last.
+func (c *DetectConfig) rejectTrial(first, last int, trial detectTrial, unmet DetectUnmetReport) error {
+	if first == last {
+		c.Logger.Debugf("Resolving plan... (try #%d)", first)
+	} else {
+		c.Logger.Debugf("Resolving plan... (tries #%d-#%d)", first, last)
+	}
+	switch {
+	case unmet.Constraint != "":
+		c.Logger.Debugf("fail: %s requires %s@%s, %s provides %s@%s", unmet.Buildpack, unmet.Requires, unmet.Constraint, unmet.Provider, unmet.Requires, unmet.ProvidedVersion)
+	case unmet.Requires != "":
+		c.Logger.Debugf("fail: %s requires %s", unmet.Buildpack, unmet.Requires)
+	default:
+		c.Logger.Debugf("fail: %s provides unused %s", unmet.Buildpack, unmet.Provides)
+	}
+	unmet.Buildpack = unmet.Buildpack.noAPI()
+	trialReport := c.report.addTrial(trial)
+	trialReport.Unmet = append(trialReport.Unmet, unmet)
+	return errFailedDetection
+}
+
+func (bp *BuildpackTOML) Detect(c *DetectConfig) (run DetectRun) {
+	var warnings []string
+	defer func() {
+		run.Warnings = append(warnings, run.Warnings...)
+	}()
 	appDir, err := filepath.Abs(c.AppDir)
 	if err != nil {
 		return DetectRun{Code: -1, Err: err}
@@ -277,7 +540,15 @@ func (bp *BuildpackTOML) Detect(c *DetectConfig) DetectRun {
 	}
 	cmd.Env = append(cmd.Env, EnvBuildpackDir+"="+bp.Path)
 
-	if err := cmd.Run(); err != nil {
+	start := time.Now()
+	err = runCmd(cmd, c.Timeout, nil)
+	c.Metrics.addDetect(newProcessMetrics(bp.Buildpack.ID, bp.Buildpack.Version, cmd, time.Since(start)))
+	if c.LogsDir != "" {
+		if err := c.logs.write(buildpackLogPath(c.LogsDir, bp.Buildpack.ID, "detect"), out.Bytes()); err != nil {
+			warnings = append(warnings, fmt.Sprintf("Failed to write detect log for buildpack %s: %s", bp.Buildpack.ID, err))
+		}
+	}
+	if err != nil {
 		if err, ok := err.(*exec.ExitError); ok {
 			if status, ok := err.Sys().(syscall.WaitStatus); ok {
 				return DetectRun{Code: status.ExitStatus(), Output: out.Bytes()}
@@ -303,7 +574,7 @@ func (bp *BuildpackTOML) Detect(c *DetectConfig) DetectRun {
 	}
 	if api.MustParse(bp.API).Compare(api.MustParse("0.3")) >= 0 {
 		if t.hasTopLevelVersions() || t.Or.hasTopLevelVersions() {
-			c.Logger.Warnf(`Warning: buildpack %s has a "version" key. This key is deprecated in build plan requirements in buildpack API 0.3. "metadata.version" should be used instead`, bp.Buildpack.ID)
+			warnings = append(warnings, fmt.Sprintf(`Warning: buildpack %s has a "version" key. This key is deprecated in build plan requirements in buildpack API 0.3. "metadata.version" should be used instead`, bp.Buildpack.ID))
 		}
 	}
 	t.Output = out.Bytes()
@@ -315,11 +586,14 @@ type BuildpackGroup struct {
 }
 
 func (bg BuildpackGroup) Detect(c *DetectConfig) (BuildpackGroup, BuildPlan, error) {
-	if c.runs == nil {
-		c.runs = &sync.Map{}
+	c.init()
+	if err := (BuildpackOrder{bg}).CheckCycles(c.BuildpacksDir, c.Platform); err != nil {
+		return BuildpackGroup{}, BuildPlan{}, err
 	}
 	bps, entries, err := bg.detect(nil, &sync.WaitGroup{}, c)
-	if err == errBuildpack {
+	if err == errTimeout {
+		err = NewLifecycleError(err, ErrTypeBuildpackTimeout)
+	} else if err == errBuildpack {
 		err = NewLifecycleError(err, ErrTypeBuildpack)
 	} else if err == errFailedDetection {
 		err = NewLifecycleError(err, ErrTypeFailedDetection)
@@ -334,7 +608,6 @@ func (bg BuildpackGroup) Detect(c *DetectConfig) (BuildpackGroup, BuildPlan, err
 
 func (bg BuildpackGroup) detect(done []Buildpack, wg *sync.WaitGroup, c *DetectConfig) ([]Buildpack, []BuildPlanEntry, error) {
 	for i, bp := range bg.Group {
-		key := bp.String()
 		if hasID(done, bp.ID) {
 			continue
 		}
@@ -342,18 +615,28 @@ func (bg BuildpackGroup) detect(done []Buildpack, wg *sync.WaitGroup, c *DetectC
 		if err != nil {
 			return nil, nil, err
 		}
+		// record the exact version found so that later phases do not need to resolve it again
+		bp.Version = info.Buildpack.Version
 		bp.API = info.API
+		key := bp.String()
+		if c.Platform.excludes(bp) {
+			// excluded buildpacks are never run and act like optional buildpacks that failed detection
+			bp.Optional = true
+			done = append(done, bp)
+			c.runs.LoadOrStore(key, DetectRun{Code: CodeDetectFail})
+			continue
+		}
+		if c.Platform.requires(bp) {
+			bp.Optional = false
+		}
 		if info.Order != nil {
 			// TODO: double-check slice safety here
-			// FIXME: cyclical references lead to infinite recursion
 			return info.Order.detect(done, bg.Group[i+1:], bp.Optional, wg, c)
 		}
 		done = append(done, bp)
 		wg.Add(1)
 		go func() {
-			if _, ok := c.runs.Load(key); !ok {
-				c.runs.Store(key, info.Detect(c))
-			}
+			c.detect(key, info)
 			wg.Done()
 		}()
 	}
@@ -373,11 +656,14 @@ func (bg BuildpackGroup) append(group ...BuildpackGroup) BuildpackGroup {
 type BuildpackOrder []BuildpackGroup
 
 func (bo BuildpackOrder) Detect(c *DetectConfig) (BuildpackGroup, BuildPlan, error) {
-	if c.runs == nil {
-		c.runs = &sync.Map{}
+	c.init()
+	if err := bo.CheckCycles(c.BuildpacksDir, c.Platform); err != nil {
+		return BuildpackGroup{}, BuildPlan{}, err
 	}
 	bps, entries, err := bo.detect(nil, nil, false, &sync.WaitGroup{}, c)
-	if err == errBuildpack {
+	if err == errTimeout {
+		err = NewLifecycleError(err, ErrTypeBuildpackTimeout)
+	} else if err == errBuildpack {
 		err = NewLifecycleError(err, ErrTypeBuildpack)
 	} else if err == errFailedDetection {
 		err = NewLifecycleError(err, ErrTypeFailedDetection)
@@ -393,13 +679,17 @@ func (bo BuildpackOrder) Detect(c *DetectConfig) (BuildpackGroup, BuildPlan, err
 func (bo BuildpackOrder) detect(done, next []Buildpack, optional bool, wg *sync.WaitGroup, c *DetectConfig) ([]Buildpack, []BuildPlanEntry, error) {
 	ngroup := BuildpackGroup{Group: next}
 	buildpackErr := false
+	timedOut := false
 	for _, group := range bo {
 		// FIXME: double-check slice safety here
 		found, plan, err := group.append(ngroup).detect(done, wg, c)
 		if err == errBuildpack {
 			buildpackErr = true
 		}
-		if err == errFailedDetection || err == errBuildpack {
+		if err == errTimeout {
+			timedOut = true
+		}
+		if err == errFailedDetection || err == errBuildpack || err == errTimeout {
 			wg = &sync.WaitGroup{}
 			continue
 		}
@@ -409,12 +699,79 @@ func (bo BuildpackOrder) detect(done, next []Buildpack, optional bool, wg *sync.
 		return ngroup.detect(done, wg, c)
 	}
 
+	if timedOut {
+		return nil, nil, errTimeout
+	}
 	if buildpackErr {
 		return nil, nil, errBuildpack
 	}
 	return nil, nil, errFailedDetection
 }
 
+// CheckCycles expands every meta-buildpack in the order without running detection
+// and returns an error of type ErrTypeOrderCycle if any order references itself.
+// Buildpacks that cannot be found or that the platform excludes are skipped, as detection
+// only fails for them if it reaches them.
+func (bo BuildpackOrder) CheckCycles(buildpacksDir string, platform PlatformBuildpacks) error {
+	err := bo.checkCycles(nil, map[string]bool{}, buildpacksDir, platform)
+	if _, ok := err.(*cycleError); ok {
+		return NewLifecycleError(err, ErrTypeOrderCycle)
+	}
+	return err
+}
+
+func (bo BuildpackOrder) checkCycles(path []Buildpack, checked map[string]bool, buildpacksDir string, platform PlatformBuildpacks) error {
+	for _, group := range bo {
+		for _, bp := range group.Group {
+			if err := checkCycles(bp, path, checked, buildpacksDir, platform); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func checkCycles(bp Buildpack, path []Buildpack, checked map[string]bool, buildpacksDir string, platform PlatformBuildpacks) error {
+	info, err := bp.Lookup(buildpacksDir)
+	if err != nil {
+		return nil
+	}
+	// compare the versions detection resolves to, as different constraints may resolve to the same version
+	bp.Version = info.Buildpack.Version
+	if platform.excludes(bp) {
+		return nil
+	}
+	key := bp.String()
+	for i, p := range path {
+		if p.String() == key {
+			cycle := append([]Buildpack{}, path[i:]...)
+			return &cycleError{path: append(cycle, bp)}
+		}
+	}
+	if checked[key] {
+		return nil
+	}
+	if info.Order != nil {
+		if err := info.Order.checkCycles(append(path, bp), checked, buildpacksDir, platform); err != nil {
+			return err
+		}
+	}
+	checked[key] = true
+	return nil
+}
+
+type cycleError struct {
+	path []Buildpack
+}
+
+func (e *cycleError) Error() string {
+	var ids []string
+	for _, bp := range e.path {
+		ids = append(ids, bp.String())
+	}
+	return "cyclical reference in buildpack order: " + strings.Join(ids, " -> ")
+}
+
 func hasID(bps []Buildpack, id string) bool {
 	for _, bp := range bps {
 		if bp.ID == id {
@@ -426,10 +783,13 @@ func hasID(bps []Buildpack, id string) bool {
 
 type DetectRun struct {
 	planSections
-	Or     planSectionsList `toml:"or"`
-	Output []byte           `toml:"-"`
-	Code   int              `toml:"-"`
-	Err    error            `toml:"-"`
+	Or       planSectionsList `toml:"or"`
+	Output   []byte           `toml:"-"`
+	Code     int              `toml:"-"`
+	Err      error            `toml:"-"`
+	Warnings []string         `toml:"-"` // logged in group order once detection of the group is done
+
+	cached bool // whether the result came from the detect cache
 }
 
 type planSections struct {
@@ -509,21 +869,41 @@ func (r *detectResult) options() []detectOption {
 }
 
 type detectResults []detectResult
-type trialFunc func(detectTrial) (depMap, detectTrial, error)
 
-func (rs detectResults) runTrials(f trialFunc) (depMap, detectTrial, error) {
-	return rs.runTrialsFrom(nil, f)
+// trialFunc attempts the complete trial numbered i.
+type trialFunc func(i int, trial detectTrial) (depMap, detectTrial, error)
+
+// rejectFunc records that the complete trials numbered first to last, which all start with the partial trial,
+// are ruled out because of unmet, and returns the error to report if no other trial succeeds.
+type rejectFunc func(first, last int, trial detectTrial, unmet DetectUnmetReport) error
+
+// solve finds the first trial, in order of the priority of each buildpack's options, that f accepts.
+// Options are assigned to one buildpack at a time, and each assignment is propagated to the options of the
+// remaining buildpacks (see propagate). If the options assigned so far conflict, or the propagation leaves a buildpack
+// without any option that could be met, the assignment is passed to reject instead of trying the trials starting with it,
+// and the next option of the buildpack is assigned. Trials are numbered as if every trial was attempted in order.
+func (rs detectResults) solve(f trialFunc, reject rejectFunc) (depMap, detectTrial, error) {
+	options := make([][]detectOption, len(rs))
+	for i := range rs {
+		options[i] = rs[i].options()
+	}
+	return solveFrom(nil, options, 1, f, reject)
 }
 
-func (rs detectResults) runTrialsFrom(prefix detectTrial, f trialFunc) (depMap, detectTrial, error) {
-	if len(rs) == 0 {
-		deps, trial, err := f(prefix)
-		return deps, trial, err
+func solveFrom(prefix detectTrial, options [][]detectOption, first int, f trialFunc, reject rejectFunc) (depMap, detectTrial, error) {
+	if len(options) == 0 {
+		return f(first, prefix)
 	}
-
+	trials := trialCount(options[1:])
 	var lastErr error
-	for _, option := range rs[0].options() {
-		deps, trial, err := rs[1:].runTrialsFrom(append(prefix, option), f)
+	for i, option := range options[0] {
+		next := append(append(detectTrial{}, prefix...), option)
+		start := addTrials(first, mulTrials(i, trials))
+		if unmet, ok := next.conflict(options[1:]); ok {
+			lastErr = reject(start, addTrials(start, trials-1), next, unmet)
+			continue
+		}
+		deps, trial, err := solveFrom(next, options[1:], start, f, reject)
 		if err == nil {
 			return deps, trial, nil
 		}
@@ -532,6 +912,29 @@ func (rs detectResults) runTrialsFrom(prefix detectTrial, f trialFunc) (depMap,
 	return nil, nil, lastErr
 }
 
+// trialCount returns the number of complete trials of the options, at most math.MaxInt32.
+func trialCount(options [][]detectOption) int {
+	n := 1
+	for _, opts := range options {
+		n = mulTrials(n, len(opts))
+	}
+	return n
+}
+
+func mulTrials(a, b int) int {
+	if a != 0 && b > math.MaxInt32/a {
+		return math.MaxInt32
+	}
+	return a * b
+}
+
+func addTrials(a, b int) int {
+	if a > math.MaxInt32-b {
+		return math.MaxInt32
+	}
+	return a + b
+}
+
 type detectOption struct {
 	Buildpack
 	planSections
@@ -539,6 +942,159 @@ type detectOption struct {
 
 type detectTrial []detectOption
 
+var errConflict = errors.New("conflict")
+
+// conflict returns a require or provide of a non-optional option that cannot be met no matter which of the
+// remaining options are appended to the trial. The options already in the trial are checked against each other,
+// and then the remaining options are propagated, which rules out options that cannot be met.
+func (ts detectTrial) conflict(remaining [][]detectOption) (DetectUnmetReport, bool) {
+	remaining, unmet, ok := ts.propagate(remaining)
+	if ok {
+		return unmet, true
+	}
+	requiredAfter := map[string]bool{}
+	for _, opts := range remaining {
+		for _, option := range opts {
+			for _, req := range option.Requires {
+				requiredAfter[req.Name] = true
+			}
+		}
+	}
+
+	deps := newDepMap(ts)
+	if err := deps.eachUnmetRequire(func(name string, bp Buildpack) error {
+		if bp.Optional {
+			return nil
+		}
+		unmet = DetectUnmetReport{Buildpack: bp, Requires: name}
+		return errConflict
+	}); err != nil {
+		return unmet, true
+	}
+	if err := deps.eachMismatchedRequire(func(name string, m versionMismatch) error {
+		if m.bp.Optional {
+			return nil
+		}
+		unmet = DetectUnmetReport{
+			Buildpack:       m.bp,
+			Requires:        name,
+			Constraint:      m.constraint,
+			Provider:        m.provider.String(),
+			ProvidedVersion: m.version,
+		}
+		return errConflict
+	}); err != nil {
+		return unmet, true
+	}
+	if err := deps.eachUnmetProvide(func(name string, bp Buildpack) error {
+		if bp.Optional || requiredAfter[name] {
+			return nil
+		}
+		unmet = DetectUnmetReport{Buildpack: bp, Provides: name}
+		return errConflict
+	}); err != nil {
+		return unmet, true
+	}
+	return DetectUnmetReport{}, false
+}
+
+// propagate rules out the remaining options of each buildpack following the trial that cannot be met in any trial
+// starting with it: an option that is not optional needs each name it requires to be provided by the trial or an option
+// of an earlier buildpack, and each name it provides to be required by an option of a later buildpack. Optional options
+// are never ruled out, as the buildpack is removed from the trial if they are not met. Ruling out options can rule out
+// others, so this is repeated until no more options are ruled out. Versions are not compared, so an option that is
+// not ruled out may still fail. If every option of a buildpack is ruled out, the first unmet require or provide
+// of its first option is returned.
+func (ts detectTrial) propagate(remaining [][]detectOption) ([][]detectOption, DetectUnmetReport, bool) {
+	remaining = append([][]detectOption{}, remaining...)
+	for {
+		requiredAfter := make([]map[string]bool, len(remaining))
+		required := map[string]bool{}
+		for i := len(remaining) - 1; i >= 0; i-- {
+			requiredAfter[i] = required
+			next := map[string]bool{}
+			for name := range required {
+				next[name] = true
+			}
+			for _, option := range remaining[i] {
+				for _, req := range option.Requires {
+					next[req.Name] = true
+				}
+			}
+			required = next
+		}
+
+		provided := map[string]bool{}
+		for _, option := range ts {
+			for _, p := range option.Provides {
+				provided[p.Name] = true
+			}
+		}
+		changed := false
+		for i, opts := range remaining {
+			var viable []detectOption
+			for _, option := range opts {
+				if _, ok := option.unmet(provided, requiredAfter[i]); !ok {
+					viable = append(viable, option)
+				}
+			}
+			if len(viable) == 0 {
+				unmet, _ := opts[0].unmet(provided, requiredAfter[i])
+				return nil, unmet, true
+			}
+			if len(viable) != len(opts) {
+				remaining[i] = viable
+				changed = true
+			}
+			for _, option := range viable {
+				for _, p := range option.Provides {
+					provided[p.Name] = true
+				}
+			}
+		}
+		if !changed {
+			return remaining, DetectUnmetReport{}, false
+		}
+	}
+}
+
+// unmet returns the first name the option requires that neither provided nor the option itself provides, or else
+// the first name it provides that neither required nor the option itself requires, unless the option is optional.
+func (o detectOption) unmet(provided, required map[string]bool) (DetectUnmetReport, bool) {
+	if o.Optional {
+		return DetectUnmetReport{}, false
+	}
+	for _, req := range o.Requires {
+		if !provided[req.Name] && !o.provides(req.Name) {
+			return DetectUnmetReport{Buildpack: o.Buildpack, Requires: req.Name}, true
+		}
+	}
+	for _, p := range o.Provides {
+		if !required[p.Name] && !o.requires(p.Name) {
+			return DetectUnmetReport{Buildpack: o.Buildpack, Provides: p.Name}, true
+		}
+	}
+	return DetectUnmetReport{}, false
+}
+
+func (o detectOption) provides(name string) bool {
+	for _, p := range o.Provides {
+		if p.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (o detectOption) requires(name string) bool {
+	for _, req := range o.Requires {
+		if req.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
 func (ts detectTrial) remove(bp Buildpack) detectTrial {
 	var out detectTrial
 	for _, t := range ts {
@@ -551,8 +1107,19 @@ func (ts detectTrial) remove(bp Buildpack) detectTrial {
 
 type depEntry struct {
 	BuildPlanEntry
-	earlyRequires []Buildpack
-	extraProvides []Buildpack
+	earlyRequires      []Buildpack
+	extraProvides      []Buildpack
+	versions           map[Buildpack]string
+	mismatchedRequires []versionMismatch
+	uncheckedRequires  []versionMismatch
+}
+
+type versionMismatch struct {
+	bp         Buildpack
+	constraint string
+	provider   Buildpack
+	version    string
+	err        error // why the versions were not compared, for unchecked requires
 }
 
 type depMap map[string]depEntry
@@ -573,6 +1140,12 @@ func newDepMap(trial detectTrial) depMap {
 func (m depMap) provide(bp Buildpack, provide Provide) {
 	entry := m[provide.Name]
 	entry.extraProvides = append(entry.extraProvides, bp)
+	if provide.Version != "" {
+		if entry.versions == nil {
+			entry.versions = map[Buildpack]string{}
+		}
+		entry.versions[bp] = provide.Version
+	}
 	m[provide.Name] = entry
 }
 
@@ -583,7 +1156,32 @@ func (m depMap) require(bp Buildpack, require Require) {
 
 	if len(entry.Providers) == 0 {
 		entry.earlyRequires = append(entry.earlyRequires, bp)
-	} else {
+		m[require.Name] = entry
+		return
+	}
+	matched := true
+	for _, provider := range entry.Providers {
+		version, ok := entry.versions[provider]
+		if !ok {
+			continue
+		}
+		mismatch := versionMismatch{
+			bp:         bp,
+			constraint: require.versionConstraint(),
+			provider:   provider,
+			version:    version,
+		}
+		satisfied, err := require.satisfiedBy(version)
+		if err != nil {
+			mismatch.err = err
+			entry.uncheckedRequires = append(entry.uncheckedRequires, mismatch)
+		}
+		if !satisfied {
+			entry.mismatchedRequires = append(entry.mismatchedRequires, mismatch)
+			matched = false
+		}
+	}
+	if matched {
 		entry.Requires = append(entry.Requires, require)
 	}
 	m[require.Name] = entry
@@ -602,6 +1200,30 @@ func (m depMap) eachUnmetProvide(f func(name string, bp Buildpack) error) error
 	return nil
 }
 
+func (m depMap) eachMismatchedRequire(f func(name string, m versionMismatch) error) error {
+	for name, entry := range m {
+		for _, mismatch := range entry.mismatchedRequires {
+			if err := f(name, mismatch); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (m depMap) eachUncheckedRequire(f func(name string, m versionMismatch)) {
+	var names []string
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, unchecked := range m[name].uncheckedRequires {
+			f(name, unchecked)
+		}
+	}
+}
+
 func (m depMap) eachUnmetRequire(f func(name string, bp Buildpack) error) error {
 	for name, entry := range m {
 		if len(entry.earlyRequires) != 0 {
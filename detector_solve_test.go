@@ -0,0 +1,133 @@
+package lifecycle
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/discard"
+	"github.com/google/go-cmp/cmp"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+)
+
+func TestSolve(t *testing.T) {
+	spec.Run(t, "Solve", testSolve, spec.Report(report.Terminal{}))
+}
+
+func testSolve(t *testing.T, when spec.G, it spec.S) {
+	when("#solve", func() {
+		it("should select the same trial as attempting every trial in order", func() {
+			rnd := rand.New(rand.NewSource(1))
+			for i := 0; i < 2000; i++ {
+				results := randomDetectResults(rnd)
+
+				_, want, wantErr := solveExhaustively(results, newSolveConfig().runTrial)
+				c := newSolveConfig()
+				_, got, err := results.solve(c.runTrial, c.rejectTrial)
+
+				if (err == nil) != (wantErr == nil) {
+					t.Fatalf("Unexpected error for %+v:\nwant: %v\ngot:  %v\n", results, wantErr, err)
+				}
+				if s := cmp.Diff(fmt.Sprintf("%+v", got), fmt.Sprintf("%+v", want)); s != "" {
+					t.Fatalf("Unexpected trial for %+v:\n%s\n", results, s)
+				}
+			}
+		})
+	})
+}
+
+// BenchmarkSolve compares solve with attempting every trial in order, as plan resolution did before,
+// on the same results of a group of n optional buildpacks where only the last alternative of each
+// buildpack can be met.
+func BenchmarkSolve(b *testing.B) {
+	for _, n := range []int{4, 8, 10} {
+		var results detectResults
+		for i := 0; i < n; i++ {
+			results = append(results, detectResult{
+				Buildpack: Buildpack{ID: fmt.Sprintf("bp%d", i), Version: "v1", Optional: true},
+				DetectRun: DetectRun{
+					planSections: planSections{Requires: []Require{{Name: "missing-a"}}},
+					Or: planSectionsList{
+						{Requires: []Require{{Name: "missing-b"}}},
+						{Provides: []Provide{{Name: "dep"}}, Requires: []Require{{Name: "dep"}}},
+					},
+				},
+			})
+		}
+		b.Run(fmt.Sprintf("every trial/%d optional buildpacks with 3 alternatives", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, _, err := solveExhaustively(results, newSolveConfig().runTrial); err != nil {
+					b.Fatalf("Unexpected error:\n%s\n", err)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("solve/%d optional buildpacks with 3 alternatives", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				c := newSolveConfig()
+				if _, _, err := results.solve(c.runTrial, c.rejectTrial); err != nil {
+					b.Fatalf("Unexpected error:\n%s\n", err)
+				}
+			}
+		})
+	}
+}
+
+func newSolveConfig() *DetectConfig {
+	c := &DetectConfig{Logger: &log.Logger{Handler: discard.New()}}
+	c.init()
+	c.report.addGroup(DetectGroupReport{})
+	return c
+}
+
+// solveExhaustively attempts every trial in order of the priority of each buildpack's options
+// until f accepts one, which is how plans were resolved before solve.
+func solveExhaustively(rs detectResults, f trialFunc) (depMap, detectTrial, error) {
+	i := 0
+	var try func(prefix detectTrial, rs detectResults) (depMap, detectTrial, error)
+	try = func(prefix detectTrial, rs detectResults) (depMap, detectTrial, error) {
+		if len(rs) == 0 {
+			i++
+			return f(i, prefix)
+		}
+		var lastErr error
+		for _, option := range rs[0].options() {
+			deps, trial, err := try(append(append(detectTrial{}, prefix...), option), rs[1:])
+			if err == nil {
+				return deps, trial, nil
+			}
+			lastErr = err
+		}
+		return nil, nil, lastErr
+	}
+	return try(nil, rs)
+}
+
+// randomDetectResults returns the results of up to 5 buildpacks, each with up to 3 alternatives
+// that require and provide up to 2 of 3 names.
+func randomDetectResults(rnd *rand.Rand) detectResults {
+	names := []string{"a", "b", "c"}
+	sections := func() planSections {
+		var s planSections
+		for i := rnd.Intn(3); i > 0; i-- {
+			s.Requires = append(s.Requires, Require{Name: names[rnd.Intn(len(names))]})
+		}
+		for i := rnd.Intn(3); i > 0; i-- {
+			s.Provides = append(s.Provides, Provide{Name: names[rnd.Intn(len(names))]})
+		}
+		return s
+	}
+	var results detectResults
+	for i := rnd.Intn(5) + 1; i > 0; i-- {
+		result := detectResult{
+			Buildpack: Buildpack{ID: fmt.Sprintf("bp%d", len(results)), Version: "v1", Optional: rnd.Intn(2) == 0},
+			DetectRun: DetectRun{planSections: sections()},
+		}
+		for j := rnd.Intn(3); j > 0; j-- {
+			result.Or = append(result.Or, sections())
+		}
+		results = append(results, result)
+	}
+	return results
+}
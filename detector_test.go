@@ -1,16 +1,27 @@
 package lifecycle_test
 
 import (
+	"archive/tar"
+	"bytes"
+	"fmt"
 	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
 	"reflect"
+	"runtime"
+	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/apex/log"
 	"github.com/apex/log/handlers/memory"
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
 	"github.com/sclevine/spec"
 	"github.com/sclevine/spec/report"
 
@@ -90,6 +101,81 @@ func testDetector(t *testing.T, when spec.G, it spec.S) {
 			}
 		})
 
+		it("should produce the same output when detect concurrency is limited", func() {
+			mkappfile("100", "detect-status")
+			config.Workers = 1
+
+			_, _, err := lifecycle.BuildpackOrder{
+				{Group: []lifecycle.Buildpack{{ID: "E", Version: "v1"}}},
+			}.Detect(config)
+			if err, ok := err.(*lifecycle.Error); !ok || err.Type != lifecycle.ErrTypeFailedDetection {
+				t.Fatalf("Unexpected error:\n%s\n", err)
+			}
+
+			if s := cmp.Diff("\n"+allLogs(logHandler), outputFailureEv1); s != "" {
+				t.Fatalf("Unexpected log:\n%s\n", s)
+			}
+		})
+
+		it("should not run more detect executions at once than the configured number of workers", func() {
+			mkappfile("0", "detect-status")
+			mkappfile("", "detect-block-A-v1", "detect-block-B-v1")
+			config.Workers = 1
+
+			errs := make(chan error, 1)
+			go func() {
+				_, _, err := lifecycle.BuildpackOrder{
+					{Group: []lifecycle.Buildpack{
+						{ID: "A", Version: "v1"},
+						{ID: "B", Version: "v1"},
+					}},
+				}.Detect(config)
+				errs <- err
+			}()
+
+			// unblock each detect execution once it is running, until detection is done
+			unblocked := map[string]bool{}
+			for done := false; !done; {
+				select {
+				case err := <-errs:
+					if err != nil {
+						t.Fatalf("Unexpected error:\n%s\n", err)
+					}
+					done = true
+				case <-time.After(10 * time.Millisecond):
+					running, _ := ioutil.ReadDir(filepath.Join(config.AppDir, "detect-running"))
+					for _, fi := range running {
+						if !unblocked[fi.Name()] {
+							unblocked[fi.Name()] = true
+							mkappfile("", "detect-unblock-"+fi.Name())
+						}
+					}
+				}
+			}
+
+			if s := cmp.Diff(rdappfile("detect-concurrency"), "1\n1\n"); s != "" {
+				t.Fatalf("Expected detect executions to run one at a time:\n%s\n", s)
+			}
+		})
+
+		it("should run the detection of each buildpack once", func() {
+			mkappfile("", "detect-runs")
+			mkappfile("100", "detect-status-A-v1")
+
+			_, _, err := lifecycle.BuildpackOrder{
+				{Group: []lifecycle.Buildpack{{ID: "A", Version: "v1"}, {ID: "B", Version: "v1"}}},
+				{Group: []lifecycle.Buildpack{{ID: "C", Version: "v1"}, {ID: "B", Version: "v1"}}},
+				{Group: []lifecycle.Buildpack{{ID: "B", Version: "v1"}}},
+			}.Detect(config)
+			if err != nil {
+				t.Fatalf("Unexpected error:\n%s\n", err)
+			}
+
+			if n := strings.Count(rdappfile("detect-runs"), "B@v1"); n != 1 {
+				t.Fatalf("Expected detect of B@v1 to run once, ran %d times", n)
+			}
+		})
+
 		it("should select the first passing group", func() {
 			mkappfile("100", "detect-status")
 			mkappfile("0", "detect-status-A-v1", "detect-status-B-v1")
@@ -126,6 +212,191 @@ func testDetector(t *testing.T, when spec.G, it spec.S) {
 			}
 		})
 
+		it("should resolve version constraints to the highest matching buildpack version", func() {
+			group, _, err := lifecycle.BuildpackOrder{
+				{Group: []lifecycle.Buildpack{
+					{ID: "A"},
+					{ID: "B", Version: "1.x"},
+					{ID: "C", Version: "latest"},
+				}},
+			}.Detect(config)
+			if err != nil {
+				t.Fatalf("Unexpected error:\n%s\n", err)
+			}
+
+			if s := cmp.Diff(group, lifecycle.BuildpackGroup{
+				Group: []lifecycle.Buildpack{
+					{ID: "A", Version: "v2", API: "0.3"},
+					{ID: "B", Version: "v1", API: "0.2"},
+					{ID: "C", Version: "v2", API: "0.2"},
+				},
+			}); s != "" {
+				t.Fatalf("Unexpected group:\n%s\n", s)
+			}
+		})
+
+		it("should not run buildpacks excluded by the platform", func() {
+			config.Platform = lifecycle.PlatformBuildpacks{Exclude: []string{"B"}}
+
+			group, _, err := lifecycle.BuildpackOrder{
+				{Group: []lifecycle.Buildpack{
+					{ID: "A", Version: "v1"},
+					{ID: "B", Version: "v1"},
+				}},
+			}.Detect(config)
+			if err != nil {
+				t.Fatalf("Unexpected error:\n%s\n", err)
+			}
+
+			if s := cmp.Diff(group, lifecycle.BuildpackGroup{
+				Group: []lifecycle.Buildpack{{ID: "A", Version: "v1", API: "0.3"}},
+			}); s != "" {
+				t.Fatalf("Unexpected group:\n%s\n", s)
+			}
+			if _, err := os.Stat(filepath.Join(config.AppDir, "detect-env-type-B-v1")); !os.IsNotExist(err) {
+				t.Fatalf("Expected excluded buildpack not to run detect")
+			}
+			if s := allLogs(logHandler); !strings.Contains(s, "skip: B@v1 (excluded by platform)\n") {
+				t.Fatalf("Unexpected log:\n%s\n", s)
+			}
+		})
+
+		it("should fail if an optional buildpack required by the platform fails", func() {
+			config.Platform = lifecycle.PlatformBuildpacks{Require: []string{"B@v1"}}
+			mkappfile("100", "detect-status-B-v1")
+
+			_, _, err := lifecycle.BuildpackOrder{
+				{Group: []lifecycle.Buildpack{
+					{ID: "A", Version: "v1"},
+					{ID: "B", Version: "v1", Optional: true},
+				}},
+			}.Detect(config)
+			if err, ok := err.(*lifecycle.Error); !ok || err.Type != lifecycle.ErrTypeFailedDetection {
+				t.Fatalf("Unexpected error:\n%s\n", err)
+			}
+
+			if s := allLogs(logHandler); !strings.HasSuffix(s,
+				"======== Results ========\n"+
+					"pass: A@v1\n"+
+					"fail: B@v1 (required by platform)\n",
+			) {
+				t.Fatalf("Unexpected log:\n%s\n", s)
+			}
+		})
+
+		when("the stack is known", func() {
+			it("should fail buildpacks that do not support the stack", func() {
+				config.Stack = lifecycle.Stack{ID: "other.stack"}
+
+				_, _, err := lifecycle.BuildpackOrder{
+					{Group: []lifecycle.Buildpack{{ID: "A", Version: "v1"}, {ID: "J", Version: "v1"}}},
+				}.Detect(config)
+				if err, ok := err.(*lifecycle.Error); !ok || err.Type != lifecycle.ErrTypeFailedDetection {
+					t.Fatalf("Unexpected error:\n%s\n", err)
+				}
+
+				if s := allLogs(logHandler); !strings.Contains(s,
+					"======== Error: J@v1 ========\n"+
+						"stack 'other.stack' is not supported\n",
+				) {
+					t.Fatalf("Unexpected log:\n%s\n", s)
+				}
+				assertWarning(t, logHandler, "Not detecting J@v1: stack 'other.stack' is not supported")
+				if _, err := os.Stat(filepath.Join(config.AppDir, "detect-env-type-J-v1")); !os.IsNotExist(err) {
+					t.Fatalf("Expected buildpack that does not support the stack not to run detect")
+				}
+			})
+
+			it("should log the warnings of buildpacks in group order", func() {
+				config.Stack = lifecycle.Stack{ID: "other.stack"}
+				toappfile("\n[[requires]]\n name = \"dep2\"\n version = \"some-version\"", "detect-plan-A-v1.toml")
+
+				_, _, err := lifecycle.BuildpackOrder{
+					{Group: []lifecycle.Buildpack{{ID: "A", Version: "v1"}, {ID: "J", Version: "v1"}}},
+				}.Detect(config)
+				if err, ok := err.(*lifecycle.Error); !ok || err.Type != lifecycle.ErrTypeFailedDetection {
+					t.Fatalf("Unexpected error:\n%s\n", err)
+				}
+
+				var warnings []string
+				for _, le := range logHandler.Entries {
+					if le.Level == log.WarnLevel {
+						warnings = append(warnings, le.Message)
+					}
+				}
+				if s := cmp.Diff(warnings, []string{
+					`Warning: buildpack A has a "version" key. This key is deprecated in build plan requirements in buildpack API 0.3. "metadata.version" should be used instead`,
+					"Not detecting J@v1: stack 'other.stack' is not supported",
+				}); s != "" {
+					t.Fatalf("Unexpected warnings:\n%s\n", s)
+				}
+			})
+
+			it("should fail buildpacks that require missing mixins", func() {
+				config.Stack = lifecycle.Stack{
+					ID:          "some.stack",
+					BuildMixins: []string{"mixin-a"},
+					RunMixins:   []string{"mixin-a"},
+				}
+
+				_, _, err := lifecycle.BuildpackOrder{
+					{Group: []lifecycle.Buildpack{{ID: "J", Version: "v1"}}},
+				}.Detect(config)
+				if err, ok := err.(*lifecycle.Error); !ok || err.Type != lifecycle.ErrTypeFailedDetection {
+					t.Fatalf("Unexpected error:\n%s\n", err)
+				}
+
+				if s := allLogs(logHandler); !strings.Contains(s,
+					"======== Error: J@v1 ========\n"+
+						"missing required mixin(s): build:mixin-b, run:mixin-c\n",
+				) {
+					t.Fatalf("Unexpected log:\n%s\n", s)
+				}
+				assertWarning(t, logHandler, "Not detecting J@v1: missing required mixin(s): build:mixin-b, run:mixin-c")
+			})
+
+			it("should detect buildpacks whose mixins are present", func() {
+				config.Stack = lifecycle.Stack{
+					ID:          "some.stack",
+					BuildMixins: []string{"mixin-a", "build:mixin-b"},
+					RunMixins:   []string{"mixin-a", "run:mixin-c"},
+				}
+
+				group, _, err := lifecycle.BuildpackOrder{
+					{Group: []lifecycle.Buildpack{{ID: "J", Version: "v1"}}},
+				}.Detect(config)
+				if err != nil {
+					t.Fatalf("Unexpected error:\n%s\n", err)
+				}
+				if s := cmp.Diff(group, lifecycle.BuildpackGroup{
+					Group: []lifecycle.Buildpack{{ID: "J", Version: "v1", API: "0.3"}},
+				}); s != "" {
+					t.Fatalf("Unexpected group:\n%s\n", s)
+				}
+			})
+
+			it("should return the mixins the buildpacks require on the stack", func() {
+				mixins, err := lifecycle.RequiredMixins([]lifecycle.Buildpack{
+					{ID: "A", Version: "v1"},
+					{ID: "J", Version: "v1"},
+				}, config.BuildpacksDir, "some.stack")
+				if err != nil {
+					t.Fatalf("Unexpected error:\n%s\n", err)
+				}
+				if s := cmp.Diff(mixins, []string{"build:mixin-b", "mixin-a", "run:mixin-c"}); s != "" {
+					t.Fatalf("Unexpected mixins:\n%s\n", s)
+				}
+
+				mixins, err = lifecycle.RequiredMixins([]lifecycle.Buildpack{{ID: "J", Version: "v1"}}, config.BuildpacksDir, "other.stack")
+				if err != nil {
+					t.Fatalf("Unexpected error:\n%s\n", err)
+				}
+				if len(mixins) != 0 {
+					t.Fatalf("Unexpected mixins: %v", mixins)
+				}
+			})
+		})
+
 		it("should fail if the group is empty", func() {
 			_, _, err := lifecycle.BuildpackOrder([]lifecycle.BuildpackGroup{{}}).Detect(config)
 			if err, ok := err.(*lifecycle.Error); !ok || err.Type != lifecycle.ErrTypeFailedDetection {
@@ -187,6 +458,186 @@ func testDetector(t *testing.T, when spec.G, it spec.S) {
 			}
 		})
 
+		it("should fail without running detect if an order references itself", func() {
+			mkappfile("0", "detect-status")
+
+			_, _, err := lifecycle.BuildpackOrder{
+				{Group: []lifecycle.Buildpack{{ID: "H", Version: "v1"}}},
+			}.Detect(config)
+			if err, ok := err.(*lifecycle.Error); !ok || err.Type != lifecycle.ErrTypeOrderCycle {
+				t.Fatalf("Unexpected error:\n%s\n", err)
+			}
+			if s := cmp.Diff(err.Error(), "cyclical reference in buildpack order: H@v1 -> I@v1 -> H@v1"); s != "" {
+				t.Fatalf("Unexpected error message:\n%s\n", s)
+			}
+
+			if s := allLogs(logHandler); s != "" {
+				t.Fatalf("Unexpected log:\n%s\n", s)
+			}
+			if _, err := os.Stat(filepath.Join(config.AppDir, "detect-env-A-v1")); !os.IsNotExist(err) {
+				t.Fatalf("Expected detect not to run: %v\n", err)
+			}
+		})
+
+		it("should find cycles between the versions that constraints resolve to", func() {
+			_, _, err := lifecycle.BuildpackOrder{
+				{Group: []lifecycle.Buildpack{{ID: "H", Version: "latest"}}},
+			}.Detect(config)
+			if err, ok := err.(*lifecycle.Error); !ok || err.Type != lifecycle.ErrTypeOrderCycle {
+				t.Fatalf("Unexpected error:\n%s\n", err)
+			}
+			if s := cmp.Diff(err.Error(), "cyclical reference in buildpack order: H@v1 -> I@v1 -> H@v1"); s != "" {
+				t.Fatalf("Unexpected error message:\n%s\n", s)
+			}
+		})
+
+		it("should not check buildpacks excluded by the platform for cycles", func() {
+			mkappfile("100", "detect-status")
+			mkappfile("0", "detect-status-A-v1")
+			config.Platform = lifecycle.PlatformBuildpacks{Exclude: []string{"I"}}
+
+			group, _, err := lifecycle.BuildpackOrder{
+				{Group: []lifecycle.Buildpack{{ID: "H", Version: "v1"}}},
+			}.Detect(config)
+			if err != nil {
+				t.Fatalf("Unexpected error:\n%s\n", err)
+			}
+			if s := cmp.Diff(group, lifecycle.BuildpackGroup{
+				Group: []lifecycle.Buildpack{{ID: "A", Version: "v1", API: "0.3"}},
+			}); s != "" {
+				t.Fatalf("Unexpected group:\n%s\n", s)
+			}
+		})
+
+		it("should not fail for missing buildpacks in groups that detection does not reach", func() {
+			mkappfile("100", "detect-status")
+			mkappfile("0", "detect-status-A-v1")
+
+			group, _, err := lifecycle.BuildpackOrder{
+				{Group: []lifecycle.Buildpack{{ID: "A", Version: "v1"}}},
+				{Group: []lifecycle.Buildpack{{ID: "missing", Version: "v1"}}},
+			}.Detect(config)
+			if err != nil {
+				t.Fatalf("Unexpected error:\n%s\n", err)
+			}
+			if s := cmp.Diff(group, lifecycle.BuildpackGroup{
+				Group: []lifecycle.Buildpack{{ID: "A", Version: "v1", API: "0.3"}},
+			}); s != "" {
+				t.Fatalf("Unexpected group:\n%s\n", s)
+			}
+		})
+
+		it("should fail with specific error if any bp detect times out", func() {
+			mkappfile("0", "detect-status")
+			mkappfile("60", "detect-sleep-B-v1")
+			config.Timeout = 2 * time.Second
+			start := time.Now()
+			_, _, err := lifecycle.BuildpackOrder{
+				{Group: []lifecycle.Buildpack{
+					{ID: "A", Version: "v1"},
+					{ID: "B", Version: "v1"},
+				}},
+			}.Detect(config)
+			if err, ok := err.(*lifecycle.Error); !ok || err.Type != lifecycle.ErrTypeBuildpackTimeout {
+				t.Fatalf("Unexpected error:\n%s\n", err)
+			}
+			if elapsed := time.Since(start); elapsed > 30*time.Second {
+				t.Fatalf("Expected buildpack process group to be killed, took %s", elapsed)
+			}
+
+			if s := allLogs(logHandler); !strings.HasSuffix(s,
+				"======== Results ========\n"+
+					"pass: A@v1\n"+
+					"err:  B@v1 (timed out after 2s)\n",
+			) {
+				t.Fatalf("Unexpected log:\n%s\n", s)
+			}
+		})
+
+		it("should record metrics for each detect execution", func() {
+			mkappfile("100", "detect-status-B-v1")
+			mkappfile("0.2", "detect-sleep-A-v1")
+			config.Metrics = &lifecycle.Metrics{}
+			_, _, err := lifecycle.BuildpackOrder{
+				{Group: []lifecycle.Buildpack{
+					{ID: "A", Version: "v1"},
+					{ID: "B", Version: "v1", Optional: true},
+				}},
+			}.Detect(config)
+			if err != nil {
+				t.Fatalf("Unexpected error:\n%s\n", err)
+			}
+
+			metrics := config.Metrics.Detect
+			sort.Slice(metrics, func(i, j int) bool { return metrics[i].ID < metrics[j].ID })
+			if len(metrics) != 2 {
+				t.Fatalf("Unexpected metrics:\n%+v\n", metrics)
+			}
+			if m := metrics[0]; m.ID != "A" || m.Version != "v1" || m.ExitCode != 0 || m.WallTime < 0.2 {
+				t.Fatalf("Unexpected metrics for A@v1:\n%+v\n", m)
+			}
+			if m := metrics[1]; m.ID != "B" || m.Version != "v1" || m.ExitCode != 100 || m.WallTime <= 0 {
+				t.Fatalf("Unexpected metrics for B@v1:\n%+v\n", m)
+			}
+			if runtime.GOOS == "linux" {
+				for _, m := range metrics {
+					if m.MaxRSS <= 0 {
+						t.Fatalf("Expected peak RSS for %s@%s:\n%+v\n", m.ID, m.Version, m)
+					}
+				}
+			}
+		})
+
+		it("should capture the output of each detect execution in a log file when configured", func() {
+			mkappfile("100", "detect-status-B-v1")
+			config.LogsDir = filepath.Join(tmpDir, "logs")
+			_, _, err := lifecycle.BuildpackOrder{
+				{Group: []lifecycle.Buildpack{
+					{ID: "A", Version: "v1"},
+					{ID: "B", Version: "v1", Optional: true},
+				}},
+			}.Detect(config)
+			if err != nil {
+				t.Fatalf("Unexpected error:\n%s\n", err)
+			}
+
+			for _, bp := range []string{"A", "B"} {
+				logs := rdfile(t, filepath.Join(tmpDir, "logs", bp, "detect.log"))
+				if !strings.Contains(logs, "detect out: "+bp+"@v1") || !strings.Contains(logs, "detect err: "+bp+"@v1") {
+					t.Fatalf("Unexpected log for %s:\n%s\n", bp, logs)
+				}
+			}
+		})
+
+		it("should replace detect logs from an earlier detection and point to the log of buildpacks that error", func() {
+			config.LogsDir = filepath.Join(tmpDir, "logs")
+			order := lifecycle.BuildpackOrder{
+				{Group: []lifecycle.Buildpack{{ID: "A", Version: "v1"}}},
+			}
+			if _, _, err := order.Detect(config); err != nil {
+				t.Fatalf("Unexpected error:\n%s\n", err)
+			}
+
+			mkappfile("127", "detect-status-A-v1")
+			if _, _, err := order.Detect(&lifecycle.DetectConfig{
+				FullEnv:       config.FullEnv,
+				AppDir:        config.AppDir,
+				PlatformDir:   config.PlatformDir,
+				BuildpacksDir: config.BuildpacksDir,
+				Logger:        config.Logger,
+				LogsDir:       config.LogsDir,
+			}); err == nil {
+				t.Fatalf("Expected error")
+			}
+			logPath := filepath.Join(tmpDir, "logs", "A", "detect.log")
+			if logs := rdfile(t, logPath); strings.Count(logs, "detect out: A@v1") != 1 {
+				t.Fatalf("Expected log of the last detection only, got:\n%s\n", logs)
+			}
+			if s := allLogs(logHandler); !strings.HasSuffix(s, "err:  A@v1 (127) (log: "+logPath+")\n") {
+				t.Fatalf("Unexpected log:\n%s\n", s)
+			}
+		})
+
 		it("should select an appropriate env type", func() {
 			mkappfile("0", "detect-status-A-v1.clear", "detect-status-B-v1")
 
@@ -237,6 +688,98 @@ func testDetector(t *testing.T, when spec.G, it spec.S) {
 			}
 		})
 
+		it("should search each buildpacks directory in order", func() {
+			emptyDir := filepath.Join(tmpDir, "empty")
+			mkdir(t, emptyDir)
+			config.BuildpacksDir = strings.Join([]string{emptyDir, config.BuildpacksDir}, string(os.PathListSeparator))
+
+			_, _, err := lifecycle.BuildpackOrder{
+				{Group: []lifecycle.Buildpack{{ID: "A", Version: "v1"}}},
+			}.Detect(config)
+			if err != nil {
+				t.Fatalf("Unexpected error:\n%s\n", err)
+			}
+
+			bpsDir, err := filepath.Abs(filepath.Join("testdata", "by-id"))
+			if err != nil {
+				t.Fatalf("Unexpected error:\n%s\n", err)
+			}
+			expectedBpDir := filepath.Join(bpsDir, "A/v1")
+			if bpDir := rdappfile("detect-env-cnb-buildpack-dir-A-v1"); bpDir != expectedBpDir {
+				t.Fatalf("Unexpected buildpack dir:\n\twanted: %s\n\tgot: %s\n", expectedBpDir, bpDir)
+			}
+		})
+
+		it("should resolve version constraints to the highest version in any buildpacks directory", func() {
+			firstDir, secondDir := filepath.Join(tmpDir, "first"), filepath.Join(tmpDir, "second")
+			for dir, version := range map[string]string{firstDir: "v1", secondDir: "v2"} {
+				bpDir := filepath.Join(dir, "C", version)
+				mkdir(t, filepath.Join(bpDir, "bin"))
+				h.RecursiveCopy(t, filepath.Join("testdata", "buildpack", "bin"), filepath.Join(bpDir, "bin"))
+				h.CopyFile(t, filepath.Join("testdata", "by-id", "C", version, "buildpack.toml"), filepath.Join(bpDir, "buildpack.toml"))
+				for _, script := range []string{"detect", "build"} {
+					h.AssertNil(t, os.Chmod(filepath.Join(bpDir, "bin", script), 0755))
+				}
+			}
+			config.BuildpacksDir = strings.Join([]string{firstDir, secondDir}, string(os.PathListSeparator))
+
+			group, _, err := lifecycle.BuildpackOrder{
+				{Group: []lifecycle.Buildpack{{ID: "C", Version: "latest"}}},
+			}.Detect(config)
+			if err != nil {
+				t.Fatalf("Unexpected error:\n%s\n", err)
+			}
+
+			if s := cmp.Diff(group, lifecycle.BuildpackGroup{
+				Group: []lifecycle.Buildpack{{ID: "C", Version: "v2", API: "0.2"}},
+			}); s != "" {
+				t.Fatalf("Unexpected group:\n%s\n", s)
+			}
+			if bpDir := rdappfile("detect-env-cnb-buildpack-dir-C-v2"); bpDir != filepath.Join(secondDir, "C", "v2") {
+				t.Fatalf("Unexpected buildpack dir: %s\n", bpDir)
+			}
+		})
+
+		it("should find buildpacks in buildpackages", func() {
+			bpkgPath := filepath.Join(tmpDir, "buildpackage.cnb")
+			mkbuildpackage(t, bpkgPath, filepath.Join("testdata", "by-id"), "A/v1")
+			config.BuildpacksDir = bpkgPath
+
+			_, _, err := lifecycle.BuildpackOrder{
+				{Group: []lifecycle.Buildpack{{ID: "A", Version: "v1"}}},
+			}.Detect(config)
+			if err != nil {
+				t.Fatalf("Unexpected error:\n%s\n", err)
+			}
+
+			bpDir := rdappfile("detect-env-cnb-buildpack-dir-A-v1")
+			if !strings.HasSuffix(bpDir, filepath.Join("cnb", "buildpacks", "A", "v1")) {
+				t.Fatalf("Unexpected buildpack dir: %s\n", bpDir)
+			}
+			if s := cmp.Diff(rdfile(t, filepath.Join(bpDir, "buildpack.toml")),
+				rdfile(t, filepath.Join("testdata", "by-id", "A", "v1", "buildpack.toml")),
+			); s != "" {
+				t.Fatalf("Unexpected buildpack.toml:\n%s\n", s)
+			}
+
+			h.AssertNil(t, lifecycle.RemoveUnpackedBuildpackages())
+			if _, err := os.Stat(bpDir); !os.IsNotExist(err) {
+				t.Fatalf("Expected unpacked buildpackage to be removed, got: %v\n", err)
+			}
+		})
+
+		it("should return the error of a buildpackage that cannot be unpacked over a missing directory", func() {
+			bpkgPath := filepath.Join(tmpDir, "invalid.cnb")
+			mkfile(t, "not a buildpackage", bpkgPath)
+			config.BuildpacksDir = strings.Join([]string{filepath.Join(tmpDir, "missing"), bpkgPath}, string(os.PathListSeparator))
+
+			_, _, err := lifecycle.BuildpackOrder{
+				{Group: []lifecycle.Buildpack{{ID: "A", Version: "v1"}}},
+			}.Detect(config)
+			h.AssertStringContains(t, fmt.Sprintf("%v", err), "extract buildpackage '"+bpkgPath+"'")
+			h.AssertNil(t, lifecycle.RemoveUnpackedBuildpackages())
+		})
+
 		it("should not output detect pass and fail as info level", func() {
 			mkappfile("100", "detect-status")
 			mkappfile("0", "detect-status-A-v1")
@@ -284,6 +827,112 @@ func testDetector(t *testing.T, when spec.G, it spec.S) {
 			}
 		})
 
+		when("a detect cache is used", func() {
+			var (
+				counterDir string
+				logsDir    string
+				detectWith func(cache *lifecycle.DetectCache) lifecycle.BuildPlan
+			)
+
+			it.Before(func() {
+				if runtime.GOOS == "windows" {
+					t.Skip("detect scripts require bash")
+				}
+				counterDir = filepath.Join(tmpDir, "counters")
+				logsDir = filepath.Join(tmpDir, "logs")
+				mkdir(t, counterDir)
+				bpsDir := filepath.Join(tmpDir, "buildpacks")
+				for id, bpTOML := range map[string]string{
+					"X": "api = \"0.3\"\n[buildpack]\nid = \"X\"\nversion = \"v1\"\n",
+					"Y": "api = \"0.3\"\n[buildpack]\nid = \"Y\"\nversion = \"v1\"\nskip-detect-cache = true\n",
+				} {
+					mkdir(t, filepath.Join(bpsDir, id, "v1", "bin"))
+					mkfile(t, bpTOML, filepath.Join(bpsDir, id, "v1", "buildpack.toml"))
+					mkfile(t, cacheDetectScript, filepath.Join(bpsDir, id, "v1", "bin", "detect"))
+					if err := os.Chmod(filepath.Join(bpsDir, id, "v1", "bin", "detect"), 0777); err != nil {
+						t.Fatalf("Error: %s\n", err)
+					}
+				}
+				mkappfile("some-contents", "app-file")
+
+				detectWith = func(cache *lifecycle.DetectCache) lifecycle.BuildPlan {
+					t.Helper()
+					_, plan, err := lifecycle.BuildpackOrder{
+						{Group: []lifecycle.Buildpack{{ID: "X", Version: "v1"}, {ID: "Y", Version: "v1"}}},
+					}.Detect(&lifecycle.DetectConfig{
+						FullEnv:       append(os.Environ(), "COUNTER_DIR="+counterDir),
+						AppDir:        config.AppDir,
+						PlatformDir:   platformDir,
+						BuildpacksDir: bpsDir,
+						Logger:        config.Logger,
+						Cache:         cache,
+						LogsDir:       logsDir,
+					})
+					if err != nil {
+						t.Fatalf("Unexpected error:\n%s\n", err)
+					}
+					return plan
+				}
+			})
+
+			it("should reuse detect results until the app changes", func() {
+				cache := lifecycle.NewDetectCache(nil)
+				plan := detectWith(cache)
+
+				cache = lifecycle.NewDetectCache(cache.Entries())
+				if s := cmp.Diff(detectWith(cache), plan); s != "" {
+					t.Fatalf("Unexpected plan:\n%s\n", s)
+				}
+				if s := rdfile(t, filepath.Join(counterDir, "X")); s != "." {
+					t.Fatalf("Expected cached buildpack to run detect once, ran %d times", len(s))
+				}
+				if s := rdfile(t, filepath.Join(counterDir, "Y")); s != ".." {
+					t.Fatalf("Expected buildpack that skips the cache to run detect twice, ran %d times", len(s))
+				}
+
+				mkappfile("other-contents", "app-file")
+				detectWith(lifecycle.NewDetectCache(cache.Entries()))
+				if s := rdfile(t, filepath.Join(counterDir, "X")); s != ".." {
+					t.Fatalf("Expected cached buildpack to run detect again after the app changed, ran %d times", len(s))
+				}
+			})
+			it("should replace the detect logs of buildpacks whose results are cached", func() {
+				cache := lifecycle.NewDetectCache(nil)
+				detectWith(cache)
+				if s := rdfile(t, filepath.Join(logsDir, "X", "detect.log")); s != "detecting X\n" {
+					t.Fatalf("Unexpected log:\n%s\n", s)
+				}
+
+				detectWith(lifecycle.NewDetectCache(cache.Entries()))
+				if s := rdfile(t, filepath.Join(logsDir, "X", "detect.log")); s != "Using the detect result of X@v1 cached by an earlier build, /bin/detect was not run\n" {
+					t.Fatalf("Unexpected log:\n%s\n", s)
+				}
+				if s := rdfile(t, filepath.Join(logsDir, "Y", "detect.log")); s != "detecting Y\n" {
+					t.Fatalf("Unexpected log:\n%s\n", s)
+				}
+			})
+
+			it("should reuse detect results passed through a detect cache file", func() {
+				cache := lifecycle.NewDetectCache(nil)
+				plan := detectWith(cache)
+
+				detectCachePath := filepath.Join(tmpDir, "detect-cache.toml")
+				h.AssertNil(t, lifecycle.WriteDetectCache(detectCachePath, cache.Entries()))
+				entries, err := lifecycle.ReadDetectCache(detectCachePath)
+				h.AssertNil(t, err)
+				if s := cmp.Diff(entries, cache.Entries()); s != "" {
+					t.Fatalf("Unexpected entries:\n%s\n", s)
+				}
+
+				if s := cmp.Diff(detectWith(lifecycle.NewDetectCache(entries)), plan); s != "" {
+					t.Fatalf("Unexpected plan:\n%s\n", s)
+				}
+				if s := rdfile(t, filepath.Join(counterDir, "X")); s != "." {
+					t.Fatalf("Expected cached buildpack to run detect once, ran %d times", len(s))
+				}
+			})
+		})
+
 		when("a build plan is employed", func() {
 			it("should return a build plan with matched dependencies", func() {
 				mkappfile("100", "detect-status-C-v1")
@@ -384,6 +1033,92 @@ func testDetector(t *testing.T, when spec.G, it spec.S) {
 				}
 			})
 
+			it("should report unmet requires for each group and the selected group", func() {
+				toappfile("\n[[requires]]\n name = \"dep1\"", "detect-plan-B-v1.toml")
+
+				_, _, err := lifecycle.BuildpackOrder{
+					{Group: []lifecycle.Buildpack{
+						{ID: "A", Version: "v1"},
+						{ID: "B", Version: "v1"},
+					}},
+					{Group: []lifecycle.Buildpack{
+						{ID: "A", Version: "v1"},
+					}},
+				}.Detect(config)
+				if err != nil {
+					t.Fatalf("Unexpected error:\n%s\n", err)
+				}
+
+				report := config.Report()
+				if len(report.Groups) != 2 {
+					t.Fatalf("Unexpected groups:\n%+v\n", report.Groups)
+				}
+				failed := report.Groups[0]
+				if failed.Pass {
+					t.Fatalf("Expected first group not to pass")
+				}
+				bp := failed.Buildpacks[1]
+				if bp.ID != "B" || bp.Result != "pass" || bp.Code != 0 || !strings.Contains(bp.Output, "detect out: B@v1") {
+					t.Fatalf("Unexpected buildpack report:\n%+v\n", bp)
+				}
+				// the trial is ruled out once A is assigned, as nothing left can provide dep1 for B
+				if s := cmp.Diff(failed.Trials, []lifecycle.DetectTrialReport{{
+					Options: []lifecycle.DetectOptionReport{
+						{Buildpack: lifecycle.Buildpack{ID: "A", Version: "v1"}},
+					},
+					Unmet: []lifecycle.DetectUnmetReport{
+						{Buildpack: lifecycle.Buildpack{ID: "B", Version: "v1"}, Requires: "dep1"},
+					},
+				}}); s != "" {
+					t.Fatalf("Unexpected trials:\n%s\n", s)
+				}
+				if !report.Groups[1].Pass {
+					t.Fatalf("Expected second group to pass")
+				}
+				if s := cmp.Diff(report.Selected, []lifecycle.Buildpack{{ID: "A", Version: "v1"}}); s != "" {
+					t.Fatalf("Unexpected selected group:\n%s\n", s)
+				}
+			})
+
+			it("should report the providers of each requirement of the selected group", func() {
+				toappfile("\n[[provides]]\n name = \"dep1\"", "detect-plan-A-v1.toml", "detect-plan-B-v1.toml")
+				toappfile("\n[[requires]]\n name = \"dep1\"\n version = \"1.x\"", "detect-plan-B-v1.toml", "detect-plan-C-v1.toml")
+
+				_, _, err := lifecycle.BuildpackOrder{
+					{Group: []lifecycle.Buildpack{
+						{ID: "A", Version: "v1"},
+						{ID: "B", Version: "v1"},
+						{ID: "C", Version: "v1"},
+					}},
+				}.Detect(config)
+				if err != nil {
+					t.Fatalf("Unexpected error:\n%s\n", err)
+				}
+
+				if s := cmp.Diff(config.Report().Requires, []lifecycle.DetectRequireReport{
+					{
+						Buildpack:  lifecycle.Buildpack{ID: "B", Version: "v1"},
+						Requires:   "dep1",
+						Constraint: "1.x",
+						Providers: []lifecycle.Buildpack{
+							{ID: "A", Version: "v1"},
+							{ID: "B", Version: "v1"},
+						},
+					},
+					{
+						Buildpack:  lifecycle.Buildpack{ID: "C", Version: "v1"},
+						Requires:   "dep1",
+						Constraint: "1.x",
+						Providers: []lifecycle.Buildpack{
+							{ID: "A", Version: "v1"},
+							{ID: "B", Version: "v1"},
+						},
+					},
+				}); s != "" {
+					t.Fatalf("Unexpected requires:\n%s\n", s)
+				}
+			})
+
 			it("should fail if all provides are not required after", func() {
 				toappfile("\n[[provides]]\n name = \"dep1\"", "detect-plan-A-v1.toml", "detect-plan-B-v1.toml")
 				toappfile("\n[[requires]]\n name = \"dep1\"", "detect-plan-A-v1.toml", "detect-plan-C-v1.toml")
@@ -532,6 +1267,129 @@ func testDetector(t *testing.T, when spec.G, it spec.S) {
 				}
 			})
 
+			it("should fallback to alternate build plans when a provided version does not satisfy a require", func() {
+				toappfile("\n[[provides]]\n name = \"dep1\"\n version = \"12.0.0\"", "detect-plan-A-v1.toml")
+				toappfile("\n[[or]]", "detect-plan-A-v1.toml")
+				toappfile("\n[[or.provides]]\n name = \"dep1\"\n version = \"14.1.0\"", "detect-plan-A-v1.toml")
+				toappfile("\n[[requires]]\n name = \"dep1\"\n [requires.metadata]\n version = \"^14\"", "detect-plan-B-v1.toml")
+
+				group, plan, err := lifecycle.BuildpackOrder{
+					{Group: []lifecycle.Buildpack{
+						{ID: "A", Version: "v1"},
+						{ID: "B", Version: "v1"},
+					}},
+				}.Detect(config)
+				if err != nil {
+					t.Fatalf("Unexpected error:\n%s\n", err)
+				}
+
+				if s := cmp.Diff(group, lifecycle.BuildpackGroup{
+					Group: []lifecycle.Buildpack{
+						{ID: "A", Version: "v1", API: "0.3"},
+						{ID: "B", Version: "v1", API: "0.2"},
+					},
+				}); s != "" {
+					t.Fatalf("Unexpected group:\n%s\n", s)
+				}
+
+				if !hasEntries(plan.Entries, []lifecycle.BuildPlanEntry{
+					{
+						Providers: []lifecycle.Buildpack{{ID: "A", Version: "v1"}},
+						Requires:  []lifecycle.Require{{Name: "dep1", Metadata: map[string]interface{}{"version": "^14"}}},
+					},
+				}) {
+					t.Fatalf("Unexpected entries:\n%+v\n", plan.Entries)
+				}
+
+				if s := allLogs(logHandler); !strings.HasSuffix(s,
+					"Resolving plan... (try #1)\n"+
+						"fail: B@v1 requires dep1@^14, A@v1 provides dep1@12.0.0\n"+
+						"Resolving plan... (try #2)\n"+
+						"A v1\n"+
+						"B v1\n",
+				) {
+					t.Fatalf("Unexpected log:\n%s\n", s)
+				}
+			})
+
+			it("should not compare versions that are not semver", func() {
+				toappfile("\n[[provides]]\n name = \"dep1\"\n version = \"12.0.0\"", "detect-plan-A-v1.toml")
+				toappfile("\n[[requires]]\n name = \"dep1\"\n [requires.metadata]\n version = \"latest\"", "detect-plan-B-v1.toml")
+
+				group, _, err := lifecycle.BuildpackOrder{
+					{Group: []lifecycle.Buildpack{
+						{ID: "A", Version: "v1"},
+						{ID: "B", Version: "v1"},
+					}},
+				}.Detect(config)
+				if err != nil {
+					t.Fatalf("Unexpected error:\n%s\n", err)
+				}
+
+				if len(group.Group) != 2 {
+					t.Fatalf("Unexpected group:\n%+v\n", group)
+				}
+				assertWarning(t, logHandler, "Not checking that dep1@12.0.0 provided by A@v1 meets the requirement dep1@latest of B@v1: version constraint 'latest' is not semver")
+			})
+
+			it("should warn about versions that are not compared sorted by name", func() {
+				toappfile("\n[[provides]]\n name = \"dep2\"\n version = \"2.0.0\"", "detect-plan-A-v1.toml")
+				toappfile("\n[[provides]]\n name = \"dep1\"\n version = \"1.0.0\"", "detect-plan-A-v1.toml")
+				toappfile("\n[[provides]]\n name = \"dep3\"\n version = \"3.0.0\"", "detect-plan-A-v1.toml")
+				toappfile("\n[[requires]]\n name = \"dep3\"\n [requires.metadata]\n version = \"latest\"", "detect-plan-B-v1.toml")
+				toappfile("\n[[requires]]\n name = \"dep1\"\n [requires.metadata]\n version = \"latest\"", "detect-plan-B-v1.toml")
+				toappfile("\n[[requires]]\n name = \"dep2\"\n [requires.metadata]\n version = \"latest\"", "detect-plan-B-v1.toml")
+
+				_, _, err := lifecycle.BuildpackOrder{
+					{Group: []lifecycle.Buildpack{
+						{ID: "A", Version: "v1"},
+						{ID: "B", Version: "v1"},
+					}},
+				}.Detect(config)
+				if err != nil {
+					t.Fatalf("Unexpected error:\n%s\n", err)
+				}
+
+				var warnings []string
+				for _, le := range logHandler.Entries {
+					if le.Level == log.WarnLevel {
+						warnings = append(warnings, le.Message)
+					}
+				}
+				if s := cmp.Diff(warnings, []string{
+					"Not checking that dep1@1.0.0 provided by A@v1 meets the requirement dep1@latest of B@v1: version constraint 'latest' is not semver",
+					"Not checking that dep2@2.0.0 provided by A@v1 meets the requirement dep2@latest of B@v1: version constraint 'latest' is not semver",
+					"Not checking that dep3@3.0.0 provided by A@v1 meets the requirement dep3@latest of B@v1: version constraint 'latest' is not semver",
+				}); s != "" {
+					t.Fatalf("Unexpected warnings:\n%s\n", s)
+				}
+			})
+
+			it("should fail if no provided version satisfies a require", func() {
+				toappfile("\n[[provides]]\n name = \"dep1\"\n version = \"12.0.0\"", "detect-plan-A-v1.toml")
+				toappfile("\n[[requires]]\n name = \"dep1\"\n [requires.metadata]\n version = \"^14\"", "detect-plan-B-v1.toml")
+
+				_, _, err := lifecycle.BuildpackOrder{
+					{Group: []lifecycle.Buildpack{
+						{ID: "A", Version: "v1"},
+						{ID: "B", Version: "v1"},
+					}},
+				}.Detect(config)
+				if err, ok := err.(*lifecycle.Error); !ok || err.Type != lifecycle.ErrTypeFailedDetection {
+					t.Fatalf("Unexpected error:\n%s\n", err)
+				}
+
+				if s := cmp.Diff(config.Report().Groups[0].Trials[0].Unmet, []lifecycle.DetectUnmetReport{{
+					Buildpack:       lifecycle.Buildpack{ID: "B", Version: "v1"},
+					Requires:        "dep1",
+					Constraint:      "^14",
+					Provider:        "A@v1",
+					ProvidedVersion: "12.0.0",
+				}}); s != "" {
+					t.Fatalf("Unexpected unmet requires:\n%s\n", s)
+				}
+			})
+
 			it("should convert top level versions to metadata versions", func() {
 				mkappfile("100", "detect-status-C-v1")
 				mkappfile("100", "detect-status-B-v2")
@@ -726,10 +1584,10 @@ func testDetector(t *testing.T, when spec.G, it spec.S) {
 					if err != nil {
 						t.Fatalf("Unexpected error:\n%s\n", err)
 					}
-					if s := allLogs(logHandler); !strings.Contains(s,
+					if s := cmp.Diff(detectRun.Warnings, []string{
 						`Warning: buildpack A has a "version" key. This key is deprecated in build plan requirements in buildpack API 0.3. "metadata.version" should be used instead`,
-					) {
-						t.Fatalf("Expected log to contain warning:\n%s\n", s)
+					}); s != "" {
+						t.Fatalf("Unexpected warnings:\n%s\n", s)
 					}
 				})
 
@@ -757,10 +1615,10 @@ func testDetector(t *testing.T, when spec.G, it spec.S) {
 					if err != nil {
 						t.Fatalf("Unexpected error:\n%s\n", err)
 					}
-					if s := allLogs(logHandler); !strings.Contains(s,
+					if s := cmp.Diff(detectRun.Warnings, []string{
 						`Warning: buildpack A has a "version" key. This key is deprecated in build plan requirements in buildpack API 0.3. "metadata.version" should be used instead`,
-					) {
-						t.Fatalf("Expected log to contain warning:\n%s\n", s)
+					}); s != "" {
+						t.Fatalf("Unexpected warnings:\n%s\n", s)
 					}
 				})
 			})
@@ -768,6 +1626,108 @@ func testDetector(t *testing.T, when spec.G, it spec.S) {
 	})
 }
 
+const cacheDetectScript = `#!/usr/bin/env bash
+bp_id=$(basename "$(cd "$(dirname "$0")/../.." && pwd)")
+echo -n . >> "$COUNTER_DIR/$bp_id"
+echo "detecting $bp_id"
+if [[ $bp_id == X ]]; then
+cat > "$2" <<EOF
+[[provides]]
+name = "dep"
+EOF
+else
+cat > "$2" <<EOF
+[[requires]]
+name = "dep"
+[requires.metadata]
+version = "1.0"
+EOF
+fi
+`
+
+// mkbuildpackage writes a buildpackage containing the buildpacks at the provided paths relative to bpsDir
+func mkbuildpackage(t *testing.T, bpkgPath, bpsDir string, bps ...string) {
+	t.Helper()
+	layerBuf := &bytes.Buffer{}
+	tw := tar.NewWriter(layerBuf)
+	for _, bp := range bps {
+		if err := writeTarDir(tw, filepath.Join(bpsDir, bp), bpsDir, "cnb/buildpacks"); err != nil {
+			t.Fatalf("Error: %s\n", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Error: %s\n", err)
+	}
+
+	layer, err := tarball.LayerFromReader(bytes.NewReader(layerBuf.Bytes()))
+	if err != nil {
+		t.Fatalf("Error: %s\n", err)
+	}
+	image, err := mutate.AppendLayers(empty.Image, layer)
+	if err != nil {
+		t.Fatalf("Error: %s\n", err)
+	}
+	layoutDir, err := ioutil.TempDir("", "buildpackage")
+	if err != nil {
+		t.Fatalf("Error: %s\n", err)
+	}
+	defer os.RemoveAll(layoutDir)
+	layoutPath, err := layout.Write(layoutDir, empty.Index)
+	if err != nil {
+		t.Fatalf("Error: %s\n", err)
+	}
+	if err := layoutPath.AppendImage(image); err != nil {
+		t.Fatalf("Error: %s\n", err)
+	}
+
+	f, err := os.Create(bpkgPath)
+	if err != nil {
+		t.Fatalf("Error: %s\n", err)
+	}
+	defer f.Close()
+	tw = tar.NewWriter(f)
+	if err := writeTarDir(tw, layoutDir, layoutDir, ""); err != nil {
+		t.Fatalf("Error: %s\n", err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Error: %s\n", err)
+	}
+}
+
+// writeTarDir writes root to tw, naming each entry by its path relative to base under prefix
+// and following symlinks so that the archive is self-contained
+func writeTarDir(tw *tar.Writer, root, base, prefix string) error {
+	return filepath.Walk(root, func(file string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(base, file)
+		if err != nil || rel == "." {
+			return err
+		}
+		if fi, err = os.Stat(file); err != nil {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(fi, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = path.Join(prefix, filepath.ToSlash(rel))
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		contents, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		_, err = tw.Write(contents)
+		return err
+	})
+}
+
 func hasEntry(l []lifecycle.BuildPlanEntry, entry lifecycle.BuildPlanEntry) bool {
 	for _, e := range l {
 		if reflect.DeepEqual(e, entry) {
@@ -789,6 +1749,16 @@ func hasEntries(a, b []lifecycle.BuildPlanEntry) bool {
 	return true
 }
 
+func assertWarning(t *testing.T, logHandler *memory.Handler, msg string) {
+	t.Helper()
+	for _, le := range logHandler.Entries {
+		if le.Level == log.WarnLevel && le.Message == msg {
+			return
+		}
+	}
+	t.Fatalf("Expected warning %q, got logs:\n%s\n", msg, allLogs(logHandler))
+}
+
 func allLogs(logHandler *memory.Handler) string {
 	var out string
 	for _, le := range logHandler.Entries {
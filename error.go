@@ -3,7 +3,9 @@ package lifecycle
 type ErrorType string
 
 const ErrTypeBuildpack ErrorType = "ERR_BUILDPACK"
+const ErrTypeBuildpackTimeout ErrorType = "ERR_BUILDPACK_TIMEOUT"
 const ErrTypeFailedDetection ErrorType = "ERR_FAILED_DETECTION"
+const ErrTypeOrderCycle ErrorType = "ERR_ORDER_CYCLE"
 
 type Error struct {
 	RootError error
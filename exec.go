@@ -0,0 +1,170 @@
+package lifecycle
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+type timeoutError struct {
+	timeout time.Duration
+	killErr error // the error killing the process group once the timeout elapsed, if any
+}
+
+func (e *timeoutError) Error() string {
+	if e.killErr != nil {
+		return fmt.Sprintf("timed out after %s, failed to kill process group: %s", e.timeout, e.killErr)
+	}
+	return fmt.Sprintf("timed out after %s", e.timeout)
+}
+
+func isTimeout(err error) bool {
+	_, ok := err.(*timeoutError)
+	return ok
+}
+
+// runCmd runs cmd in its own process group. If timeout is non-zero and elapses
+// before cmd exits, the entire process group is killed and a timeout error is returned,
+// which includes the error killing it if that failed.
+// If exited is not nil, it is called once cmd has exited and before waiting for the output
+// of cmd to be copied, so that it can terminate processes left running that hold the output open.
+func runCmd(cmd *exec.Cmd, timeout time.Duration, exited func()) error {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	output, err := pipeOutput(cmd)
+	if err != nil {
+		return err
+	}
+	group, err := startProcessGroup(cmd)
+	output.closeWriters()
+	if err != nil {
+		output.wait()
+		return err
+	}
+	defer group.close()
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		killErr := group.kill()
+		<-done
+		err = &timeoutError{timeout: timeout, killErr: killErr}
+	}
+	if exited != nil {
+		exited()
+	}
+	if copyErr := output.wait(); err == nil {
+		err = copyErr
+	}
+	return err
+}
+
+// cmdOutput copies the output of a command from pipes, which the command is given instead of its writers
+type cmdOutput struct {
+	readers []*os.File
+	writers []*os.File
+	wg      sync.WaitGroup
+	errs    chan error
+}
+
+// pipeOutput gives cmd pipes to write to in place of its stdout and stderr writers, unless they are files.
+// Otherwise exec copies the output through pipes itself and cmd.Wait does not return until every process
+// holding them open, including any daemon started by cmd, has exited.
+func pipeOutput(cmd *exec.Cmd) (*cmdOutput, error) {
+	output := &cmdOutput{errs: make(chan error, 2)}
+	stdout, err := output.pipe(cmd.Stdout)
+	if err != nil {
+		output.closeWriters()
+		output.wait()
+		return nil, err
+	}
+	stderr := stdout
+	if !sameWriter(cmd.Stdout, cmd.Stderr) {
+		if stderr, err = output.pipe(cmd.Stderr); err != nil {
+			output.closeWriters()
+			output.wait()
+			return nil, err
+		}
+	}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	return output, nil
+}
+
+func (o *cmdOutput) pipe(w io.Writer) (io.Writer, error) {
+	if _, ok := w.(*os.File); ok || w == nil {
+		return w, nil
+	}
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	o.readers = append(o.readers, pr)
+	o.writers = append(o.writers, pw)
+	o.wg.Add(1)
+	go func() {
+		defer o.wg.Done()
+		_, err := io.Copy(w, pr)
+		o.errs <- err
+	}()
+	return pw, nil
+}
+
+// closeWriters closes the write ends of the pipes, which the command holds its own copies of once started
+func (o *cmdOutput) closeWriters() {
+	for _, pw := range o.writers {
+		pw.Close()
+	}
+}
+
+// wait waits until the output is copied and returns the first error copying it
+func (o *cmdOutput) wait() error {
+	o.wg.Wait()
+	close(o.errs)
+	var err error
+	for copyErr := range o.errs {
+		if err == nil {
+			err = copyErr
+		}
+	}
+	for _, pr := range o.readers {
+		pr.Close()
+	}
+	return err
+}
+
+// sameWriter reports whether a and b are the same writer, as exec does to share a pipe between stdout and stderr
+func sameWriter(a, b io.Writer) (same bool) {
+	defer func() {
+		if recover() != nil {
+			same = false
+		}
+	}()
+	return a == b
+}
+
+// defaultGracePeriod is how long processes left behind by a buildpack have to exit after SIGTERM before they are killed
+const defaultGracePeriod = 5 * time.Second
+
+// strayProcess is a process that was still running in a buildpack's process group after the buildpack exited
+type strayProcess struct {
+	pid     int
+	command string
+}
+
+func (p strayProcess) String() string {
+	if p.command == "" {
+		return fmt.Sprintf("%d", p.pid)
+	}
+	return fmt.Sprintf("%d (%s)", p.pid, p.command)
+}
@@ -0,0 +1,23 @@
+package lifecycle
+
+import (
+	"os"
+	"syscall"
+)
+
+// processGroupMembers reports the process group itself while any process in it is running,
+// as the processes in a group cannot be listed without /proc.
+func processGroupMembers(pgid int) []strayProcess {
+	if err := syscall.Kill(-pgid, 0); err != nil {
+		return nil
+	}
+	return []strayProcess{{pid: pgid, command: "process group"}}
+}
+
+// maxRSS returns the peak resident set size of the exited process in bytes.
+func maxRSS(state *os.ProcessState) int64 {
+	if rusage, ok := state.SysUsage().(*syscall.Rusage); ok {
+		return rusage.Maxrss
+	}
+	return 0
+}
@@ -0,0 +1,48 @@
+package lifecycle
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// processGroupMembers returns the running processes in the process group, ignoring zombies.
+func processGroupMembers(pgid int) []strayProcess {
+	statPaths, err := filepath.Glob("/proc/[0-9]*/stat")
+	if err != nil {
+		return nil
+	}
+	var procs []strayProcess
+	for _, statPath := range statPaths {
+		stat, err := ioutil.ReadFile(statPath)
+		if err != nil {
+			continue
+		}
+		// the command is in parentheses and may itself contain spaces or parentheses
+		open, end := strings.IndexByte(string(stat), '('), strings.LastIndexByte(string(stat), ')')
+		if open < 0 || end < open {
+			continue
+		}
+		fields := strings.Fields(string(stat[end+1:]))
+		if len(fields) < 3 || fields[0] == "Z" || fields[2] != strconv.Itoa(pgid) {
+			continue
+		}
+		pid, err := strconv.Atoi(strings.TrimSpace(string(stat[:open])))
+		if err != nil {
+			continue
+		}
+		procs = append(procs, strayProcess{pid: pid, command: string(stat[open+1 : end])})
+	}
+	return procs
+}
+
+// maxRSS returns the peak resident set size of the exited process in bytes.
+func maxRSS(state *os.ProcessState) int64 {
+	if rusage, ok := state.SysUsage().(*syscall.Rusage); ok {
+		return rusage.Maxrss * 1024
+	}
+	return 0
+}
@@ -0,0 +1,74 @@
+// +build linux darwin
+
+package lifecycle
+
+import (
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// processGroup is the process group of a command, which its descendants inherit
+type processGroup struct {
+	pgid int
+}
+
+// startProcessGroup starts cmd as the leader of a new process group.
+func startProcessGroup(cmd *exec.Cmd) (*processGroup, error) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &processGroup{pgid: cmd.Process.Pid}, nil
+}
+
+func (g *processGroup) kill() error {
+	return signalProcessGroup(g.pgid, syscall.SIGKILL)
+}
+
+func (g *processGroup) close() {}
+
+// terminateProcessGroup terminates the processes remaining in the process group of cmd after cmd exited.
+// They are sent SIGTERM, then SIGKILL if any are still running after gracePeriod.
+// It returns the processes that were still running.
+func terminateProcessGroup(cmd *exec.Cmd, gracePeriod time.Duration) ([]strayProcess, error) {
+	pgid := cmd.Process.Pid
+	stray := processGroupMembers(pgid)
+	if len(stray) == 0 {
+		return nil, nil
+	}
+	if err := signalProcessGroup(pgid, syscall.SIGTERM); err != nil {
+		return stray, err
+	}
+	for deadline := time.Now().Add(gracePeriod); time.Now().Before(deadline); {
+		reapProcessGroup(pgid)
+		if len(processGroupMembers(pgid)) == 0 {
+			return stray, nil
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	err := signalProcessGroup(pgid, syscall.SIGKILL)
+	reapProcessGroup(pgid)
+	return stray, err
+}
+
+func signalProcessGroup(pgid int, sig syscall.Signal) error {
+	if err := syscall.Kill(-pgid, sig); err != nil && err != syscall.ESRCH {
+		return err
+	}
+	return nil
+}
+
+// reapProcessGroup waits for exited processes in the group that were reparented to the lifecycle,
+// which happens when it runs as PID 1 in a container.
+func reapProcessGroup(pgid int) {
+	var status syscall.WaitStatus
+	for {
+		if pid, err := syscall.Wait4(-pgid, &status, syscall.WNOHANG, nil); pid <= 0 || err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package lifecycle
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/windows"
+)
+
+// processGroup is the job object a command is assigned to, which its descendants inherit
+type processGroup struct {
+	job windows.Handle
+}
+
+// startProcessGroup starts cmd in a new process group and assigns it to a new job object.
+// Processes that cmd starts before it is assigned to the job are not in the job, so kill does not reach them.
+func startProcessGroup(cmd *exec.Cmd) (*processGroup, error) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.CreationFlags |= syscall.CREATE_NEW_PROCESS_GROUP
+	job, err := windows.CreateJobObject(nil, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "create job object")
+	}
+	if err := cmd.Start(); err != nil {
+		windows.CloseHandle(job)
+		return nil, err
+	}
+	process, err := windows.OpenProcess(windows.PROCESS_TERMINATE|windows.PROCESS_SET_QUOTA, false, uint32(cmd.Process.Pid))
+	if err == nil {
+		err = windows.AssignProcessToJobObject(job, process)
+		windows.CloseHandle(process)
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		windows.CloseHandle(job)
+		return nil, errors.Wrap(err, "assign process to job object")
+	}
+	return &processGroup{job: job}, nil
+}
+
+func (g *processGroup) kill() error {
+	return windows.TerminateJobObject(g.job, 1)
+}
+
+func (g *processGroup) close() {
+	windows.CloseHandle(g.job)
+}
+
+// terminateProcessGroup does nothing on Windows, where processes cannot be listed by process group.
+func terminateProcessGroup(cmd *exec.Cmd, gracePeriod time.Duration) ([]strayProcess, error) {
+	return nil, nil
+}
+
+// maxRSS returns zero, as the peak resident set size is not reported on Windows.
+func maxRSS(state *os.ProcessState) int64 {
+	return 0
+}
@@ -6,7 +6,10 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/buildpacks/imgutil"
@@ -29,17 +32,25 @@ type Cache interface {
 }
 
 type Exporter struct {
-	Buildpacks   []Buildpack
-	LayerFactory LayerFactory
-	Logger       Logger
-	PlatformAPI  *api.Version
+	Buildpacks         []Buildpack
+	LayerFactory       LayerFactory
+	Logger             Logger
+	PlatformAPI        *api.Version
+	DetectCache        []DetectCacheEntry // the detect results to cache if DetectCacheEnabled, otherwise the previously cached results are kept
+	DetectCacheEnabled bool
+	LayerWorkers       int // the maximum number of buildpack layers tarred concurrently, layers are tarred one at a time if less than 2
+	MaxLayers          int // the maximum number of layers added on top of the run image, launch layers are merged to stay within it if more than 0
+
+	tarPaths map[string]string // the tarballs of the exported layers by digest, to compare their files when verifying
 }
 
 //go:generate mockgen -package testmock -destination testmock/layer_factory.go github.com/buildpacks/lifecycle LayerFactory
 type LayerFactory interface {
 	DirLayer(id string, dir string) (layers.Layer, error)
 	LauncherLayer(path string) (layers.Layer, error)
+	MergedLayer(id string, dirs []string) (layers.Layer, error)
 	ProcessTypesLayer(metadata launch.Metadata) (layers.Layer, error)
+	SBOMLayer(dir string, docs map[string][]byte) (layers.Layer, error)
 	SliceLayers(dir string, slices []layers.Slice) ([]layers.Layer, error)
 }
 
@@ -58,11 +69,19 @@ type ExportOptions struct {
 	LauncherConfig     LauncherConfig
 	Stack              StackMetadata
 	Project            ProjectMetadata
+	RequiredMixins     []string // the mixins the buildpacks require on the stack, which the run image must have
 	DefaultProcessType string
+	SBOMDir            string
+	Secrets            Secrets          // launch layers must not contain the value of any secret
+	Reference          *VerifyReference // if set, the export is compared to the reference instead of being saved
+	CreatedAt          time.Time        // the creation time of the image, defaults to imgutil.NormalizedDateTime
 }
 
 type ExportReport struct {
-	Image ImageReport `toml:"image"`
+	Image  ImageReport       `toml:"image"`
+	Layers []LayerReport     `toml:"layers,omitempty"`
+	Config map[string]string `toml:"config,omitempty"` // the SHA-256 of each label and env var of the image
+	Verify *VerifyReport     `toml:"verify,omitempty"`
 }
 
 type ImageReport struct {
@@ -73,6 +92,10 @@ type ImageReport struct {
 
 func (e *Exporter) Export(opts ExportOptions) (ExportReport, error) {
 	var err error
+	e.tarPaths = map[string]string{}
+	if opts.CreatedAt.IsZero() {
+		opts.CreatedAt = imgutil.NormalizedDateTime
+	}
 
 	opts.LayersDir, err = filepath.Abs(opts.LayersDir)
 	if err != nil {
@@ -84,6 +107,16 @@ func (e *Exporter) Export(opts ExportOptions) (ExportReport, error) {
 		return ExportReport{}, errors.Wrapf(err, "app dir absolute path")
 	}
 
+	if len(opts.RequiredMixins) > 0 {
+		runMixins, err := ReadMixins(opts.WorkingImage)
+		if err != nil {
+			return ExportReport{}, errors.Wrap(err, "get run image mixins")
+		}
+		if err := validateRunMixins(opts.RequiredMixins, runMixins); err != nil {
+			return ExportReport{}, errors.Wrapf(err, "incompatible run image '%s'", opts.RunImageRef)
+		}
+	}
+
 	meta := LayersMetadata{}
 	meta.RunImage.TopLayer, err = opts.WorkingImage.TopLayer()
 	if err != nil {
@@ -99,7 +132,7 @@ func (e *Exporter) Export(opts ExportOptions) (ExportReport, error) {
 	}
 
 	// buildpack-provided layers
-	if err := e.addBuildpackLayers(opts, &meta); err != nil {
+	if err := e.addBuildpackLayers(opts, e.lifecycleLayers(buildMD), &meta); err != nil {
 		return ExportReport{}, err
 	}
 
@@ -113,6 +146,11 @@ func (e *Exporter) Export(opts ExportOptions) (ExportReport, error) {
 		return ExportReport{}, err
 	}
 
+	// SBOM layer (CycloneDX and SPDX documents generated from the bill of materials)
+	if err := e.addSBOMLayer(opts, buildMD.BOM, &meta); err != nil {
+		return ExportReport{}, errors.Wrap(err, "exporting SBOM")
+	}
+
 	if err := e.setLabels(opts, meta, buildMD); err != nil {
 		return ExportReport{}, err
 	}
@@ -134,8 +172,21 @@ func (e *Exporter) Export(opts ExportOptions) (ExportReport, error) {
 		return ExportReport{}, errors.Wrap(err, "setting cmd")
 	}
 
-	report := ExportReport{}
-	report.Image, err = saveImage(opts.WorkingImage, opts.AdditionalNames, e.Logger)
+	report := ExportReport{Layers: meta.layerReports()}
+	report.Config, err = configDigests(opts.WorkingImage)
+	if err != nil {
+		return ExportReport{}, errors.Wrap(err, "reading image config")
+	}
+
+	if opts.Reference != nil {
+		report.Verify, err = e.verify(report, opts.Reference)
+		if err != nil {
+			return ExportReport{}, errors.Wrapf(err, "verifying against reference '%s'", opts.Reference.Name)
+		}
+		return report, nil
+	}
+
+	report.Image, err = saveImage(opts.WorkingImage, opts.CreatedAt, opts.AdditionalNames, e.Logger)
 	if err != nil {
 		return ExportReport{}, err
 	}
@@ -143,66 +194,425 @@ func (e *Exporter) Export(opts ExportOptions) (ExportReport, error) {
 	return report, nil
 }
 
-func (e *Exporter) addBuildpackLayers(opts ExportOptions, meta *LayersMetadata) error {
+func (e *Exporter) addBuildpackLayers(opts ExportOptions, lifecycleLayers int, meta *LayersMetadata) error {
+	var bpDirs []bpLayersDir
+	var launchLayers []*launchLayer
+	var localLayers []bpLayer
 	for _, bp := range e.Buildpacks {
 		bpDir, err := readBuildpackLayersDir(opts.LayersDir, bp)
 		if err != nil {
 			return errors.Wrapf(err, "reading layers for buildpack '%s'", bp.ID)
 		}
+		bpDirs = append(bpDirs, bpDir)
+		for _, fsLayer := range bpDir.findLayers(forLaunch) {
+			lmd, err := fsLayer.read()
+			if err != nil {
+				return errors.Wrapf(err, "reading '%s' metadata", fsLayer.Identifier())
+			}
+			launchLayers = append(launchLayers, &launchLayer{bpLayer: fsLayer, index: len(launchLayers), buildpack: bp.ID, metadata: lmd})
+			if fsLayer.hasLocalContents() {
+				localLayers = append(localLayers, fsLayer)
+			}
+		}
+
+		if malformedLayers := bpDir.findLayers(forMalformed); len(malformedLayers) > 0 {
+			ids := make([]string, 0, len(malformedLayers))
+			var causes []string
+			for _, ml := range malformedLayers {
+				ids = append(ids, ml.Identifier())
+				if _, err := ml.read(); err != nil {
+					causes = append(causes, err.Error())
+				}
+			}
+			return fmt.Errorf("failed to parse metadata for layers '%s': %s", ids, strings.Join(causes, "; "))
+		}
+	}
+	stop := make(chan struct{})
+	var tarring sync.WaitGroup
+	defer func() {
+		close(stop)
+		tarring.Wait()
+	}()
+	dirLayers := e.dirLayers(localLayers, opts.Secrets, stop, &tarring)
+	for _, l := range launchLayers {
+		l.result = dirLayers[l.Identifier()]
+	}
+
+	groups, err := e.groupLayers(launchLayers, e.MaxLayers-lifecycleLayers, opts.OrigMetadata)
+	if err != nil {
+		return err
+	}
+	for _, group := range groups {
+		if len(group.layers) == 1 && group.previous == nil {
+			err = e.addLaunchLayer(opts, group.layers[0])
+		} else {
+			err = e.addMergedLayer(opts, group, meta)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, bpDir := range bpDirs {
+		bp := bpDir.buildpack
 		bpMD := BuildpackLayersMetadata{
 			ID:      bp.ID,
 			Version: bp.Version,
 			Layers:  map[string]BuildpackLayerMetadata{},
 			Store:   bpDir.store,
 		}
-		for _, fsLayer := range bpDir.findLayers(forLaunch) {
-			fsLayer := fsLayer
-			lmd, err := fsLayer.read()
-			if err != nil {
-				return errors.Wrapf(err, "reading '%s' metadata", fsLayer.Identifier())
+		for _, l := range launchLayers {
+			if l.buildpack == bp.ID {
+				bpMD.Layers[l.name()] = l.metadata
 			}
+		}
+		meta.Buildpacks = append(meta.Buildpacks, bpMD)
+	}
+	return nil
+}
 
-			if fsLayer.hasLocalContents() {
-				layer, err := e.LayerFactory.DirLayer(fsLayer.Identifier(), fsLayer.path)
-				if err != nil {
-					return errors.Wrapf(err, "creating layer")
+// launchLayer is a buildpack layer that is exported in the app image.
+type launchLayer struct {
+	bpLayer
+	index     int // the position of the layer among the launch layers of all buildpacks
+	buildpack string
+	metadata  BuildpackLayerMetadata
+	result    chan dirLayer // receives the layer created from the local contents, nil if there are none
+
+	received bool
+	created  dirLayer
+	size     int64
+}
+
+// layer returns the layer created from the local contents, waiting for it to be created if necessary.
+func (l *launchLayer) layer() (layers.Layer, error) {
+	if !l.received {
+		l.created = <-l.result
+		l.received = true
+	}
+	return l.created.layer, l.created.err
+}
+
+// tarSize returns the size of the tarball created from the local contents.
+func (l *launchLayer) tarSize() (int64, error) {
+	if l.size == 0 {
+		layer, err := l.layer()
+		if err != nil {
+			return 0, err
+		}
+		fi, err := os.Stat(layer.TarPath)
+		if err != nil {
+			return 0, err
+		}
+		l.size = fi.Size()
+	}
+	return l.size, nil
+}
+
+// layerGroup is a group of launch layers that are added to the image as one layer, if there is more than one.
+type layerGroup struct {
+	layers   []*launchLayer
+	previous *MergedLayerMetadata // the merged layer of the previous image that the group can reuse
+}
+
+// buildpack returns the ID of the buildpack that contributed all layers in the group, or an empty string
+// if they were contributed by several buildpacks.
+func (g *layerGroup) buildpack() string {
+	for _, l := range g.layers {
+		if l.buildpack != g.layers[0].buildpack {
+			return ""
+		}
+	}
+	return g.layers[0].buildpack
+}
+
+// mergeable returns true if all layers in the group have local contents that can be merged with other layers.
+func (g *layerGroup) mergeable() bool {
+	for _, l := range g.layers {
+		if l.result == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// withoutContents returns the first layer in the group that has no local contents.
+func (g *layerGroup) withoutContents() *launchLayer {
+	for _, l := range g.layers {
+		if l.result == nil {
+			return l
+		}
+	}
+	return nil
+}
+
+// missing returns the layers in ids that are not in the group.
+func (g *layerGroup) missing(ids []string) []string {
+	var missing []string
+	for _, id := range ids {
+		found := false
+		for _, l := range g.layers {
+			if l.Identifier() == id {
+				found = true
+			}
+		}
+		if !found {
+			missing = append(missing, id)
+		}
+	}
+	return missing
+}
+
+func (g *layerGroup) tarSize() (int64, error) {
+	var size int64
+	for _, l := range g.layers {
+		lsize, err := l.tarSize()
+		if err != nil {
+			return 0, err
+		}
+		size += lsize
+	}
+	return size, nil
+}
+
+// groupLayers returns the launch layers grouped into the layers of the image. Layers that were merged in the previous
+// image are merged again if a maximum number of layers is set, so that the merged layer can be reused, or if any of them
+// has no local contents, as it can only be reused as part of the merged layer. This is only done if all the layers of
+// the merged layer still exist; it is an error if they do not and one of them has no local contents.
+// Then, while there are more groups than slots, the two smallest groups are merged, preferring groups of layers
+// from the same buildpack.
+func (e *Exporter) groupLayers(launchLayers []*launchLayer, slots int, orig LayersMetadata) ([]*layerGroup, error) {
+	var groups []*layerGroup
+	grouped := map[string]bool{}
+	for _, l := range launchLayers {
+		if grouped[l.Identifier()] {
+			continue
+		}
+		group := &layerGroup{layers: []*launchLayer{l}}
+		if previous := orig.mergedLayerFor(l.Identifier()); previous != nil {
+			merged := &layerGroup{previous: previous}
+			for _, other := range launchLayers {
+				for _, id := range previous.Layers {
+					if other.Identifier() == id {
+						merged.layers = append(merged.layers, other)
+					}
+				}
+			}
+			if len(merged.layers) != len(previous.Layers) {
+				if !merged.mergeable() {
+					return nil, fmt.Errorf("cannot reuse layer '%s' without contents, layers it was merged with in the previous image no longer exist: '%s'",
+						merged.withoutContents().Identifier(), strings.Join(merged.missing(previous.Layers), "', '"))
+				}
+			} else if e.MaxLayers > 0 || !merged.mergeable() {
+				group = merged
+			}
+		}
+		for _, member := range group.layers {
+			grouped[member.Identifier()] = true
+		}
+		groups = append(groups, group)
+	}
+
+	for e.MaxLayers > 0 && len(groups) > 0 && len(groups) > slots {
+		a, b := -1, -1
+		var bestSize int64
+		bestSameBuildpack := false
+		for i := range groups {
+			if !groups[i].mergeable() {
+				continue
+			}
+			for j := i + 1; j < len(groups); j++ {
+				if !groups[j].mergeable() {
+					continue
+				}
+				sameBuildpack := groups[i].buildpack() != "" && groups[i].buildpack() == groups[j].buildpack()
+				if bestSameBuildpack && !sameBuildpack {
+					continue
 				}
-				origLayerMetadata := opts.OrigMetadata.MetadataForBuildpack(bp.ID).Layers[fsLayer.name()]
-				lmd.SHA, err = e.addOrReuseLayer(opts.WorkingImage, layer, origLayerMetadata.SHA)
+				iSize, err := groups[i].tarSize()
 				if err != nil {
-					return err
+					return nil, err
 				}
-			} else {
-				if lmd.Cache {
-					return fmt.Errorf("layer '%s' is cache=true but has no contents", fsLayer.Identifier())
+				jSize, err := groups[j].tarSize()
+				if err != nil {
+					return nil, err
 				}
-				origLayerMetadata, ok := opts.OrigMetadata.MetadataForBuildpack(bp.ID).Layers[fsLayer.name()]
-				if !ok {
-					return fmt.Errorf("cannot reuse '%s', previous image has no metadata for layer '%s'", fsLayer.Identifier(), fsLayer.Identifier())
+				if a < 0 || (sameBuildpack && !bestSameBuildpack) || iSize+jSize < bestSize {
+					a, b, bestSize, bestSameBuildpack = i, j, iSize+jSize, sameBuildpack
 				}
+			}
+		}
+		if a < 0 {
+			return nil, fmt.Errorf("cannot merge %d buildpack layers into the %d that fit within the maximum of %d layers added to the run image", len(launchLayers), slots, e.MaxLayers)
+		}
+		merged := append(append([]*launchLayer{}, groups[a].layers...), groups[b].layers...)
+		sort.Slice(merged, func(i, j int) bool { return merged[i].index < merged[j].index })
+		groups[a] = &layerGroup{layers: merged}
+		groups = append(groups[:b], groups[b+1:]...)
+	}
 
-				e.Logger.Infof("Reusing layer '%s'\n", fsLayer.Identifier())
-				e.Logger.Debugf("Layer '%s' SHA: %s\n", fsLayer.Identifier(), origLayerMetadata.SHA)
-				if err := opts.WorkingImage.ReuseLayer(origLayerMetadata.SHA); err != nil {
-					return errors.Wrapf(err, "reusing layer: '%s'", fsLayer.Identifier())
-				}
-				lmd.SHA = origLayerMetadata.SHA
+	for _, group := range groups {
+		if len(group.layers) < 2 || group.previous != nil {
+			continue
+		}
+		var ids []string
+		for _, l := range group.layers {
+			ids = append(ids, l.Identifier())
+		}
+		for i, previous := range orig.Merged {
+			if strings.Join(previous.Layers, ",") == strings.Join(ids, ",") {
+				group.previous = &orig.Merged[i]
 			}
-			bpMD.Layers[fsLayer.name()] = lmd
 		}
-		meta.Buildpacks = append(meta.Buildpacks, bpMD)
+	}
+	return groups, nil
+}
 
-		if malformedLayers := bpDir.findLayers(forMalformed); len(malformedLayers) > 0 {
-			ids := make([]string, 0, len(malformedLayers))
-			for _, ml := range malformedLayers {
-				ids = append(ids, ml.Identifier())
+func (e *Exporter) addLaunchLayer(opts ExportOptions, l *launchLayer) error {
+	origLayerMetadata, ok := opts.OrigMetadata.MetadataForBuildpack(l.buildpack).Layers[l.name()]
+	if l.result != nil {
+		layer, err := l.layer()
+		if err != nil {
+			return err
+		}
+		previousSHA := origLayerMetadata.SHA
+		if opts.OrigMetadata.mergedLayerFor(l.Identifier()) != nil {
+			previousSHA = "" // the layer is only in the previous image as part of a merged layer
+		}
+		l.metadata.SHA, err = e.addOrReuseLayer(opts.WorkingImage, layer, previousSHA)
+		return err
+	}
+
+	if l.metadata.Cache {
+		return fmt.Errorf("layer '%s' is cache=true but has no contents", l.Identifier())
+	}
+	if !ok {
+		return fmt.Errorf("cannot reuse '%s', previous image has no metadata for layer '%s'", l.Identifier(), l.Identifier())
+	}
+	e.Logger.Infof("Reusing layer '%s'\n", l.Identifier())
+	e.Logger.Debugf("Layer '%s' SHA: %s\n", l.Identifier(), origLayerMetadata.SHA)
+	if err := opts.WorkingImage.ReuseLayer(origLayerMetadata.SHA); err != nil {
+		return errors.Wrapf(err, "reusing layer: '%s'", l.Identifier())
+	}
+	l.metadata.SHA = origLayerMetadata.SHA
+	return nil
+}
+
+// addMergedLayer adds the layers in the group as one layer. If any of the layers has no local contents, the merged
+// layer of the previous image is reused, which is only possible if none of the other layers changed since.
+func (e *Exporter) addMergedLayer(opts ExportOptions, group *layerGroup, meta *LayersMetadata) error {
+	id := fmt.Sprintf("merged-%d", len(meta.Merged)+1)
+	merged := MergedLayerMetadata{}
+	var dirs []string
+	var changed []string
+	reusePrevious := false
+	for _, l := range group.layers {
+		merged.Layers = append(merged.Layers, l.Identifier())
+		origLayerMetadata, ok := opts.OrigMetadata.MetadataForBuildpack(l.buildpack).Layers[l.name()]
+		if l.result == nil {
+			if l.metadata.Cache {
+				return fmt.Errorf("layer '%s' is cache=true but has no contents", l.Identifier())
+			}
+			if !ok {
+				return fmt.Errorf("cannot reuse '%s', previous image has no metadata for layer '%s'", l.Identifier(), l.Identifier())
 			}
-			return fmt.Errorf("failed to parse metadata for layers '%s'", ids)
+			l.metadata.SHA = origLayerMetadata.SHA
+			reusePrevious = true
+			continue
+		}
+		layer, err := l.layer()
+		if err != nil {
+			return err
+		}
+		l.metadata.SHA = layer.Digest
+		dirs = append(dirs, l.path)
+		if layer.Digest != origLayerMetadata.SHA {
+			changed = append(changed, l.Identifier())
+		}
+	}
+	e.Logger.Infof("Merging layers '%s' into layer '%s'\n", strings.Join(merged.Layers, "', '"), id)
+
+	if reusePrevious {
+		if len(changed) > 0 {
+			return fmt.Errorf("cannot reuse layer '%s' without contents, layers it was merged with in the previous image changed: '%s'",
+				group.withoutContents().Identifier(), strings.Join(changed, "', '"))
+		}
+		e.Logger.Infof("Reusing layer '%s'\n", id)
+		e.Logger.Debugf("Layer '%s' SHA: %s\n", id, group.previous.SHA)
+		if err := opts.WorkingImage.ReuseLayer(group.previous.SHA); err != nil {
+			return errors.Wrapf(err, "reusing layer: '%s'", id)
 		}
+		merged.SHA = group.previous.SHA
+		meta.Merged = append(meta.Merged, merged)
+		return nil
 	}
+
+	mergedLayer, err := e.LayerFactory.MergedLayer(id, dirs)
+	if err != nil {
+		return errors.Wrapf(err, "creating layer '%s'", id)
+	}
+	previousSHA := ""
+	if group.previous != nil {
+		previousSHA = group.previous.SHA
+	}
+	merged.SHA, err = e.addOrReuseLayer(opts.WorkingImage, mergedLayer, previousSHA)
+	if err != nil {
+		return err
+	}
+	meta.Merged = append(meta.Merged, merged)
 	return nil
 }
 
+type dirLayer struct {
+	layer layers.Layer
+	err   error
+}
+
+// dirLayers starts checking the layers for secrets and tarring them, up to LayerWorkers at a time,
+// and returns a channel for each layer that receives the result. Layers are started in order,
+// so that they are ready in the order they are added to the image. No more layers are started once stop is closed;
+// wg is done once the layers that were started are finished.
+func (e *Exporter) dirLayers(fsLayers []bpLayer, secrets Secrets, stop <-chan struct{}, wg *sync.WaitGroup) map[string]chan dirLayer {
+	workers := e.LayerWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	sem := make(chan struct{}, workers)
+	results := make(map[string]chan dirLayer, len(fsLayers))
+	for _, fsLayer := range fsLayers {
+		results[fsLayer.Identifier()] = make(chan dirLayer, 1)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for _, fsLayer := range fsLayers {
+			select {
+			case sem <- struct{}{}:
+			case <-stop:
+				return
+			}
+			wg.Add(1)
+			go func(fsLayer bpLayer) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				results[fsLayer.Identifier()] <- e.dirLayer(fsLayer, secrets)
+			}(fsLayer)
+		}
+	}()
+	return results
+}
+
+func (e *Exporter) dirLayer(fsLayer bpLayer, secrets Secrets) dirLayer {
+	if err := secrets.checkLayer(fsLayer.path); err != nil {
+		return dirLayer{err: errors.Wrapf(err, "checking layer '%s' for secrets", fsLayer.Identifier())}
+	}
+	layer, err := e.LayerFactory.DirLayer(fsLayer.Identifier(), fsLayer.path)
+	if err != nil {
+		return dirLayer{err: errors.Wrap(err, "creating layer")}
+	}
+	return dirLayer{layer: layer}
+}
+
 func (e *Exporter) addLauncherLayers(opts ExportOptions, buildMD *BuildMetadata, meta *LayersMetadata) error {
 	launcherLayer, err := e.LayerFactory.LauncherLayer(opts.LauncherConfig.Path)
 	if err != nil {
@@ -227,6 +637,30 @@ func (e *Exporter) addLauncherLayers(opts ExportOptions, buildMD *BuildMetadata,
 	return nil
 }
 
+// addSBOMLayer adds a layer with SBOM documents for the bill of materials, which are found in the sbom directory
+// of the layers directory in the image, and writes them to opts.SBOMDir if it is set and the image is exported.
+// Nothing is exported if the bill of materials is empty.
+func (e *Exporter) addSBOMLayer(opts ExportOptions, bom []BOMEntry, meta *LayersMetadata) error {
+	if len(bom) == 0 {
+		return nil
+	}
+	docs, err := sbomDocuments(bom, opts.CreatedAt)
+	if err != nil {
+		return err
+	}
+	if opts.SBOMDir != "" && opts.Reference == nil {
+		if err := writeSBOM(opts.SBOMDir, docs); err != nil {
+			return err
+		}
+	}
+	sbomLayer, err := e.LayerFactory.SBOMLayer(filepath.Join(opts.LayersDir, "sbom"), docs)
+	if err != nil {
+		return errors.Wrap(err, "creating layer 'sbom'")
+	}
+	meta.SBOM.SHA, err = e.addOrReuseLayer(opts.WorkingImage, sbomLayer, opts.OrigMetadata.SBOM.SHA)
+	return err
+}
+
 func (e *Exporter) addAppLayers(opts ExportOptions, slices []layers.Slice, meta *LayersMetadata) error {
 	// creating app layers (slices + app dir)
 	sliceLayers, err := e.LayerFactory.SliceLayers(opts.AppDir, slices)
@@ -255,6 +689,7 @@ func (e *Exporter) addAppLayers(opts ExportOptions, slices []layers.Slice, meta
 			return err
 		}
 		e.Logger.Debugf("Layer '%s' SHA: %s\n", slice.ID, slice.Digest)
+		e.tarPaths[slice.Digest] = slice.TarPath
 		meta.App = append(meta.App, LayerMetadata{SHA: slice.Digest})
 	}
 
@@ -392,6 +827,20 @@ func (e *Exporter) launcherConfig(opts ExportOptions, buildMD *BuildMetadata, me
 	return nil
 }
 
+// lifecycleLayers returns the number of layers the exporter adds in addition to the buildpack layers:
+// the app slices, the launcher and config layers, the process-types layer and the SBOM layer.
+// The layers of the run image are not counted, as MaxLayers only limits the layers added on top of it.
+func (e *Exporter) lifecycleLayers(buildMD *BuildMetadata) int {
+	count := len(buildMD.Slices) + 3
+	if e.supportsMulticallLauncher() && len(buildMD.Processes) > 0 {
+		count++
+	}
+	if len(buildMD.BOM) > 0 {
+		count++
+	}
+	return count
+}
+
 func (e *Exporter) supportsMulticallLauncher() bool {
 	return e.PlatformAPI.Compare(api.MustParse("0.4")) >= 0
 }
@@ -413,6 +862,7 @@ func (e *Exporter) addOrReuseLayer(image imgutil.Image, layer layers.Layer, prev
 	if err != nil {
 		return "", errors.Wrapf(err, "creating layer '%s'", layer.ID)
 	}
+	e.tarPaths[layer.Digest] = layer.TarPath
 	if layer.Digest == previousSHA {
 		e.Logger.Infof("Reusing layer '%s'\n", layer.ID)
 		e.Logger.Debugf("Layer '%s' SHA: %s\n", layer.ID, layer.Digest)
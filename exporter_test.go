@@ -14,6 +14,7 @@ import (
 
 	"github.com/apex/log"
 	"github.com/apex/log/handlers/memory"
+	"github.com/buildpacks/imgutil"
 	"github.com/buildpacks/imgutil/fakes"
 	"github.com/buildpacks/imgutil/local"
 	"github.com/buildpacks/imgutil/remote"
@@ -47,6 +48,9 @@ func testExporter(t *testing.T, when spec.G, it spec.S) {
 			RunImageRef:     "run-image-reference",
 			AdditionalNames: []string{},
 		}
+
+		sbomLayerDir  string
+		sbomLayerDocs map[string][]byte
 	)
 
 	it.Before(func() {
@@ -97,6 +101,13 @@ func testExporter(t *testing.T, when spec.G, it spec.S) {
 				return createTestLayer(id, tmpDir)
 			}).AnyTimes()
 
+		layerFactory.EXPECT().
+			SBOMLayer(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(dir string, docs map[string][]byte) (layers.Layer, error) {
+				sbomLayerDir, sbomLayerDocs = dir, docs
+				return createTestLayer("sbom", tmpDir)
+			}).AnyTimes()
+
 		layerFactory.EXPECT().
 			LauncherLayer(launcherPath).
 			DoAndReturn(func(path string) (layers.Layer, error) { return createTestLayer("launcher", tmpDir) }).
@@ -241,6 +252,148 @@ func testExporter(t *testing.T, when spec.G, it spec.S) {
 				assertAddLayerLog(t, logHandler, "config")
 			})
 
+			it("creates SBOM layer from the bill of materials", func() {
+				_, err := exporter.Export(opts)
+				h.AssertNil(t, err)
+
+				assertHasLayer(t, fakeAppImage, "sbom")
+				assertAddLayerLog(t, logHandler, "sbom")
+				h.AssertEq(t, sbomLayerDir, filepath.Join(opts.LayersDir, "sbom"))
+				h.AssertEq(t, len(sbomLayerDocs), 2)
+				h.AssertPathDoesNotExist(t, filepath.Join(opts.LayersDir, "sbom"))
+			})
+
+			when("an SBOM directory is provided", func() {
+				it.Before(func() {
+					opts.SBOMDir = filepath.Join(tmpDir, "sbom")
+				})
+
+				it.After(func() {
+					opts.SBOMDir = ""
+				})
+
+				it("writes a CycloneDX document with the buildpack and metadata as properties", func() {
+					_, err := exporter.Export(opts)
+					h.AssertNil(t, err)
+
+					var cdx lifecycle.CycloneDX
+					data, err := ioutil.ReadFile(filepath.Join(opts.SBOMDir, "bom.cdx.json"))
+					h.AssertNil(t, err)
+					h.AssertNil(t, json.Unmarshal(data, &cdx))
+
+					h.AssertEq(t, cdx.BOMFormat, "CycloneDX")
+					h.AssertEq(t, cdx.Components, []lifecycle.CycloneDXComponent{{
+						Type:    "library",
+						Name:    "Spring Auto-reconfiguration",
+						Version: "2.7.0",
+						Properties: []lifecycle.CycloneDXProperty{
+							{Name: "io.buildpacks.buildpack.id", Value: "buildpack.id"},
+							{Name: "io.buildpacks.buildpack.version", Value: "1.2.3"},
+							{Name: "io.buildpacks.metadata.licenses", Value: `[{"type":"Apache-2.0"}]`},
+							{Name: "io.buildpacks.metadata.sha256", Value: "0d524877db7344ec34620f7e46254053568292f5ce514f74e3a0e9b2dbfc338b"},
+							{Name: "io.buildpacks.metadata.stacks", Value: `["io.buildpacks.stacks.bionic","org.cloudfoundry.stacks.cflinuxfs3"]`},
+							{Name: "io.buildpacks.metadata.uri", Value: "https://example.com"},
+						},
+					}})
+				})
+
+				it("writes an SPDX document attributing packages to buildpacks", func() {
+					_, err := exporter.Export(opts)
+					h.AssertNil(t, err)
+
+					var spdx lifecycle.SPDX
+					data, err := ioutil.ReadFile(filepath.Join(opts.SBOMDir, "bom.spdx.json"))
+					h.AssertNil(t, err)
+					h.AssertNil(t, json.Unmarshal(data, &spdx))
+
+					h.AssertEq(t, spdx.SPDXVersion, "SPDX-2.2")
+					h.AssertEq(t, spdx.CreationInfo.Created, "1980-01-01T00:00:01Z")
+					h.AssertEq(t, len(spdx.Packages), 1)
+					h.AssertEq(t, spdx.Packages[0].Name, "Spring Auto-reconfiguration")
+					h.AssertEq(t, spdx.Packages[0].VersionInfo, "2.7.0")
+					h.AssertEq(t, spdx.Packages[0].SourceInfo, "contributed by buildpack buildpack.id@1.2.3")
+					h.AssertEq(t, len(spdx.Packages[0].Annotations), 4)
+					h.AssertEq(t, spdx.Packages[0].Annotations[3].Comment, "io.buildpacks.metadata.uri=https://example.com")
+				})
+
+				it("takes the versions of entries from their metadata on Platform API 0.4 and later", func() {
+					bom := []lifecycle.BOMEntry{{
+						Require: lifecycle.Require{
+							Name:     "some-dep",
+							Metadata: map[string]interface{}{"version": "v1"},
+						},
+						Buildpack: lifecycle.Buildpack{ID: "buildpack.id", Version: "1.2.3"},
+					}}
+
+					cdx, err := lifecycle.NewCycloneDX(bom)
+					h.AssertNil(t, err)
+					h.AssertEq(t, cdx.Components[0].Version, "v1")
+
+					spdx, err := lifecycle.NewSPDX(bom, imgutil.NormalizedDateTime)
+					h.AssertNil(t, err)
+					h.AssertEq(t, spdx.Packages[0].VersionInfo, "v1")
+				})
+
+				it("writes the same documents as the SBOM layer", func() {
+					_, err := exporter.Export(opts)
+					h.AssertNil(t, err)
+
+					for _, file := range []string{"bom.cdx.json", "bom.spdx.json"} {
+						h.AssertEq(t,
+							rdfile(t, filepath.Join(opts.SBOMDir, file)),
+							string(sbomLayerDocs[file]),
+						)
+					}
+				})
+
+				it("creates the SPDX document at the default creation time of the image", func() {
+					savedImage := &savedAtImage{Image: fakeAppImage}
+					opts.WorkingImage = savedImage
+
+					_, err := exporter.Export(opts)
+					h.AssertNil(t, err)
+
+					var spdx lifecycle.SPDX
+					h.AssertNil(t, json.Unmarshal([]byte(rdfile(t, filepath.Join(opts.SBOMDir, "bom.spdx.json"))), &spdx))
+					h.AssertEq(t, spdx.CreationInfo.Created, savedImage.savedAt.Format("2006-01-02T15:04:05Z"))
+				})
+
+				when("a creation time is provided", func() {
+					var (
+						defaultDateTime time.Time
+						savedImage      *savedAtImage
+					)
+
+					it.Before(func() {
+						defaultDateTime = imgutil.NormalizedDateTime
+						opts.CreatedAt = time.Date(2021, time.March, 4, 5, 6, 7, 0, time.UTC)
+						savedImage = &savedAtImage{Image: fakeAppImage}
+						opts.WorkingImage = savedImage
+					})
+
+					it.After(func() {
+						opts.CreatedAt = time.Time{}
+					})
+
+					it("creates the image and SPDX document at the creation time", func() {
+						_, err := exporter.Export(opts)
+						h.AssertNil(t, err)
+
+						var spdx lifecycle.SPDX
+						h.AssertNil(t, json.Unmarshal([]byte(rdfile(t, filepath.Join(opts.SBOMDir, "bom.spdx.json"))), &spdx))
+						h.AssertEq(t, spdx.CreationInfo.Created, "2021-03-04T05:06:07Z")
+						h.AssertEq(t, savedImage.savedAt, opts.CreatedAt)
+					})
+
+					it("restores the default creation time of images after saving", func() {
+						_, err := exporter.Export(opts)
+						h.AssertNil(t, err)
+
+						h.AssertEq(t, imgutil.NormalizedDateTime, defaultDateTime)
+					})
+				})
+			})
+
 			it("reuses launcher layer if the sha matches the sha in the metadata", func() {
 				_, err := exporter.Export(opts)
 				h.AssertNil(t, err)
@@ -291,11 +444,12 @@ func testExporter(t *testing.T, when spec.G, it spec.S) {
 				_, err := exporter.Export(opts)
 				h.AssertNil(t, err)
 
-				// expects 4 layers
+				// expects 5 layers
 				// 1. app layer
 				// 2. config layer
-				// 3-4. buildpack layers
-				h.AssertEq(t, fakeAppImage.NumberOfAddedLayers(), 4)
+				// 3. sbom layer
+				// 4-5. buildpack layers
+				h.AssertEq(t, fakeAppImage.NumberOfAddedLayers(), 5)
 			})
 
 			it("only reuses expected layers", func() {
@@ -309,6 +463,82 @@ func testExporter(t *testing.T, when spec.G, it spec.S) {
 				h.AssertEq(t, len(fakeAppImage.ReusedLayers()), 4)
 			})
 
+			when("layers were merged in the previous image", func() {
+				it.Before(func() {
+					exporter.MaxLayers = 8
+					fakeAppImage.AddPreviousLayer("merged-1-digest", "")
+					opts.OrigMetadata.Merged = []lifecycle.MergedLayerMetadata{{
+						SHA:    "merged-1-digest",
+						Layers: []string{"other.buildpack.id:local-reusable-layer", "other.buildpack.id:new-launch-layer"},
+					}}
+					layerFactory.EXPECT().
+						MergedLayer("merged-1", []string{
+							filepath.Join(opts.LayersDir, "other.buildpack.id", "local-reusable-layer"),
+							filepath.Join(opts.LayersDir, "other.buildpack.id", "new-launch-layer"),
+						}).
+						DoAndReturn(func(id string, dirs []string) (layers.Layer, error) {
+							return createTestLayer(id, tmpDir)
+						})
+				})
+
+				it("merges the same layers and reuses the merged layer", func() {
+					_, err := exporter.Export(opts)
+					h.AssertNil(t, err)
+
+					assertLogEntry(t, logHandler, "Merging layers 'other.buildpack.id:local-reusable-layer', 'other.buildpack.id:new-launch-layer' into layer 'merged-1'")
+					assertReuseLayerLog(t, logHandler, "merged-1")
+
+					metadataJSON, err := fakeAppImage.Label("io.buildpacks.lifecycle.metadata")
+					h.AssertNil(t, err)
+					var meta lifecycle.LayersMetadata
+					h.AssertNil(t, json.Unmarshal([]byte(metadataJSON), &meta))
+					h.AssertEq(t, meta.Merged, opts.OrigMetadata.Merged)
+				})
+			})
+
+			when("a layer without contents was merged in the previous image", func() {
+				it.Before(func() {
+					fakeAppImage.AddPreviousLayer("merged-1-digest", "")
+					opts.OrigMetadata.Merged = []lifecycle.MergedLayerMetadata{{
+						SHA:    "merged-1-digest",
+						Layers: []string{"buildpack.id:launch-layer-no-local-dir", "other.buildpack.id:local-reusable-layer"},
+					}}
+				})
+
+				it("reuses the merged layer if the other layers are unchanged", func() {
+					_, err := exporter.Export(opts)
+					h.AssertNil(t, err)
+
+					h.AssertContains(t, fakeAppImage.ReusedLayers(), "merged-1-digest")
+					assertReuseLayerLog(t, logHandler, "merged-1")
+
+					metadataJSON, err := fakeAppImage.Label("io.buildpacks.lifecycle.metadata")
+					h.AssertNil(t, err)
+					var meta lifecycle.LayersMetadata
+					h.AssertNil(t, json.Unmarshal([]byte(metadataJSON), &meta))
+					h.AssertEq(t, meta.Merged, opts.OrigMetadata.Merged)
+				})
+
+				it("returns an error if another layer changed", func() {
+					opts.OrigMetadata.Buildpacks[1].Layers["local-reusable-layer"] = lifecycle.BuildpackLayerMetadata{
+						LayerMetadata: lifecycle.LayerMetadata{SHA: "old-local-reusable-layer-digest"},
+					}
+
+					_, err := exporter.Export(opts)
+					h.AssertError(t, err, "cannot reuse layer 'buildpack.id:launch-layer-no-local-dir' without contents, layers it was merged with in the previous image changed: 'other.buildpack.id:local-reusable-layer'")
+					h.AssertEq(t, len(fakeAppImage.SavedNames()), 0)
+				})
+
+				it("returns an error if another layer was deleted", func() {
+					h.AssertNil(t, os.RemoveAll(filepath.Join(opts.LayersDir, "other.buildpack.id", "local-reusable-layer")))
+					h.AssertNil(t, os.Remove(filepath.Join(opts.LayersDir, "other.buildpack.id", "local-reusable-layer.toml")))
+
+					_, err := exporter.Export(opts)
+					h.AssertError(t, err, "cannot reuse layer 'buildpack.id:launch-layer-no-local-dir' without contents, layers it was merged with in the previous image no longer exist: 'other.buildpack.id:local-reusable-layer'")
+					h.AssertEq(t, len(fakeAppImage.SavedNames()), 0)
+				})
+			})
+
 			it("saves lifecycle metadata with layer info", func() {
 				_, err := exporter.Export(opts)
 				h.AssertNil(t, err)
@@ -330,6 +560,7 @@ func testExporter(t *testing.T, when spec.G, it spec.S) {
 				h.AssertEq(t, meta.Config.SHA, "config-digest")
 				h.AssertEq(t, meta.Launcher.SHA, "launcher-digest")
 				h.AssertEq(t, meta.ProcessTypes.SHA, "process-types-digest")
+				h.AssertEq(t, meta.SBOM.SHA, "sbom-digest")
 				h.AssertEq(t, meta.Buildpacks[0].ID, "buildpack.id")
 				h.AssertEq(t, meta.Buildpacks[0].Version, "1.2.3")
 				h.AssertEq(t, meta.Buildpacks[0].Layers["launch-layer-no-local-dir"].SHA, "launch-layer-no-local-dir-digest")
@@ -372,6 +603,16 @@ func testExporter(t *testing.T, when spec.G, it spec.S) {
 				h.AssertEq(t, meta.Stack.RunImage.Mirrors, []string{"registry.example.com/some/run", "other.example.com/some/run"})
 			})
 
+			it("fails when the run image lacks mixins the buildpacks require", func() {
+				h.AssertNil(t, fakeAppImage.SetLabel(lifecycle.StackMixinsLabel, `["mixin-a", "run:mixin-c"]`))
+				opts.RunImageRef = "some/run"
+				opts.RequiredMixins = []string{"build:mixin-d", "mixin-a", "mixin-b", "run:mixin-c"}
+
+				_, err := exporter.Export(opts)
+				h.AssertError(t, err, "incompatible run image 'some/run': missing required mixin(s): mixin-b")
+				h.AssertEq(t, len(fakeAppImage.SavedNames()), 0)
+			})
+
 			when("metadata.toml is missing bom and has empty process list", func() {
 				it.Before(func() {
 					err := ioutil.WriteFile(filepath.Join(opts.LayersDir, "config", "metadata.toml"), []byte(`
@@ -424,6 +665,13 @@ version = "4.5.6"
 `
 					h.AssertJSONEq(t, expectedJSON, metadataJSON)
 				})
+
+				it("does not create SBOM layer", func() {
+					_, err := exporter.Export(opts)
+					h.AssertNil(t, err)
+
+					assertDoesNotHaveLayer(t, fakeAppImage, "sbom")
+				})
 			})
 
 			it("combines metadata.toml with launcher config to create build label", func() {
@@ -850,6 +1098,49 @@ version = "4.5.6"
 				h.AssertEq(t, fakeAppImage.NumberOfAddedLayers(), 6)
 			})
 
+			when("there is a maximum number of layers", func() {
+				it("merges launch layers to stay within it", func() {
+					exporter.MaxLayers = 5
+					layerFactory.EXPECT().
+						MergedLayer("merged-1", []string{
+							filepath.Join(opts.LayersDir, "buildpack.id", "layer1"),
+							filepath.Join(opts.LayersDir, "buildpack.id", "layer2"),
+						}).
+						DoAndReturn(func(id string, dirs []string) (layers.Layer, error) {
+							return createTestLayer(id, tmpDir)
+						})
+
+					_, err := exporter.Export(opts)
+					h.AssertNil(t, err)
+
+					assertLogEntry(t, logHandler, "Merging layers 'buildpack.id:layer1', 'buildpack.id:layer2' into layer 'merged-1'")
+					assertHasLayer(t, fakeAppImage, "merged-1")
+					assertAddLayerLog(t, logHandler, "merged-1")
+					assertDoesNotHaveLayer(t, fakeAppImage, "buildpack.id:layer1")
+					h.AssertEq(t, fakeAppImage.NumberOfAddedLayers(), 5)
+
+					metadataJSON, err := fakeAppImage.Label("io.buildpacks.lifecycle.metadata")
+					h.AssertNil(t, err)
+					var meta lifecycle.LayersMetadata
+					h.AssertNil(t, json.Unmarshal([]byte(metadataJSON), &meta))
+					h.AssertEq(t, meta.Merged, []lifecycle.MergedLayerMetadata{{
+						SHA:    "merged-1-digest",
+						Layers: []string{"buildpack.id:layer1", "buildpack.id:layer2"},
+					}})
+
+					t.Log("keeps the SHA of each merged layer")
+					h.AssertEq(t, meta.Buildpacks[0].Layers["layer1"].SHA, "layer1-digest")
+					h.AssertEq(t, meta.Buildpacks[0].Layers["layer2"].SHA, "layer2-digest")
+				})
+
+				it("returns an error when the lifecycle layers leave no room for launch layers", func() {
+					exporter.MaxLayers = 4
+
+					_, err := exporter.Export(opts)
+					h.AssertError(t, err, "cannot merge 2 buildpack layers into the 0 that fit within the maximum of 4 layers")
+				})
+			})
+
 			it("saves metadata with layer info", func() {
 				_, err := exporter.Export(opts)
 				h.AssertNil(t, err)
@@ -1112,6 +1403,153 @@ version = "4.5.6"
 				h.AssertNil(t, err)
 				h.AssertContains(t, fakeAppImage.SavedNames(), append(opts.AdditionalNames, fakeAppImage.Name())...)
 			})
+
+			when("verifying against a reference", func() {
+				var (
+					workingImage    *fakes.Image
+					referenceReport lifecycle.ExportReport
+				)
+
+				it.Before(func() {
+					exporter.LayerFactory = &layers.Factory{
+						ArtifactsDir: filepath.Join(tmpDir, "artifacts"),
+						Logger:       &log.Logger{Handler: logHandler},
+					}
+					var err error
+					referenceReport, err = exporter.Export(opts)
+					h.AssertNil(t, err)
+
+					exporter.LayerFactory = &layers.Factory{
+						ArtifactsDir: filepath.Join(tmpDir, "verify-artifacts"),
+						Logger:       &log.Logger{Handler: logHandler},
+					}
+					h.AssertNil(t, os.Mkdir(filepath.Join(tmpDir, "verify-artifacts"), 0777))
+					workingImage = fakes.NewImage("some-repo/app-image", "some-top-layer-sha", local.IDIdentifier{ImageID: "other-image-id"})
+				})
+
+				it.After(func() {
+					opts.Reference = nil
+					opts.SBOMDir = ""
+					opts.WorkingImage = fakeAppImage
+					workingImage.Cleanup()
+				})
+
+				when("the reference is an image", func() {
+					it.Before(func() {
+						var err error
+						opts.Reference, err = lifecycle.NewImageVerifyReference(fakeAppImage)
+						h.AssertNil(t, err)
+						opts.WorkingImage = workingImage
+					})
+
+					it("reports no differences when the export is identical", func() {
+						report, err := exporter.Export(opts)
+						h.AssertNil(t, err)
+
+						h.AssertEq(t, report.Verify.Reference, "some-repo/app-image")
+						h.AssertEq(t, report.Verify.Identical(), true)
+						h.AssertEq(t, workingImage.IsSaved(), false)
+						assertLogEntry(t, logHandler, "Export is identical to reference 'some-repo/app-image'")
+					})
+
+					it("does not write the SBOM directory", func() {
+						opts.SBOMDir = filepath.Join(tmpDir, "sbom")
+
+						_, err := exporter.Export(opts)
+						h.AssertNil(t, err)
+
+						h.AssertPathDoesNotExist(t, opts.SBOMDir)
+					})
+
+					it("reports the layers and files that differ", func() {
+						layerDir := filepath.Join(opts.LayersDir, "buildpack.id", "layer1")
+						h.AssertNil(t, ioutil.WriteFile(filepath.Join(layerDir, "file-from-layer-1"), []byte("changed"), 0644))
+						h.AssertNil(t, ioutil.WriteFile(filepath.Join(layerDir, "new-file"), []byte("new"), 0644))
+
+						report, err := exporter.Export(opts)
+						h.AssertNil(t, err)
+
+						h.AssertEq(t, report.Verify.Identical(), false)
+						h.AssertEq(t, len(report.Verify.Layers), 1)
+						diff := report.Verify.Layers[0]
+						h.AssertEq(t, diff.ID, "buildpack.id:layer1")
+						h.AssertEq(t, len(diff.Files), 2)
+						h.AssertStringContains(t, diff.Files[0].Path, "file-from-layer-1")
+						h.AssertEq(t, diff.Files[0].Differences, []string{lifecycle.FileContent})
+						h.AssertStringContains(t, diff.Files[1].Path, "new-file")
+						h.AssertEq(t, diff.Files[1].Differences, []string{lifecycle.FileAdded})
+						h.AssertEq(t, report.Verify.Config, []string{"label:" + lifecycle.LayerMetadataLabel})
+						assertLogEntry(t, logHandler, "Layer 'buildpack.id:layer1' differs from reference")
+					})
+				})
+
+				when("the reference is a report", func() {
+					it.Before(func() {
+						reportPath := filepath.Join(tmpDir, "report.toml")
+						h.AssertNil(t, lifecycle.WriteTOML(reportPath, &referenceReport))
+
+						var err error
+						opts.Reference, err = lifecycle.ReadVerifyReference(reportPath)
+						h.AssertNil(t, err)
+						opts.WorkingImage = workingImage
+					})
+
+					it("reports the layers that differ", func() {
+						h.AssertNil(t, ioutil.WriteFile(filepath.Join(opts.LayersDir, "buildpack.id", "layer2", "file-from-layer-2"), []byte("changed"), 0644))
+
+						report, err := exporter.Export(opts)
+						h.AssertNil(t, err)
+
+						h.AssertEq(t, len(report.Verify.Layers), 1)
+						h.AssertEq(t, report.Verify.Layers[0].ID, "buildpack.id:layer2")
+						h.AssertEq(t, len(report.Verify.Layers[0].Files), 0)
+						h.AssertEq(t, report.Verify.Config, []string{"label:" + lifecycle.LayerMetadataLabel})
+					})
+				})
+			})
+
+			when("layers are tarred concurrently", func() {
+				it.Before(func() {
+					exporter.LayerWorkers = 4
+				})
+
+				it("adds the layers in order", func() {
+					_, err := exporter.Export(opts)
+					h.AssertNil(t, err)
+
+					h.AssertEq(t, fakeAppImage.NumberOfAddedLayers(), 6)
+					var added []string
+					for _, entry := range logHandler.Entries {
+						if strings.HasPrefix(entry.Message, "Adding layer 'buildpack.id:") {
+							added = append(added, entry.Message)
+						}
+					}
+					h.AssertEq(t, added, []string{
+						"Adding layer 'buildpack.id:layer1'\n",
+						"Adding layer 'buildpack.id:layer2'\n",
+					})
+				})
+			})
+
+			when("a launch layer contains the value of a secret", func() {
+				it.Before(func() {
+					opts.Secrets = lifecycle.Secrets{
+						{Name: "OTHER_TOKEN", Value: []byte("other-secret-value")},
+						{Name: "SOME_TOKEN", Value: []byte("from layer 1")},
+					}
+				})
+
+				it.After(func() {
+					opts.Secrets = nil
+				})
+
+				it("returns an error naming the secret", func() {
+					_, err := exporter.Export(opts)
+					h.AssertError(t, err, "checking layer 'buildpack.id:layer1' for secrets")
+					h.AssertError(t, err, "file-from-layer-1' contains the value of secret 'SOME_TOKEN'")
+					h.AssertEq(t, len(fakeAppImage.SavedNames()), 0)
+				})
+			})
 		})
 
 		when("buildpack requires an escaped id", func() {
@@ -1174,6 +1612,7 @@ version = "4.5.6"
 					err,
 					"failed to parse metadata for layers '[buildpack.id:bad-layer]'",
 				)
+				h.AssertError(t, err, filepath.Join(opts.LayersDir, "buildpack.id", "bad-layer.toml"))
 			})
 		})
 
@@ -1197,6 +1636,17 @@ version = "4.5.6"
 	})
 }
 
+// savedAtImage records imgutil.NormalizedDateTime when it is saved, which local and remote images use as their creation time.
+type savedAtImage struct {
+	*fakes.Image
+	savedAt time.Time
+}
+
+func (i *savedAtImage) Save(additionalNames ...string) error {
+	i.savedAt = imgutil.NormalizedDateTime
+	return i.Image.Save(additionalNames...)
+}
+
 func createTestLayer(id string, tmpDir string) (layers.Layer, error) {
 	tarPath := filepath.Join(tmpDir, "artifacts", strings.Replace(id, "/", "_", -1))
 	f, err := os.Create(tarPath)
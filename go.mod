@@ -2,6 +2,7 @@ module github.com/buildpacks/lifecycle
 
 require (
 	github.com/BurntSushi/toml v0.3.1
+	github.com/Masterminds/semver/v3 v3.1.1
 	github.com/Microsoft/go-winio v0.4.15-0.20190919025122-fc70bd9a86b5 // indirect
 	github.com/apex/log v1.9.0
 	github.com/buildpacks/imgutil v0.0.0-20200831154319-afd98bd2f655
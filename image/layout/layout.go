@@ -0,0 +1,607 @@
+// Package layout provides an imgutil.Image that is read from and saved to an OCI image layout directory.
+// Every image in the layout is listed in its index.json with its name as the
+// org.opencontainers.image.ref.name annotation, so that one layout can hold the run image,
+// the previous image and the app image.
+package layout
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/buildpacks/imgutil"
+	"github.com/buildpacks/imgutil/remote"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	ocilayout "github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/pkg/errors"
+)
+
+const RefNameAnnotation = "org.opencontainers.image.ref.name"
+
+type Image struct {
+	path       string
+	repoName   string
+	image      v1.Image
+	prevLayers []v1.Layer
+	createdAt  time.Time
+}
+
+type ImageOption func(*Image) (*Image, error)
+
+// WithPreviousImage makes the layers of the image with the name in the layout available to ReuseLayer.
+func WithPreviousImage(imageName string) ImageOption {
+	return func(i *Image) (*Image, error) {
+		prevImage, err := readImage(i.path, imageName)
+		if err != nil {
+			return nil, err
+		}
+		if prevImage == nil {
+			return i, nil
+		}
+		i.prevLayers, err = prevImage.Layers()
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to get layers for previous image with repo name '%s'", imageName)
+		}
+		return i, nil
+	}
+}
+
+// FromBaseImage starts the image from the image with the name in the layout, if there is one.
+func FromBaseImage(imageName string) ImageOption {
+	return func(i *Image) (*Image, error) {
+		base, err := readImage(i.path, imageName)
+		if err != nil {
+			return nil, err
+		}
+		if base != nil {
+			i.image = base
+		}
+		return i, nil
+	}
+}
+
+// NewImage returns an image named repoName in the layout at path. The layout is created when the image is saved
+// if it does not exist.
+func NewImage(repoName, path string, ops ...ImageOption) (imgutil.Image, error) {
+	image, err := emptyImage()
+	if err != nil {
+		return nil, err
+	}
+
+	li := &Image{
+		path:     path,
+		repoName: repoName,
+		image:    image,
+	}
+
+	for _, op := range ops {
+		li, err = op(li)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return li, nil
+}
+
+func emptyImage() (v1.Image, error) {
+	cfg := &v1.ConfigFile{
+		OS:           "linux",
+		Architecture: "amd64",
+		RootFS: v1.RootFS{
+			Type:    "layers",
+			DiffIDs: []v1.Hash{},
+		},
+	}
+	return mutate.ConfigFile(empty.Image, cfg)
+}
+
+// refName normalizes an image name, so that e.g. "some/app" matches "index.docker.io/some/app:latest".
+func refName(imageName string) (string, error) {
+	ref, err := name.ParseReference(imageName, name.WeakValidation)
+	if err != nil {
+		return "", err
+	}
+	return ref.Name(), nil
+}
+
+// readIndex returns the index of the layout at path, or nil if there is no layout.
+func readIndex(path string) (*v1.IndexManifest, v1.ImageIndex, error) {
+	index, err := ocilayout.ImageIndexFromPath(path)
+	if os.IsNotExist(errors.Cause(err)) {
+		return nil, nil, nil
+	} else if err != nil {
+		return nil, nil, errors.Wrapf(err, "read layout '%s'", path)
+	}
+	manifest, err := index.IndexManifest()
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "read index of layout '%s'", path)
+	}
+	return manifest, index, nil
+}
+
+// findDescriptor returns the descriptor of the last image with the name in the index, or nil if there is none.
+// Names with a digest, such as the identifiers of layout images, match the image with that digest.
+func findDescriptor(manifest *v1.IndexManifest, imageName string) (*v1.Descriptor, error) {
+	if digest, err := name.NewDigest(imageName, name.WeakValidation); err == nil {
+		for i, desc := range manifest.Manifests {
+			if desc.Digest.String() == digest.DigestStr() {
+				return &manifest.Manifests[i], nil
+			}
+		}
+		return nil, nil
+	}
+	want, err := refName(imageName)
+	if err != nil {
+		return nil, err
+	}
+	var found *v1.Descriptor
+	for i, desc := range manifest.Manifests {
+		got, err := refName(desc.Annotations[RefNameAnnotation])
+		if err != nil {
+			continue
+		}
+		if got == want {
+			found = &manifest.Manifests[i]
+		}
+	}
+	return found, nil
+}
+
+// readImage returns the image with the name in the layout at path, or nil if there is none.
+func readImage(path, imageName string) (v1.Image, error) {
+	manifest, index, err := readIndex(path)
+	if err != nil || manifest == nil {
+		return nil, err
+	}
+	desc, err := findDescriptor(manifest, imageName)
+	if err != nil || desc == nil {
+		return nil, err
+	}
+	image, err := index.Image(desc.Digest)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read image '%s' from layout '%s'", imageName, path)
+	}
+	return image, nil
+}
+
+func (i *Image) Label(key string) (string, error) {
+	cfg, err := i.image.ConfigFile()
+	if err != nil || cfg == nil {
+		return "", fmt.Errorf("failed to get config file for image '%s'", i.repoName)
+	}
+	labels := cfg.Config.Labels
+	return labels[key], nil
+}
+
+func (i *Image) Labels() (map[string]string, error) {
+	cfg, err := i.image.ConfigFile()
+	if err != nil || cfg == nil {
+		return nil, fmt.Errorf("failed to get config file for image '%s'", i.repoName)
+	}
+	return cfg.Config.Labels, nil
+}
+
+func (i *Image) Env(key string) (string, error) {
+	cfg, err := i.image.ConfigFile()
+	if err != nil || cfg == nil {
+		return "", fmt.Errorf("failed to get config file for image '%s'", i.repoName)
+	}
+	for _, envVar := range cfg.Config.Env {
+		parts := strings.SplitN(envVar, "=", 2)
+		if parts[0] == key && len(parts) == 2 {
+			return parts[1], nil
+		}
+	}
+	return "", nil
+}
+
+func (i *Image) OS() (string, error) {
+	cfg, err := i.image.ConfigFile()
+	if err != nil || cfg == nil || cfg.OS == "" {
+		return "", fmt.Errorf("failed to get OS from config file for image '%s'", i.repoName)
+	}
+	return cfg.OS, nil
+}
+
+func (i *Image) OSVersion() (string, error) {
+	cfg, err := i.image.ConfigFile()
+	if err != nil || cfg == nil {
+		return "", fmt.Errorf("failed to get OSVersion from config file for image '%s'", i.repoName)
+	}
+	return cfg.OSVersion, nil
+}
+
+func (i *Image) Architecture() (string, error) {
+	cfg, err := i.image.ConfigFile()
+	if err != nil || cfg == nil || cfg.Architecture == "" {
+		return "", fmt.Errorf("failed to get Architecture from config file for image '%s'", i.repoName)
+	}
+	return cfg.Architecture, nil
+}
+
+func (i *Image) Rename(name string) {
+	i.repoName = name
+}
+
+func (i *Image) Name() string {
+	return i.repoName
+}
+
+func (i *Image) Found() bool {
+	image, err := readImage(i.path, i.repoName)
+	return err == nil && image != nil
+}
+
+// Identifier returns the digest of the image, qualified with its repository like the digest of a remote image.
+func (i *Image) Identifier() (imgutil.Identifier, error) {
+	ref, err := name.ParseReference(i.repoName, name.WeakValidation)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse reference for image '%s': %s", i.repoName, err)
+	}
+
+	hash, err := i.image.Digest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get digest for image '%s': %s", i.repoName, err)
+	}
+
+	digestRef, err := name.NewDigest(fmt.Sprintf("%s@%s", ref.Context().Name(), hash.String()), name.WeakValidation)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating digest reference")
+	}
+
+	return remote.DigestIdentifier{
+		Digest: digestRef,
+	}, nil
+}
+
+func (i *Image) CreatedAt() (time.Time, error) {
+	configFile, err := i.image.ConfigFile()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get createdAt time for image '%s': %s", i.repoName, err)
+	}
+	return configFile.Created.UTC(), nil
+}
+
+// Rebase replaces the layers of the image up to and including baseTopLayer with the layers of newBase.
+func (i *Image) Rebase(baseTopLayer string, newBase imgutil.Image) error {
+	newBaseLayout, ok := newBase.(*Image)
+	if !ok {
+		return errors.New("expected new base to be a layout image")
+	}
+
+	origLayers, err := i.image.Layers()
+	if err != nil {
+		return err
+	}
+	top := -1
+	for idx, layer := range origLayers {
+		diffID, err := layer.DiffID()
+		if err != nil {
+			return err
+		}
+		if diffID.String() == baseTopLayer {
+			top = idx
+			break
+		}
+	}
+	if top < 0 {
+		return errors.New("could not find base layer in image")
+	}
+	newBaseLayers, err := newBaseLayout.image.Layers()
+	if err != nil {
+		return err
+	}
+
+	origConfig, err := i.image.ConfigFile()
+	if err != nil {
+		return err
+	}
+	newBaseConfig, err := newBaseLayout.image.ConfigFile()
+	if err != nil {
+		return err
+	}
+	newImageConfig := origConfig.DeepCopy()
+	newImageConfig.Architecture = newBaseConfig.Architecture
+	newImageConfig.OS = newBaseConfig.OS
+	newImageConfig.OSVersion = newBaseConfig.OSVersion
+	newImageConfig.RootFS.DiffIDs = []v1.Hash{}
+	newImageConfig.History = nil
+
+	newImage, err := mutate.ConfigFile(empty.Image, newImageConfig)
+	if err != nil {
+		return err
+	}
+	newImage, err = mutate.AppendLayers(newImage, append(newBaseLayers, origLayers[top+1:]...)...)
+	if err != nil {
+		return errors.Wrap(err, "rebase")
+	}
+	i.image = newImage
+	return nil
+}
+
+func (i *Image) SetLabel(key, val string) error {
+	configFile, err := i.image.ConfigFile()
+	if err != nil {
+		return err
+	}
+	config := *configFile.Config.DeepCopy()
+	if config.Labels == nil {
+		config.Labels = map[string]string{}
+	}
+	config.Labels[key] = val
+	i.image, err = mutate.Config(i.image, config)
+	return err
+}
+
+func (i *Image) RemoveLabel(key string) error {
+	cfg, err := i.image.ConfigFile()
+	if err != nil || cfg == nil {
+		return fmt.Errorf("failed to get config file for image '%s'", i.repoName)
+	}
+	config := *cfg.Config.DeepCopy()
+	delete(config.Labels, key)
+	i.image, err = mutate.Config(i.image, config)
+	return err
+}
+
+func (i *Image) SetEnv(key, val string) error {
+	configFile, err := i.image.ConfigFile()
+	if err != nil {
+		return err
+	}
+	config := *configFile.Config.DeepCopy()
+	ignoreCase := configFile.OS == "windows"
+	for idx, e := range config.Env {
+		parts := strings.Split(e, "=")
+		foundKey := parts[0]
+		searchKey := key
+		if ignoreCase {
+			foundKey = strings.ToUpper(foundKey)
+			searchKey = strings.ToUpper(searchKey)
+		}
+		if foundKey == searchKey {
+			config.Env[idx] = fmt.Sprintf("%s=%s", key, val)
+			i.image, err = mutate.Config(i.image, config)
+			return err
+		}
+	}
+	config.Env = append(config.Env, fmt.Sprintf("%s=%s", key, val))
+	i.image, err = mutate.Config(i.image, config)
+	return err
+}
+
+func (i *Image) SetWorkingDir(dir string) error {
+	configFile, err := i.image.ConfigFile()
+	if err != nil {
+		return err
+	}
+	config := *configFile.Config.DeepCopy()
+	config.WorkingDir = dir
+	i.image, err = mutate.Config(i.image, config)
+	return err
+}
+
+func (i *Image) SetEntrypoint(ep ...string) error {
+	configFile, err := i.image.ConfigFile()
+	if err != nil {
+		return err
+	}
+	config := *configFile.Config.DeepCopy()
+	config.Entrypoint = ep
+	i.image, err = mutate.Config(i.image, config)
+	return err
+}
+
+func (i *Image) SetCmd(cmd ...string) error {
+	configFile, err := i.image.ConfigFile()
+	if err != nil {
+		return err
+	}
+	config := *configFile.Config.DeepCopy()
+	config.Cmd = cmd
+	i.image, err = mutate.Config(i.image, config)
+	return err
+}
+
+func (i *Image) TopLayer() (string, error) {
+	all, err := i.image.Layers()
+	if err != nil {
+		return "", err
+	}
+	if len(all) == 0 {
+		return "", fmt.Errorf("image %s has no layers", i.Name())
+	}
+	topLayer := all[len(all)-1]
+	hex, err := topLayer.DiffID()
+	if err != nil {
+		return "", err
+	}
+	return hex.String(), nil
+}
+
+func (i *Image) GetLayer(sha string) (io.ReadCloser, error) {
+	layers, err := i.image.Layers()
+	if err != nil {
+		return nil, err
+	}
+
+	layer, err := findLayerWithSha(layers, sha)
+	if err != nil {
+		return nil, err
+	}
+
+	return layer.Uncompressed()
+}
+
+func (i *Image) AddLayer(path string) error {
+	layer, err := tarball.LayerFromFile(path)
+	if err != nil {
+		return err
+	}
+	i.image, err = mutate.AppendLayers(i.image, layer)
+	if err != nil {
+		return errors.Wrap(err, "add layer")
+	}
+	return nil
+}
+
+func (i *Image) AddLayerWithDiffID(path, diffID string) error {
+	return i.AddLayer(path)
+}
+
+func (i *Image) ReuseLayer(sha string) error {
+	layer, err := findLayerWithSha(i.prevLayers, sha)
+	if err != nil {
+		return err
+	}
+	i.image, err = mutate.AppendLayers(i.image, layer)
+	return err
+}
+
+func findLayerWithSha(layers []v1.Layer, diffID string) (v1.Layer, error) {
+	for _, layer := range layers {
+		dID, err := layer.DiffID()
+		if err != nil {
+			return nil, errors.Wrap(err, "get diff ID for previous image layer")
+		}
+		if diffID == dID.String() {
+			return layer, nil
+		}
+	}
+	return nil, fmt.Errorf(`previous image did not have layer with diff id '%s'`, diffID)
+}
+
+// SetCreatedAt sets the creation time of the image config and its history when the image is saved,
+// instead of imgutil.NormalizedDateTime.
+func (i *Image) SetCreatedAt(createdAt time.Time) {
+	i.createdAt = createdAt
+}
+
+// Save writes the image to the layout under `Name()` and the additional names, replacing any images
+// previously saved under those names. The history and creation time are normalized as for remote images.
+func (i *Image) Save(additionalNames ...string) error {
+	var err error
+
+	allNames := append([]string{i.repoName}, additionalNames...)
+
+	createdAt := i.createdAt
+	if createdAt.IsZero() {
+		createdAt = imgutil.NormalizedDateTime
+	}
+	i.image, err = mutate.CreatedAt(i.image, v1.Time{Time: createdAt})
+	if err != nil {
+		return errors.Wrap(err, "set creation time")
+	}
+
+	cfg, err := i.image.ConfigFile()
+	if err != nil {
+		return errors.Wrap(err, "get image config")
+	}
+	cfg = cfg.DeepCopy()
+
+	layers, err := i.image.Layers()
+	if err != nil {
+		return errors.Wrap(err, "get image layers")
+	}
+	cfg.History = make([]v1.History, len(layers))
+	for i := range cfg.History {
+		cfg.History[i] = v1.History{
+			Created: v1.Time{Time: createdAt},
+		}
+	}
+
+	cfg.DockerVersion = ""
+	cfg.Container = ""
+	i.image, err = mutate.ConfigFile(i.image, cfg)
+	if err != nil {
+		return errors.Wrap(err, "zeroing history")
+	}
+
+	path, err := i.layoutPath()
+	if err != nil {
+		return err
+	}
+	var diagnostics []imgutil.SaveDiagnostic
+	for _, n := range allNames {
+		if err := i.doSave(path, n); err != nil {
+			diagnostics = append(diagnostics, imgutil.SaveDiagnostic{ImageName: n, Cause: err})
+		}
+	}
+	if len(diagnostics) > 0 {
+		return imgutil.SaveError{Errors: diagnostics}
+	}
+
+	return nil
+}
+
+// layoutPath returns the layout the image is saved to, creating it if it does not exist.
+func (i *Image) layoutPath() (ocilayout.Path, error) {
+	manifest, _, err := readIndex(i.path)
+	if err != nil {
+		return "", err
+	}
+	if manifest != nil {
+		return ocilayout.Path(i.path), nil
+	}
+	path, err := ocilayout.Write(i.path, empty.Index)
+	if err != nil {
+		return "", errors.Wrapf(err, "create layout '%s'", i.path)
+	}
+	return path, nil
+}
+
+func (i *Image) doSave(path ocilayout.Path, imageName string) error {
+	if _, err := refName(imageName); err != nil {
+		return err
+	}
+	if err := path.AppendImage(i.image, ocilayout.WithAnnotations(map[string]string{RefNameAnnotation: imageName})); err != nil {
+		return err
+	}
+	return i.removeFromIndex(imageName, true)
+}
+
+// removeFromIndex removes the images with the name from the index of the layout.
+// If keepLast is set, the image that was saved last under the name is kept.
+func (i *Image) removeFromIndex(imageName string, keepLast bool) error {
+	manifest, _, err := readIndex(i.path)
+	if err != nil || manifest == nil {
+		return err
+	}
+	last, err := findDescriptor(manifest, imageName)
+	if err != nil {
+		return err
+	}
+	want, err := refName(imageName)
+	if err != nil {
+		return err
+	}
+	kept := []v1.Descriptor{}
+	for idx, desc := range manifest.Manifests {
+		if got, err := refName(desc.Annotations[RefNameAnnotation]); err == nil && got == want {
+			if !keepLast || &manifest.Manifests[idx] != last {
+				continue
+			}
+		}
+		kept = append(kept, desc)
+	}
+	manifest.Manifests = kept
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	return ocilayout.Path(i.path).WriteFile("index.json", data, os.ModePerm)
+}
+
+// Delete removes the image from the index of the layout. Its blobs are left in place,
+// as they may be shared with other images in the layout.
+func (i *Image) Delete() error {
+	return i.removeFromIndex(i.repoName, false)
+}
@@ -0,0 +1,235 @@
+package layout_test
+
+import (
+	"archive/tar"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/buildpacks/imgutil"
+	"github.com/buildpacks/imgutil/remote"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	ocilayout "github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+
+	"github.com/buildpacks/lifecycle/image/layout"
+	h "github.com/buildpacks/lifecycle/testhelpers"
+)
+
+func TestLayout(t *testing.T) {
+	spec.Run(t, "Layout", testLayout, spec.Report(report.Terminal{}))
+}
+
+func testLayout(t *testing.T, when spec.G, it spec.S) {
+	var (
+		tmpDir    string
+		layoutDir string
+	)
+
+	it.Before(func() {
+		var err error
+		tmpDir, err = ioutil.TempDir("", "lifecycle.layout")
+		h.AssertNil(t, err)
+		layoutDir = filepath.Join(tmpDir, "layout")
+	})
+
+	it.After(func() {
+		os.RemoveAll(tmpDir)
+	})
+
+	layerTar := func(name, contents string) string {
+		path := filepath.Join(tmpDir, name+".tar")
+		f, err := os.Create(path)
+		h.AssertNil(t, err)
+		defer f.Close()
+		tw := tar.NewWriter(f)
+		h.AssertNil(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(contents))}))
+		_, err = tw.Write([]byte(contents))
+		h.AssertNil(t, err)
+		h.AssertNil(t, tw.Close())
+		return path
+	}
+
+	saveImage := func(repoName string, labels map[string]string, layerNames ...string) imgutil.Image {
+		img, err := layout.NewImage(repoName, layoutDir)
+		h.AssertNil(t, err)
+		for k, v := range labels {
+			h.AssertNil(t, img.SetLabel(k, v))
+		}
+		for _, n := range layerNames {
+			h.AssertNil(t, img.AddLayer(layerTar(n, n+"-contents")))
+		}
+		h.AssertNil(t, img.Save())
+		return img
+	}
+
+	indexManifest := func() *v1.IndexManifest {
+		index, err := ocilayout.ImageIndexFromPath(layoutDir)
+		h.AssertNil(t, err)
+		manifest, err := index.IndexManifest()
+		h.AssertNil(t, err)
+		return manifest
+	}
+
+	when("#Save", func() {
+		it("writes an OCI image layout with the image under each name", func() {
+			img, err := layout.NewImage("some/app:latest", layoutDir)
+			h.AssertNil(t, err)
+			h.AssertNil(t, img.SetLabel("some.label", "some-value"))
+			h.AssertNil(t, img.Save("some/app:other"))
+
+			h.AssertPathExists(t, filepath.Join(layoutDir, "oci-layout"))
+			manifest := indexManifest()
+			h.AssertEq(t, len(manifest.Manifests), 2)
+			h.AssertEq(t, manifest.Manifests[0].Annotations[layout.RefNameAnnotation], "some/app:latest")
+			h.AssertEq(t, manifest.Manifests[1].Annotations[layout.RefNameAnnotation], "some/app:other")
+
+			reread, err := layout.NewImage("some/app:other", layoutDir, layout.FromBaseImage("some/app:other"))
+			h.AssertNil(t, err)
+			h.AssertEq(t, reread.Found(), true)
+			label, err := reread.Label("some.label")
+			h.AssertNil(t, err)
+			h.AssertEq(t, label, "some-value")
+		})
+
+		it("replaces an image previously saved under the same name", func() {
+			saveImage("some/app", map[string]string{"some.label": "old"})
+			saveImage("index.docker.io/some/app:latest", map[string]string{"some.label": "new"})
+			saveImage("some/other-app", nil)
+
+			h.AssertEq(t, len(indexManifest().Manifests), 2)
+			img, err := layout.NewImage("some/app", layoutDir, layout.FromBaseImage("some/app"))
+			h.AssertNil(t, err)
+			label, err := img.Label("some.label")
+			h.AssertNil(t, err)
+			h.AssertEq(t, label, "new")
+		})
+	})
+
+	when("#Identifier", func() {
+		it("returns the digest of the saved image", func() {
+			img := saveImage("some/app", nil, "some-layer")
+
+			id, err := img.Identifier()
+			h.AssertNil(t, err)
+			digestID, ok := id.(remote.DigestIdentifier)
+			if !ok {
+				t.Fatalf("Expected a digest identifier, got %T", id)
+			}
+			h.AssertEq(t, digestID.Digest.DigestStr(), indexManifest().Manifests[0].Digest.String())
+		})
+	})
+
+	when("#Found", func() {
+		it("returns false when the layout does not exist or lacks the image", func() {
+			img, err := layout.NewImage("some/app", layoutDir)
+			h.AssertNil(t, err)
+			h.AssertEq(t, img.Found(), false)
+
+			saveImage("some/other-app", nil)
+			h.AssertEq(t, img.Found(), false)
+		})
+	})
+
+	when("#ReuseLayer", func() {
+		it("reuses layers of the previous image in the layout", func() {
+			prev := saveImage("some/app", nil, "some-layer", "other-layer")
+			topLayer, err := prev.TopLayer()
+			h.AssertNil(t, err)
+
+			img, err := layout.NewImage("some/app", layoutDir, layout.WithPreviousImage("some/app"))
+			h.AssertNil(t, err)
+			h.AssertNil(t, img.ReuseLayer(topLayer))
+			h.AssertNil(t, img.Save())
+
+			reread, err := layout.NewImage("some/app", layoutDir, layout.FromBaseImage("some/app"))
+			h.AssertNil(t, err)
+			rc, err := reread.GetLayer(topLayer)
+			h.AssertNil(t, err)
+			defer rc.Close()
+			tr := tar.NewReader(rc)
+			_, err = tr.Next()
+			h.AssertNil(t, err)
+			contents, err := ioutil.ReadAll(tr)
+			h.AssertNil(t, err)
+			h.AssertEq(t, string(contents), "other-layer-contents")
+		})
+
+		it("finds the previous image by its identifier", func() {
+			prev := saveImage("some/app", nil, "some-layer")
+			id, err := prev.Identifier()
+			h.AssertNil(t, err)
+			topLayer, err := prev.TopLayer()
+			h.AssertNil(t, err)
+
+			img, err := layout.NewImage("some/app", layoutDir, layout.WithPreviousImage(id.String()))
+			h.AssertNil(t, err)
+			h.AssertNil(t, img.ReuseLayer(topLayer))
+		})
+
+		it("fails when the previous image lacks the layer", func() {
+			img, err := layout.NewImage("some/app", layoutDir, layout.WithPreviousImage("some/app"))
+			h.AssertNil(t, err)
+			h.AssertError(t, img.ReuseLayer("sha256:missing"), "previous image did not have layer with diff id 'sha256:missing'")
+		})
+	})
+
+	when("#Rebase", func() {
+		it("replaces the layers of the old base with the new base", func() {
+			oldBase := saveImage("some/run:old", nil, "old-base-layer")
+			oldTop, err := oldBase.TopLayer()
+			h.AssertNil(t, err)
+			newBase := saveImage("some/run:new", nil, "new-base-layer")
+			newTop, err := newBase.TopLayer()
+			h.AssertNil(t, err)
+
+			app, err := layout.NewImage("some/app", layoutDir, layout.FromBaseImage("some/run:old"))
+			h.AssertNil(t, err)
+			h.AssertNil(t, app.AddLayer(layerTar("app-layer", "app-layer-contents")))
+			appTop, err := app.TopLayer()
+			h.AssertNil(t, err)
+
+			h.AssertNil(t, app.Rebase(oldTop, newBase))
+
+			_, err = app.GetLayer(oldTop)
+			h.AssertNotNil(t, err)
+			_, err = app.GetLayer(newTop)
+			h.AssertNil(t, err)
+			top, err := app.TopLayer()
+			h.AssertNil(t, err)
+			h.AssertEq(t, top, appTop)
+		})
+	})
+
+	when("#SetCreatedAt", func() {
+		it("saves the image config and history at the creation time", func() {
+			createdAt := time.Date(2021, time.March, 4, 5, 6, 7, 0, time.UTC)
+			img, err := layout.NewImage("some/app", layoutDir)
+			h.AssertNil(t, err)
+			h.AssertNil(t, img.AddLayer(layerTar("some-layer", "some-contents")))
+			img.(*layout.Image).SetCreatedAt(createdAt)
+			h.AssertNil(t, img.Save())
+
+			saved, err := layout.NewImage("some/app", layoutDir, layout.FromBaseImage("some/app"))
+			h.AssertNil(t, err)
+			savedAt, err := saved.CreatedAt()
+			h.AssertNil(t, err)
+			h.AssertEq(t, savedAt, createdAt)
+		})
+	})
+
+	when("#Delete", func() {
+		it("removes the image from the index", func() {
+			img := saveImage("some/app", nil)
+			saveImage("some/other-app", nil)
+
+			h.AssertNil(t, img.Delete())
+
+			h.AssertEq(t, img.Found(), false)
+			h.AssertEq(t, len(indexManifest().Manifests), 1)
+		})
+	})
+}
@@ -54,6 +54,7 @@ func readBuildpackLayersDir(layersDir string, buildpack Buildpack) (bpLayersDir,
 			var bpStore BuildpackStore
 			_, err := toml.DecodeFile(tf, &bpStore)
 			if err != nil {
+				err = tomlValidator{}.typeError(tf, storeTOMLSchema, err)
 				return bpLayersDir{}, errors.Wrapf(err, "failed decoding store.toml for buildpack %q", buildpack.ID)
 			}
 			bpDir.store = &bpStore
@@ -118,7 +119,7 @@ func (bp *bpLayer) read() (BuildpackLayerMetadata, error) {
 	}
 	defer fh.Close()
 	if _, err := toml.DecodeFile(tomlPath, &data); err != nil {
-		return BuildpackLayerMetadata{}, err
+		return BuildpackLayerMetadata{}, tomlValidator{}.typeError(tomlPath, layerTOMLSchema, err)
 	}
 	sha, err := ioutil.ReadFile(bp.path + ".sha")
 	if err != nil {
@@ -27,3 +27,42 @@ func (f *Factory) DirLayer(id string, dir string) (layer Layer, err error) {
 		return archive.AddDirToArchive(tw, dir)
 	})
 }
+
+// MergedLayer creates a single layer from the given directories.
+// MergedLayer will set the UID and GID of entries describing each dir and its children (but not their parents)
+//    to Factory.UID and Factory.GID, and adds the parents shared by several directories only once.
+func (f *Factory) MergedLayer(id string, dirs []string) (layer Layer, err error) {
+	var absDirs []string
+	var allParents []archive.PathInfo
+	seen := map[string]bool{}
+	for _, dir := range dirs {
+		dir, err = filepath.Abs(dir)
+		if err != nil {
+			return Layer{}, err
+		}
+		absDirs = append(absDirs, dir)
+		parents, err := parents(dir)
+		if err != nil {
+			return Layer{}, err
+		}
+		for _, parent := range parents {
+			if !seen[parent.Path] {
+				seen[parent.Path] = true
+				allParents = append(allParents, parent)
+			}
+		}
+	}
+	return f.writeLayer(id, func(tw *archive.NormalizingTarWriter) error {
+		if err := archive.AddFilesToArchive(tw, allParents); err != nil {
+			return err
+		}
+		tw.WithUID(f.UID)
+		tw.WithGID(f.GID)
+		for _, dir := range absDirs {
+			if err := archive.AddDirToArchive(tw, dir); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
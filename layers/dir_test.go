@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
@@ -141,6 +142,99 @@ func testDirs(t *testing.T, when spec.G, it spec.S) {
 				fmt.Sprintf("Reusing tarball for layer \"some-layer-id\" with SHA: %s\n", dirLayer.Digest),
 			)
 		})
+
+		it("sets the modification time of entries to ModTime", func() {
+			factory.ModTime = time.Date(2021, time.March, 4, 5, 6, 7, 0, time.UTC)
+			layer, err := factory.DirLayer("other-layer-id", dir)
+			h.AssertNil(t, err)
+			if layer.Digest == dirLayer.Digest {
+				t.Fatalf("expected digest to change with the modification time, got %s", layer.Digest)
+			}
+
+			f, err := os.Open(layer.TarPath)
+			h.AssertNil(t, err)
+			defer f.Close()
+			tr := tar.NewReader(f)
+			for {
+				header, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+				h.AssertNil(t, err)
+				h.AssertEq(t, header.ModTime.UTC(), factory.ModTime)
+			}
+		})
+
+		it("creates layers concurrently", func() {
+			var wg sync.WaitGroup
+			concurrentLayers := make([]layers.Layer, 4)
+			errs := make([]error, len(concurrentLayers))
+			for i := range concurrentLayers {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					concurrentLayers[i], errs[i] = factory.DirLayer(fmt.Sprintf("other-layer-id-%d", i), dir)
+				}(i)
+			}
+			wg.Wait()
+
+			for i, layer := range concurrentLayers {
+				h.AssertNil(t, errs[i])
+				h.AssertEq(t, layer.Digest, dirLayer.Digest)
+				reusedLayer, err := factory.DirLayer(layer.ID, dir)
+				h.AssertNil(t, err)
+				h.AssertEq(t, reusedLayer, layer)
+			}
+		})
+	})
+
+	when("#MergedLayer", func() {
+		it("creates a layer from the directories with their shared parents added once", func() {
+			otherDir := filepath.Join(dir, "other-dir")
+			someDir := filepath.Join(dir, "some-dir")
+			mergedLayer, err := factory.MergedLayer("some-layer-id", []string{otherDir, someDir})
+			h.AssertNil(t, err)
+
+			h.AssertEq(t, mergedLayer.ID, "some-layer-id")
+			assertTarEntries(t, mergedLayer.TarPath, append(parents(t, otherDir), []*tar.Header{
+				{
+					Name:     tarPath(otherDir),
+					Uid:      factory.UID,
+					Gid:      factory.GID,
+					Typeflag: tar.TypeDir,
+				},
+				{
+					Name:     tarPath(filepath.Join(otherDir, "other-file.md")),
+					Uid:      factory.UID,
+					Gid:      factory.GID,
+					Typeflag: tar.TypeReg,
+				},
+				{
+					Name:     tarPath(filepath.Join(otherDir, "other-file.txt")),
+					Uid:      factory.UID,
+					Gid:      factory.GID,
+					Typeflag: tar.TypeReg,
+				},
+				{
+					Name:     tarPath(someDir),
+					Uid:      factory.UID,
+					Gid:      factory.GID,
+					Typeflag: tar.TypeDir,
+				},
+				{
+					Name:     tarPath(filepath.Join(someDir, "file.md")),
+					Uid:      factory.UID,
+					Gid:      factory.GID,
+					Typeflag: tar.TypeReg,
+				},
+				{
+					Name:     tarPath(filepath.Join(someDir, "some-file.txt")),
+					Uid:      factory.UID,
+					Gid:      factory.GID,
+					Typeflag: tar.TypeReg,
+				},
+			}...))
+		})
 	})
 }
 
@@ -0,0 +1,58 @@
+package layers
+
+import (
+	"archive/tar"
+	"path/filepath"
+	"runtime"
+	"sort"
+
+	"github.com/buildpacks/lifecycle/archive"
+)
+
+// SBOMLayer creates a layer containing the SBOM documents, by file name, in dir.
+// SBOMLayer does not read or write dir, only its parents must exist. It will set the UID and GID of entries
+// describing dir and the documents to Factory.UID and Factory.GID.
+func (f *Factory) SBOMLayer(dir string, docs map[string][]byte) (layer Layer, err error) {
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return Layer{}, err
+	}
+	parents, err := parents(dir)
+	if err != nil {
+		return Layer{}, err
+	}
+	var names []string
+	for name := range docs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	dirMode, fileMode := int64(0755), int64(0644)
+	if runtime.GOOS == "windows" {
+		dirMode, fileMode = 0777, 0777
+	}
+	return f.writeLayer("sbom", func(tw *archive.NormalizingTarWriter) error {
+		if err := archive.AddFilesToArchive(tw, parents); err != nil {
+			return err
+		}
+		tw.WithUID(f.UID)
+		tw.WithGID(f.GID)
+		if err := tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: dir, Mode: dirMode}); err != nil {
+			return err
+		}
+		for _, name := range names {
+			hdr := &tar.Header{
+				Typeflag: tar.TypeReg,
+				Name:     filepath.Join(dir, name),
+				Mode:     fileMode,
+				Size:     int64(len(docs[name])),
+			}
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+			if _, err := tw.Write(docs[name]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
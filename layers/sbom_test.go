@@ -0,0 +1,71 @@
+package layers_test
+
+import (
+	"archive/tar"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/apex/log/handlers/memory"
+	"github.com/sclevine/spec"
+	"github.com/sclevine/spec/report"
+
+	"github.com/buildpacks/lifecycle/layers"
+	h "github.com/buildpacks/lifecycle/testhelpers"
+)
+
+func TestSBOMLayer(t *testing.T) {
+	spec.Run(t, "Factory", testSBOMLayer, spec.Parallel(), spec.Report(report.Terminal{}))
+}
+
+func testSBOMLayer(t *testing.T, when spec.G, it spec.S) {
+	var (
+		factory   *layers.Factory
+		layersDir string
+	)
+	it.Before(func() {
+		artifactDir, err := ioutil.TempDir("", "layers.sbom.layer")
+		h.AssertNil(t, err)
+		layersDir, err = ioutil.TempDir("", "layers")
+		h.AssertNil(t, err)
+		factory = &layers.Factory{
+			ArtifactsDir: artifactDir,
+			Logger:       &log.Logger{Handler: memory.New()},
+			UID:          1234,
+			GID:          4321,
+		}
+	})
+
+	it.After(func() {
+		h.AssertNil(t, os.RemoveAll(factory.ArtifactsDir))
+		h.AssertNil(t, os.RemoveAll(layersDir))
+	})
+
+	when("#SBOMLayer", func() {
+		it("creates a layer with the documents in the directory without writing them to it", func() {
+			sbomDir := filepath.Join(layersDir, "sbom")
+			sbomLayer, err := factory.SBOMLayer(sbomDir, map[string][]byte{
+				"b.json": []byte("b-content"),
+				"a.json": []byte("a-content"),
+			})
+			h.AssertNil(t, err)
+			h.AssertEq(t, sbomLayer.ID, "sbom")
+			dirMode, fileMode := int64(0755), int64(0644)
+			if runtime.GOOS == "windows" {
+				dirMode, fileMode = 0777, 0777
+			}
+			expected := parents(t, sbomDir)
+			expected = append(expected,
+				&tar.Header{Name: tarPath(sbomDir), Uid: 1234, Gid: 4321, Mode: dirMode, Typeflag: tar.TypeDir},
+				&tar.Header{Name: tarPath(filepath.Join(sbomDir, "a.json")), Uid: 1234, Gid: 4321, Mode: fileMode, Typeflag: tar.TypeReg},
+				&tar.Header{Name: tarPath(filepath.Join(sbomDir, "b.json")), Uid: 1234, Gid: 4321, Mode: fileMode, Typeflag: tar.TypeReg},
+			)
+			assertTarEntries(t, sbomLayer.TarPath, expected)
+			assertEntryContent(t, sbomLayer.TarPath, tarPath(filepath.Join(sbomDir, "b.json")), "b-content")
+			h.AssertPathDoesNotExist(t, sbomDir)
+		})
+	})
+}
@@ -12,6 +12,7 @@ const (
 	BuildMetadataLabel = "io.buildpacks.build.metadata"
 	LayerMetadataLabel = "io.buildpacks.lifecycle.metadata"
 	StackIDLabel       = "io.buildpacks.stack.id"
+	StackMixinsLabel   = "io.buildpacks.stack.mixins"
 )
 
 type BuildMetadata struct {
@@ -52,6 +53,7 @@ func (md BuildMetadata) toLaunchMD() launch.Metadata {
 
 type CacheMetadata struct {
 	Buildpacks []BuildpackLayersMetadata `json:"buildpacks"`
+	Detect     []DetectCacheEntry        `json:"detect,omitempty"`
 }
 
 func (cm *CacheMetadata) MetadataForBuildpack(id string) BuildpackLayersMetadata {
@@ -69,8 +71,10 @@ type LayersMetadata struct {
 	Buildpacks   []BuildpackLayersMetadata `json:"buildpacks" toml:"buildpacks"`
 	Config       LayerMetadata             `json:"config" toml:"config"`
 	Launcher     LayerMetadata             `json:"launcher" toml:"launcher"`
+	Merged       []MergedLayerMetadata     `json:"merged,omitempty" toml:"merged,omitempty"`
 	ProcessTypes LayerMetadata             `json:"process-types" toml:"process-types"`
 	RunImage     RunImageMetadata          `json:"runImage" toml:"run-image"`
+	SBOM         LayerMetadata             `json:"sbom" toml:"sbom"`
 	Stack        StackMetadata             `json:"stack" toml:"stack"`
 }
 
@@ -81,9 +85,11 @@ type LayersMetadataCompat struct {
 	App          interface{}               `json:"app" toml:"app"`
 	Config       LayerMetadata             `json:"config" toml:"config"`
 	Launcher     LayerMetadata             `json:"launcher" toml:"launcher"`
+	Merged       []MergedLayerMetadata     `json:"merged,omitempty" toml:"merged,omitempty"`
 	ProcessTypes LayerMetadata             `json:"process-types" toml:"process-types"`
 	Buildpacks   []BuildpackLayersMetadata `json:"buildpacks" toml:"buildpacks"`
 	RunImage     RunImageMetadata          `json:"runImage" toml:"run-image"`
+	SBOM         LayerMetadata             `json:"sbom" toml:"sbom"`
 	Stack        StackMetadata             `json:"stack" toml:"stack"`
 }
 
@@ -101,6 +107,24 @@ type LayerMetadata struct {
 	SHA string `json:"sha" toml:"sha"`
 }
 
+// MergedLayerMetadata is a layer of the image that contains several buildpack layers, to keep the number of layers
+// below Exporter.MaxLayers. The metadata of each buildpack layer still records the SHA of the layer on its own.
+type MergedLayerMetadata struct {
+	SHA    string   `json:"sha" toml:"sha"`
+	Layers []string `json:"layers" toml:"layers"` // the buildpack layers in the merged layer, as '<buildpack ID>:<layer name>'
+}
+
+func (m *LayersMetadata) mergedLayerFor(id string) *MergedLayerMetadata {
+	for i, merged := range m.Merged {
+		for _, layerID := range merged.Layers {
+			if layerID == id {
+				return &m.Merged[i]
+			}
+		}
+	}
+	return nil
+}
+
 type BuildpackLayersMetadata struct {
 	ID      string                            `json:"key" toml:"key"`
 	Version string                            `json:"version" toml:"version"`
@@ -130,7 +154,13 @@ type RunImageMetadata struct {
 }
 
 type StackMetadata struct {
-	RunImage StackRunImageMetadata `json:"runImage" toml:"run-image"`
+	BuildImage StackBuildImageMetadata `json:"-" toml:"build-image"`
+	RunImage   StackRunImageMetadata   `json:"runImage" toml:"run-image"`
+}
+
+// StackBuildImageMetadata holds the mixins label of the build image, as provided by the platform
+type StackBuildImageMetadata struct {
+	Mixins []string `toml:"mixins" json:"mixins,omitempty"`
 }
 
 type StackRunImageMetadata struct {
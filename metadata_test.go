@@ -2,6 +2,7 @@ package lifecycle_test
 
 import (
 	"testing"
+	"time"
 
 	"github.com/sclevine/spec"
 
@@ -72,4 +73,29 @@ func testMetadata(t *testing.T, when spec.G, it spec.S) {
 			})
 		})
 	})
+
+	when("CommitTime", func() {
+		it("returns the commit-time in the project source metadata", func() {
+			projectMD := lifecycle.ProjectMetadata{Source: &lifecycle.ProjectSource{
+				Metadata: map[string]interface{}{"commit-time": "2021-03-04T06:06:07+01:00"},
+			}}
+			commitTime, err := projectMD.CommitTime()
+			h.AssertNil(t, err)
+			h.AssertEq(t, commitTime, time.Date(2021, time.March, 4, 5, 6, 7, 0, time.UTC))
+		})
+
+		it("returns the zero time when there is no commit-time", func() {
+			commitTime, err := lifecycle.ProjectMetadata{}.CommitTime()
+			h.AssertNil(t, err)
+			h.AssertEq(t, commitTime.IsZero(), true)
+		})
+
+		it("fails when the commit-time is not a time", func() {
+			projectMD := lifecycle.ProjectMetadata{Source: &lifecycle.ProjectSource{
+				Metadata: map[string]interface{}{"commit-time": "yesterday"},
+			}}
+			_, err := projectMD.CommitTime()
+			h.AssertError(t, err, "parse commit-time in project metadata")
+		})
+	})
 }
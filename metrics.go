@@ -0,0 +1,87 @@
+package lifecycle
+
+import (
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Metrics records how long each phase took and the resources used by every /bin/detect and /bin/build
+// invocation. If the platform asks for metrics, they are written to metrics.toml, which the detector starts
+// and later phases add to.
+type Metrics struct {
+	Phases []PhaseMetrics   `toml:"phases"`
+	Detect []ProcessMetrics `toml:"detect"`
+	Build  []ProcessMetrics `toml:"build"`
+	mu     sync.Mutex
+}
+
+// PhaseMetrics is the wall time of a phase, in seconds.
+type PhaseMetrics struct {
+	Name     string  `toml:"name"`
+	WallTime float64 `toml:"wall-time"`
+}
+
+// ProcessMetrics describes a buildpack process. Times are in seconds. MaxRSS is the peak resident
+// set size in bytes, or zero where it is not available.
+type ProcessMetrics struct {
+	ID         string  `toml:"id"`
+	Version    string  `toml:"version"`
+	ExitCode   int     `toml:"exit-code"`
+	WallTime   float64 `toml:"wall-time"`
+	UserTime   float64 `toml:"user-time"`
+	SystemTime float64 `toml:"system-time"`
+	MaxRSS     int64   `toml:"max-rss"`
+}
+
+// ReadMetrics returns the metrics recorded by earlier phases, which are empty if the file does not exist.
+func ReadMetrics(path string) (*Metrics, error) {
+	metrics := &Metrics{}
+	if _, err := toml.DecodeFile(path, metrics); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return metrics, nil
+}
+
+// AddPhase records the wall time of a phase.
+func (m *Metrics) AddPhase(name string, wallTime time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Phases = append(m.Phases, PhaseMetrics{Name: name, WallTime: wallTime.Seconds()})
+}
+
+func (m *Metrics) addDetect(p ProcessMetrics) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Detect = append(m.Detect, p)
+}
+
+func (m *Metrics) addBuild(p ProcessMetrics) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Build = append(m.Build, p)
+}
+
+// newProcessMetrics describes cmd, which must have been run, from its wall time and resource usage.
+func newProcessMetrics(id, version string, cmd *exec.Cmd, wallTime time.Duration) ProcessMetrics {
+	p := ProcessMetrics{ID: id, Version: version, WallTime: wallTime.Seconds()}
+	if state := cmd.ProcessState; state != nil {
+		p.ExitCode = state.ExitCode()
+		p.UserTime = state.UserTime().Seconds()
+		p.SystemTime = state.SystemTime().Seconds()
+		p.MaxRSS = maxRSS(state)
+	}
+	return p
+}
@@ -1,5 +1,11 @@
 package lifecycle
 
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
 const (
 	ProjectMetadataLabel = "io.buildpacks.project.metadata"
 )
@@ -13,3 +19,25 @@ type ProjectSource struct {
 	Version  map[string]interface{} `toml:"version" json:"version,omitempty"`
 	Metadata map[string]interface{} `toml:"metadata" json:"metadata,omitempty"`
 }
+
+// CommitTime returns the time of the commit the project was built from, if the source metadata records it
+// as 'commit-time', either as a TOML datetime or in RFC 3339 format. It returns the zero time otherwise.
+func (p ProjectMetadata) CommitTime() (time.Time, error) {
+	if p.Source == nil {
+		return time.Time{}, nil
+	}
+	switch v := p.Source.Metadata["commit-time"].(type) {
+	case nil:
+		return time.Time{}, nil
+	case time.Time:
+		return v.UTC(), nil
+	case string:
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return time.Time{}, errors.Wrap(err, "parse commit-time in project metadata")
+		}
+		return t.UTC(), nil
+	default:
+		return time.Time{}, errors.Errorf("invalid commit-time '%v' in project metadata", v)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/buildpacks/imgutil"
 	"github.com/pkg/errors"
@@ -45,6 +46,20 @@ func (r *Rebaser) Rebase(workingImage imgutil.Image, newBaseImage imgutil.Image,
 		return RebaseReport{}, errors.New(fmt.Sprintf("incompatible stack: '%s' is not compatible with '%s'", newBaseStackID, workingStackID))
 	}
 
+	workingMixins, err := ReadMixins(workingImage)
+	if err != nil {
+		return RebaseReport{}, errors.Wrap(err, "get working image mixins")
+	}
+
+	newBaseMixins, err := ReadMixins(newBaseImage)
+	if err != nil {
+		return RebaseReport{}, errors.Wrap(err, "get new base image mixins")
+	}
+
+	if err := validateRunMixins(workingMixins, newBaseMixins); err != nil {
+		return RebaseReport{}, errors.Wrap(err, "incompatible new base image")
+	}
+
 	err = workingImage.Rebase(origMetadata.RunImage.TopLayer, newBaseImage)
 	if err != nil {
 		return RebaseReport{}, errors.Wrap(err, "rebase working image")
@@ -76,7 +91,7 @@ func (r *Rebaser) Rebase(workingImage imgutil.Image, newBaseImage imgutil.Image,
 	}
 
 	report := RebaseReport{}
-	report.Image, err = saveImage(workingImage, additionalNames, r.Logger)
+	report.Image, err = saveImage(workingImage, time.Time{}, additionalNames, r.Logger)
 	if err != nil {
 		return RebaseReport{}, err
 	}
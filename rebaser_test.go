@@ -208,5 +208,16 @@ func testRebaser(t *testing.T, when spec.G, it spec.S) {
 				h.AssertError(t, err, "stack not defined on working image")
 			})
 		})
+
+		when("the new base image lacks mixins of the app image", func() {
+			it("returns an error listing the missing mixins and prevents the rebase from taking place", func() {
+				h.AssertNil(t, fakeWorkingImage.SetLabel(lifecycle.StackMixinsLabel, `["mixin-a", "mixin-b", "run:mixin-c"]`))
+				h.AssertNil(t, fakeNewBaseImage.SetLabel(lifecycle.StackMixinsLabel, `["mixin-a"]`))
+
+				_, err := rebaser.Rebase(fakeWorkingImage, fakeNewBaseImage, additionalNames)
+				h.AssertError(t, err, "incompatible new base image: missing required mixin(s): mixin-b, run:mixin-c")
+				h.AssertEq(t, fakeWorkingImage.Base(), "")
+			})
+		})
 	})
 }
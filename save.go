@@ -2,6 +2,8 @@ package lifecycle
 
 import (
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/buildpacks/imgutil"
 	"github.com/buildpacks/imgutil/local"
@@ -9,10 +11,20 @@ import (
 	"github.com/pkg/errors"
 )
 
-func saveImage(image imgutil.Image, additionalNames []string, logger Logger) (ImageReport, error) {
+// createdAtImage is implemented by images that can be saved with a given creation time, like layout images.
+type createdAtImage interface {
+	SetCreatedAt(createdAt time.Time)
+}
+
+// normalizedDateTimeMu guards imgutil.NormalizedDateTime, which local and remote images use as
+// the creation time of their config and history when they are saved.
+var normalizedDateTimeMu sync.Mutex
+
+// saveImage saves the image with the creation time createdAt, or the image's default creation time if createdAt is zero.
+func saveImage(image imgutil.Image, createdAt time.Time, additionalNames []string, logger Logger) (ImageReport, error) {
 	var saveErr error
 	imageReport := ImageReport{}
-	if err := image.Save(additionalNames...); err != nil {
+	if err := saveImageAt(image, createdAt, additionalNames); err != nil {
 		var ok bool
 		if saveErr, ok = err.(imgutil.SaveError); !ok {
 			return ImageReport{}, errors.Wrap(err, "saving image")
@@ -49,6 +61,22 @@ func saveImage(image imgutil.Image, additionalNames []string, logger Logger) (Im
 	return imageReport, saveErr
 }
 
+func saveImageAt(image imgutil.Image, createdAt time.Time, additionalNames []string) error {
+	if createdAt.IsZero() {
+		return image.Save(additionalNames...)
+	}
+	if ci, ok := image.(createdAtImage); ok {
+		ci.SetCreatedAt(createdAt)
+		return image.Save(additionalNames...)
+	}
+	normalizedDateTimeMu.Lock()
+	defer normalizedDateTimeMu.Unlock()
+	defaultDateTime := imgutil.NormalizedDateTime
+	imgutil.NormalizedDateTime = createdAt
+	defer func() { imgutil.NormalizedDateTime = defaultDateTime }()
+	return image.Save(additionalNames...)
+}
+
 type MultiError struct {
 	Errors []error
 }
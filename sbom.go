@@ -0,0 +1,224 @@
+package lifecycle
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	CycloneDXFile = "bom.cdx.json"
+	SPDXFile      = "bom.spdx.json"
+
+	sbomBuildpackIDProperty      = "io.buildpacks.buildpack.id"
+	sbomBuildpackVersionProperty = "io.buildpacks.buildpack.version"
+	sbomMetadataPropertyPrefix   = "io.buildpacks.metadata."
+)
+
+// CycloneDX is a CycloneDX 1.3 document describing the components in the bill of materials.
+type CycloneDX struct {
+	BOMFormat   string               `json:"bomFormat"`
+	SpecVersion string               `json:"specVersion"`
+	Version     int                  `json:"version"`
+	Components  []CycloneDXComponent `json:"components"`
+}
+
+type CycloneDXComponent struct {
+	Type       string              `json:"type"`
+	Name       string              `json:"name"`
+	Version    string              `json:"version,omitempty"`
+	Properties []CycloneDXProperty `json:"properties,omitempty"`
+}
+
+type CycloneDXProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// SPDX is an SPDX 2.2 document describing the packages in the bill of materials.
+type SPDX struct {
+	SPDXVersion       string           `json:"spdxVersion"`
+	DataLicense       string           `json:"dataLicense"`
+	SPDXID            string           `json:"SPDXID"`
+	Name              string           `json:"name"`
+	DocumentNamespace string           `json:"documentNamespace"`
+	CreationInfo      SPDXCreationInfo `json:"creationInfo"`
+	Packages          []SPDXPackage    `json:"packages"`
+}
+
+type SPDXCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type SPDXPackage struct {
+	SPDXID           string           `json:"SPDXID"`
+	Name             string           `json:"name"`
+	VersionInfo      string           `json:"versionInfo,omitempty"`
+	DownloadLocation string           `json:"downloadLocation"`
+	FilesAnalyzed    bool             `json:"filesAnalyzed"`
+	LicenseConcluded string           `json:"licenseConcluded"`
+	LicenseDeclared  string           `json:"licenseDeclared"`
+	CopyrightText    string           `json:"copyrightText"`
+	SourceInfo       string           `json:"sourceInfo,omitempty"`
+	Annotations      []SPDXAnnotation `json:"annotations,omitempty"`
+}
+
+type SPDXAnnotation struct {
+	AnnotationType string `json:"annotationType"`
+	Annotator      string `json:"annotator"`
+	AnnotationDate string `json:"annotationDate"`
+	Comment        string `json:"comment"`
+}
+
+// sbomProperties returns the buildpack that contributed the entry and its metadata as name-value pairs,
+// sorted by name. Metadata values that are not strings are encoded as JSON.
+func (e BOMEntry) sbomProperties() ([]CycloneDXProperty, error) {
+	props := []CycloneDXProperty{
+		{Name: sbomBuildpackIDProperty, Value: e.Buildpack.ID},
+		{Name: sbomBuildpackVersionProperty, Value: e.Buildpack.Version},
+	}
+	var keys []string
+	for key := range e.Metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value, ok := e.Metadata[key].(string)
+		if !ok {
+			data, err := json.Marshal(e.Metadata[key])
+			if err != nil {
+				return nil, errors.Wrapf(err, "encode metadata '%s' of '%s'", key, e.Name)
+			}
+			value = string(data)
+		}
+		props = append(props, CycloneDXProperty{Name: sbomMetadataPropertyPrefix + key, Value: value})
+	}
+	return props, nil
+}
+
+// sbomVersion returns the version of the entry, which builds on Platform API 0.4 and later record
+// as metadata.version instead.
+func (e BOMEntry) sbomVersion() string {
+	if e.Version != "" {
+		return e.Version
+	}
+	if version, ok := e.Metadata["version"]; ok {
+		return fmt.Sprintf("%v", version)
+	}
+	return ""
+}
+
+// NewCycloneDX returns a CycloneDX document with a component for each entry in the bill of materials.
+func NewCycloneDX(bom []BOMEntry) (CycloneDX, error) {
+	doc := CycloneDX{
+		BOMFormat:   "CycloneDX",
+		SpecVersion: "1.3",
+		Version:     1,
+		Components:  []CycloneDXComponent{},
+	}
+	for _, entry := range bom {
+		props, err := entry.sbomProperties()
+		if err != nil {
+			return CycloneDX{}, err
+		}
+		doc.Components = append(doc.Components, CycloneDXComponent{
+			Type:       "library",
+			Name:       entry.Name,
+			Version:    entry.sbomVersion(),
+			Properties: props,
+		})
+	}
+	return doc, nil
+}
+
+// NewSPDX returns an SPDX document with a package for each entry in the bill of materials.
+// The contributing buildpack is recorded as the source of each package and its metadata as annotations.
+// The document namespace is derived from the entries and the creation time is that of the image,
+// so that the same bill of materials always results in the same document for the same image creation time.
+func NewSPDX(bom []BOMEntry, createdAt time.Time) (SPDX, error) {
+	created := createdAt.UTC().Format("2006-01-02T15:04:05Z")
+	data, err := json.Marshal(bom)
+	if err != nil {
+		return SPDX{}, errors.Wrap(err, "encode bill of materials")
+	}
+	doc := SPDX{
+		SPDXVersion:       "SPDX-2.2",
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              "app",
+		DocumentNamespace: fmt.Sprintf("https://buildpacks.io/spdx/%x", sha256.Sum256(data)),
+		CreationInfo: SPDXCreationInfo{
+			Created:  created,
+			Creators: []string{"Tool: lifecycle"},
+		},
+		Packages: []SPDXPackage{},
+	}
+	for i, entry := range bom {
+		props, err := entry.sbomProperties()
+		if err != nil {
+			return SPDX{}, err
+		}
+		pkg := SPDXPackage{
+			SPDXID:           fmt.Sprintf("SPDXRef-Package-%d", i),
+			Name:             entry.Name,
+			VersionInfo:      entry.sbomVersion(),
+			DownloadLocation: "NOASSERTION",
+			LicenseConcluded: "NOASSERTION",
+			LicenseDeclared:  "NOASSERTION",
+			CopyrightText:    "NOASSERTION",
+			SourceInfo:       fmt.Sprintf("contributed by buildpack %s", entry.Buildpack),
+		}
+		for _, prop := range props[2:] {
+			pkg.Annotations = append(pkg.Annotations, SPDXAnnotation{
+				AnnotationType: "OTHER",
+				Annotator:      "Tool: lifecycle",
+				AnnotationDate: created,
+				Comment:        fmt.Sprintf("%s=%s", prop.Name, prop.Value),
+			})
+		}
+		doc.Packages = append(doc.Packages, pkg)
+	}
+	return doc, nil
+}
+
+// sbomDocuments returns the CycloneDX and SPDX documents for the bill of materials, by file name.
+func sbomDocuments(bom []BOMEntry, createdAt time.Time) (map[string][]byte, error) {
+	cdx, err := NewCycloneDX(bom)
+	if err != nil {
+		return nil, err
+	}
+	spdx, err := NewSPDX(bom, createdAt)
+	if err != nil {
+		return nil, err
+	}
+	docs := map[string][]byte{}
+	for file, doc := range map[string]interface{}{CycloneDXFile: cdx, SPDXFile: spdx} {
+		data, err := json.MarshalIndent(doc, "", "  ")
+		if err != nil {
+			return nil, errors.Wrapf(err, "encode %s", file)
+		}
+		docs[file] = data
+	}
+	return docs, nil
+}
+
+// writeSBOM writes the SBOM documents to dir.
+func writeSBOM(dir string, docs map[string][]byte) error {
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	for file, data := range docs {
+		if err := ioutil.WriteFile(filepath.Join(dir, file), data, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package lifecycle
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	EnvSecretsDir = "CNB_SECRETS_DIR"
+
+	redactedSecret = "[redacted]"
+)
+
+// Secret is a file in the secrets directory provided by the platform. The directory is kept apart from
+// the platform directory, which every buildpack can read; only each buildpack's /bin/build is given a copy
+// of the secrets, in a private directory.
+type Secret struct {
+	Name  string
+	Value []byte // the contents of the file, without any trailing newline
+}
+
+type Secrets []Secret
+
+// ReadSecrets returns the secrets in dir, ignoring empty files. There are no secrets if dir is empty.
+func ReadSecrets(dir string) (Secrets, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Wrap(err, "read secrets")
+	}
+	var secrets Secrets
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		value, err := ioutil.ReadFile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return nil, errors.Wrapf(err, "read secret '%s'", f.Name())
+		}
+		value = bytes.TrimRight(value, "\r\n")
+		if len(value) == 0 {
+			continue
+		}
+		secrets = append(secrets, Secret{Name: f.Name(), Value: value})
+	}
+	return secrets, nil
+}
+
+// stage writes the secrets to a new directory that only the current user can read, in memory if /dev/shm
+// is available. The directory must be removed once the buildpack that was given it has exited.
+func (s Secrets) stage() (string, error) {
+	parent := ""
+	if fi, err := os.Stat("/dev/shm"); err == nil && fi.IsDir() {
+		parent = "/dev/shm"
+	}
+	dir, err := ioutil.TempDir(parent, "secrets.")
+	if err != nil {
+		return "", errors.Wrap(err, "create secrets directory")
+	}
+	for _, secret := range s {
+		if err := ioutil.WriteFile(filepath.Join(dir, secret.Name), secret.Value, 0600); err != nil {
+			os.RemoveAll(dir)
+			return "", errors.Wrapf(err, "write secret '%s'", secret.Name)
+		}
+	}
+	return dir, nil
+}
+
+// find returns the secret whose value appears in the file at path, if any.
+// The file is read in chunks, keeping enough of each chunk to find values that span two chunks.
+func (s Secrets) find(path string) (*Secret, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	overlap := s.maxLen() - 1
+	buf := make([]byte, 0, 32*1024+overlap)
+	r := bufio.NewReader(f)
+	for {
+		n, err := io.ReadFull(r, buf[len(buf):cap(buf)])
+		buf = buf[:len(buf)+n]
+		for i, secret := range s {
+			if bytes.Contains(buf, secret.Value) {
+				return &s[i], nil
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if len(buf) > overlap {
+			buf = buf[:copy(buf, buf[len(buf)-overlap:])]
+		}
+	}
+}
+
+// checkLayer returns an error naming the secret and file if the value of any secret appears
+// in a file in the layer directory.
+func (s Secrets) checkLayer(layerDir string) error {
+	if len(s) == 0 {
+		return nil
+	}
+	return filepath.Walk(layerDir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		secret, err := s.find(path)
+		if err != nil {
+			return err
+		}
+		if secret != nil {
+			return errors.Errorf("file '%s' contains the value of secret '%s'", path, secret.Name)
+		}
+		return nil
+	})
+}
+
+func (s Secrets) maxLen() int {
+	max := 1
+	for _, secret := range s {
+		if len(secret.Value) > max {
+			max = len(secret.Value)
+		}
+	}
+	return max
+}
+
+// redactWriter replaces the values of secrets written to w with "[redacted]". Output that may be
+// the start of a secret is held back until the rest of it is written, or until Flush is called.
+type redactWriter struct {
+	w       io.Writer
+	secrets Secrets
+	pending []byte
+}
+
+func newRedactWriter(w io.Writer, secrets Secrets) *redactWriter {
+	return &redactWriter{w: w, secrets: secrets}
+}
+
+func (r *redactWriter) Write(data []byte) (int, error) {
+	if len(r.secrets) == 0 {
+		return r.w.Write(data)
+	}
+	r.pending = append(r.pending, data...)
+	for _, secret := range r.secrets {
+		r.pending = bytes.Replace(r.pending, secret.Value, []byte(redactedSecret), -1)
+	}
+	keep := r.partialSecret()
+	if _, err := r.w.Write(r.pending[:len(r.pending)-keep]); err != nil {
+		return 0, err
+	}
+	r.pending = append(r.pending[:0], r.pending[len(r.pending)-keep:]...)
+	return len(data), nil
+}
+
+// partialSecret returns the length of the longest end of the pending output that is the start of a secret.
+func (r *redactWriter) partialSecret() int {
+	keep := 0
+	for _, secret := range r.secrets {
+		for n := len(secret.Value) - 1; n > keep; n-- {
+			if n <= len(r.pending) && bytes.HasSuffix(r.pending, secret.Value[:n]) {
+				keep = n
+				break
+			}
+		}
+	}
+	return keep
+}
+
+// Flush writes any output that was held back.
+func (r *redactWriter) Flush() error {
+	if len(r.pending) == 0 {
+		return nil
+	}
+	_, err := r.w.Write(r.pending)
+	r.pending = nil
+	return err
+}
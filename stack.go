@@ -0,0 +1,123 @@
+package lifecycle
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/buildpacks/imgutil"
+	"github.com/pkg/errors"
+)
+
+const (
+	buildMixinPrefix = "build:"
+	runMixinPrefix   = "run:"
+)
+
+// Stack describes the stack detection runs on. The mixins are the io.buildpacks.stack.mixins labels
+// of the build and run images. Nil mixins are not validated.
+type Stack struct {
+	ID          string
+	BuildMixins []string
+	RunMixins   []string
+}
+
+// BuildpackStack is a stack supported by a buildpack and the mixins the buildpack requires on it.
+// Mixins prefixed with "build:" or "run:" are only required on the build or run image.
+type BuildpackStack struct {
+	ID     string   `toml:"id"`
+	Mixins []string `toml:"mixins,omitempty"`
+}
+
+// checkStack returns an error if the buildpack does not support the stack or requires mixins it lacks.
+// Buildpacks that do not declare any stacks are assumed to support every stack.
+func (bp *BuildpackTOML) checkStack(stack Stack) error {
+	if len(bp.Stacks) == 0 {
+		return nil
+	}
+	for _, s := range bp.Stacks {
+		if s.ID != stack.ID && s.ID != "*" {
+			continue
+		}
+		var missing []string
+		for _, mixin := range s.Mixins {
+			build := !strings.HasPrefix(mixin, runMixinPrefix)
+			run := !strings.HasPrefix(mixin, buildMixinPrefix)
+			if (build && stack.BuildMixins != nil && !hasMixin(stack.BuildMixins, mixin)) ||
+				(run && stack.RunMixins != nil && !hasMixin(stack.RunMixins, mixin)) {
+				missing = append(missing, mixin)
+			}
+		}
+		if len(missing) > 0 {
+			return missingMixinsError(missing)
+		}
+		return nil
+	}
+	return errors.Errorf("stack '%s' is not supported", stack.ID)
+}
+
+// RequiredMixins returns the mixins that the buildpacks of the group require on the stack, sorted and without duplicates.
+func RequiredMixins(group []Buildpack, buildpacksDir, stackID string) ([]string, error) {
+	seen := map[string]bool{}
+	var mixins []string
+	for _, bp := range group {
+		bpTOML, err := bp.Lookup(buildpacksDir)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range bpTOML.Stacks {
+			if s.ID != stackID && s.ID != "*" {
+				continue
+			}
+			for _, mixin := range s.Mixins {
+				if !seen[mixin] {
+					seen[mixin] = true
+					mixins = append(mixins, mixin)
+				}
+			}
+			break
+		}
+	}
+	sort.Strings(mixins)
+	return mixins, nil
+}
+
+// ReadMixins returns the mixins listed in the io.buildpacks.stack.mixins label of the image.
+func ReadMixins(image imgutil.Image) ([]string, error) {
+	var mixins []string
+	if err := DecodeLabel(image, StackMixinsLabel, &mixins); err != nil {
+		return nil, err
+	}
+	return mixins, nil
+}
+
+// validateRunMixins returns an error listing the required mixins that the run image does not have.
+// Mixins prefixed with "build:" are never required on the run image.
+func validateRunMixins(required, runMixins []string) error {
+	var missing []string
+	for _, mixin := range required {
+		if strings.HasPrefix(mixin, buildMixinPrefix) {
+			continue
+		}
+		if !hasMixin(runMixins, mixin) {
+			missing = append(missing, mixin)
+		}
+	}
+	if len(missing) > 0 {
+		return missingMixinsError(missing)
+	}
+	return nil
+}
+
+func missingMixinsError(missing []string) error {
+	sort.Strings(missing)
+	return errors.Errorf("missing required mixin(s): %s", strings.Join(missing, ", "))
+}
+
+func hasMixin(mixins []string, mixin string) bool {
+	for _, m := range mixins {
+		if m == mixin {
+			return true
+		}
+	}
+	return false
+}
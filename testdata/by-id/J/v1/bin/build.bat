@@ -0,0 +1 @@
+../../../../buildpack/bin/build.bat
\ No newline at end of file
@@ -0,0 +1 @@
+../../../../buildpack/bin/detect.bat
\ No newline at end of file
@@ -66,6 +66,21 @@ func (mr *MockLayerFactoryMockRecorder) LauncherLayer(arg0 interface{}) *gomock.
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LauncherLayer", reflect.TypeOf((*MockLayerFactory)(nil).LauncherLayer), arg0)
 }
 
+// MergedLayer mocks base method
+func (m *MockLayerFactory) MergedLayer(arg0 string, arg1 []string) (layers.Layer, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MergedLayer", arg0, arg1)
+	ret0, _ := ret[0].(layers.Layer)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// MergedLayer indicates an expected call of MergedLayer
+func (mr *MockLayerFactoryMockRecorder) MergedLayer(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MergedLayer", reflect.TypeOf((*MockLayerFactory)(nil).MergedLayer), arg0, arg1)
+}
+
 // ProcessTypesLayer mocks base method
 func (m *MockLayerFactory) ProcessTypesLayer(arg0 launch.Metadata) (layers.Layer, error) {
 	m.ctrl.T.Helper()
@@ -81,6 +96,21 @@ func (mr *MockLayerFactoryMockRecorder) ProcessTypesLayer(arg0 interface{}) *gom
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ProcessTypesLayer", reflect.TypeOf((*MockLayerFactory)(nil).ProcessTypesLayer), arg0)
 }
 
+// SBOMLayer mocks base method
+func (m *MockLayerFactory) SBOMLayer(arg0 string, arg1 map[string][]byte) (layers.Layer, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SBOMLayer", arg0, arg1)
+	ret0, _ := ret[0].(layers.Layer)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SBOMLayer indicates an expected call of SBOMLayer
+func (mr *MockLayerFactoryMockRecorder) SBOMLayer(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SBOMLayer", reflect.TypeOf((*MockLayerFactory)(nil).SBOMLayer), arg0, arg1)
+}
+
 // SliceLayers mocks base method
 func (m *MockLayerFactory) SliceLayers(arg0 string, arg1 []layers.Slice) ([]layers.Layer, error) {
 	m.ctrl.T.Helper()
@@ -37,6 +37,16 @@ func ReadOrder(path string) (BuildpackOrder, error) {
 	return order.Order, err
 }
 
+// ReadPlatformBuildpacks reads the buildpacks excluded or required by the platform.
+// A missing file excludes and requires nothing.
+func ReadPlatformBuildpacks(path string) (PlatformBuildpacks, error) {
+	var platform PlatformBuildpacks
+	if _, err := toml.DecodeFile(path, &platform); err != nil && !os.IsNotExist(err) {
+		return PlatformBuildpacks{}, err
+	}
+	return platform, nil
+}
+
 func TruncateSha(sha string) string {
 	rawSha := strings.TrimPrefix(sha, "sha256:")
 	if len(sha) > 12 {
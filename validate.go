@@ -0,0 +1,267 @@
+package lifecycle
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pkg/errors"
+)
+
+const (
+	TOMLValidationWarn   = "warn"
+	TOMLValidationStrict = "strict"
+)
+
+// tomlSchema describes the keys of a table in a TOML file written by a buildpack.
+type tomlSchema map[string]tomlKey
+
+type tomlKey struct {
+	kind     tomlKind
+	required bool
+	elem     tomlSchema // the schema of each table in an array of tables
+}
+
+// tomlKind is the type of a TOML value, described as it appears in validation errors.
+type tomlKind string
+
+const (
+	tomlString      tomlKind = "a string"
+	tomlBool        tomlKind = "a boolean"
+	tomlStringArray tomlKind = "an array of strings"
+	tomlTable       tomlKind = "a table"
+	tomlTableArray  tomlKind = "an array of tables"
+)
+
+var (
+	launchTOMLSchema = tomlSchema{
+		"labels": {kind: tomlTableArray, elem: tomlSchema{
+			"key":   {kind: tomlString, required: true},
+			"value": {kind: tomlString},
+		}},
+		"processes": {kind: tomlTableArray, elem: tomlSchema{
+			"type":    {kind: tomlString, required: true},
+			"command": {kind: tomlString, required: true},
+			"args":    {kind: tomlStringArray},
+			"direct":  {kind: tomlBool},
+		}},
+		"slices": {kind: tomlTableArray, elem: tomlSchema{
+			"paths": {kind: tomlStringArray},
+		}},
+	}
+	layerTOMLSchema = tomlSchema{
+		"metadata": {kind: tomlTable},
+		"build":    {kind: tomlBool},
+		"launch":   {kind: tomlBool},
+		"cache":    {kind: tomlBool},
+	}
+	storeTOMLSchema = tomlSchema{
+		"metadata": {kind: tomlTable},
+	}
+	buildpackPlanSchema = tomlSchema{
+		"entries": {kind: tomlTableArray, elem: tomlSchema{
+			"name":     {kind: tomlString, required: true},
+			"version":  {kind: tomlString},
+			"metadata": {kind: tomlTable},
+		}},
+	}
+)
+
+// tomlValidator decodes TOML files written by buildpacks. Values of the wrong type are errors, reported
+// with the key they are found at, except in layer metadata files outside of strict mode. Unknown keys and
+// missing required keys are only reported if mode is TOMLValidationWarn, as warnings, or
+// TOMLValidationStrict, as errors.
+type tomlValidator struct {
+	mode   string
+	logger Logger
+}
+
+// decode decodes the file at path into v, validating it against schema.
+// Errors opening the file are returned unwrapped.
+func (tv tomlValidator) decode(path string, v interface{}, schema tomlSchema) error {
+	md, err := toml.DecodeFile(path, v)
+	if os.IsNotExist(err) {
+		return err
+	} else if err != nil {
+		return tv.typeError(path, schema, err)
+	}
+	if tv.mode == "" {
+		return nil
+	}
+	var problems []string
+	for _, key := range schema.unknownKeys(md.Undecoded()) {
+		problems = append(problems, fmt.Sprintf("unknown key '%s'", key))
+	}
+	var data map[string]interface{}
+	if _, err := toml.DecodeFile(path, &data); err != nil {
+		return errors.Wrapf(err, "parse %s", path)
+	}
+	problems = append(problems, schema.check("", data, true)...)
+	if len(problems) == 0 {
+		return nil
+	}
+	if tv.mode == TOMLValidationStrict {
+		return errors.Errorf("invalid %s: %s", path, strings.Join(problems, ", "))
+	}
+	for _, problem := range problems {
+		tv.logger.Warnf("Warning: %s: %s", path, problem)
+	}
+	return nil
+}
+
+// typeError describes the values in the file at path that do not have the type required by schema,
+// or returns decodeErr with the path if there are none, e.g. because the file is not valid TOML.
+func (tv tomlValidator) typeError(path string, schema tomlSchema, decodeErr error) error {
+	var data map[string]interface{}
+	if _, err := toml.DecodeFile(path, &data); err == nil {
+		if problems := schema.check("", data, false); len(problems) > 0 {
+			return errors.Errorf("invalid %s: %s", path, strings.Join(problems, ", "))
+		}
+	}
+	return errors.Wrapf(decodeErr, "parse %s", path)
+}
+
+// validateLayerTOMLs validates the layer metadata files and store.toml in a buildpack's layers directory.
+// Unless mode is TOMLValidationStrict, values of the wrong type are only warned about, as these files
+// were not checked during the build before and the exporter still reports the layers it cannot read.
+func (tv tomlValidator) validateLayerTOMLs(bpLayersDir string) error {
+	paths, err := filepath.Glob(filepath.Join(bpLayersDir, "*.toml"))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		var err error
+		switch filepath.Base(path) {
+		case "launch.toml":
+			continue
+		case "store.toml":
+			err = tv.decode(path, &BuildpackStore{}, storeTOMLSchema)
+		default:
+			err = tv.decode(path, &BuildpackLayerMetadataFile{}, layerTOMLSchema)
+		}
+		if err != nil {
+			if tv.mode == TOMLValidationStrict {
+				return err
+			}
+			tv.logger.Warnf("Warning: %s", err)
+		}
+	}
+	return nil
+}
+
+// unknownKeys returns the undecoded keys that are not in the schema. Keys within free-form tables,
+// such as metadata, and within other unknown keys are left out.
+func (s tomlSchema) unknownKeys(undecoded []toml.Key) []string {
+	var unknown []string
+	reported := map[string]bool{}
+	for _, key := range undecoded {
+		if s.inTable(key) {
+			continue
+		}
+		var withinUnknown bool
+		for i := 1; i < len(key); i++ {
+			if reported[key[:i].String()] {
+				withinUnknown = true
+				break
+			}
+		}
+		if withinUnknown {
+			continue
+		}
+		reported[key.String()] = true
+		unknown = append(unknown, key.String())
+	}
+	return unknown
+}
+
+// inTable reports whether the key is within a free-form table in the schema.
+func (s tomlSchema) inTable(key toml.Key) bool {
+	schema := s
+	for _, part := range key[:len(key)-1] {
+		field, ok := schema[part]
+		if !ok {
+			return false
+		}
+		if field.kind == tomlTable {
+			return true
+		}
+		schema = field.elem
+	}
+	return false
+}
+
+// check returns the keys in data with values of the wrong type and,
+// if checkRequired is set, the required keys that are missing.
+func (s tomlSchema) check(prefix string, data map[string]interface{}, checkRequired bool) []string {
+	var keys []string
+	for key := range s {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var problems []string
+	for _, key := range keys {
+		field := s[key]
+		val, ok := data[key]
+		if !ok {
+			if field.required && checkRequired {
+				problems = append(problems, fmt.Sprintf("missing required key '%s%s'", prefix, key))
+			}
+			continue
+		}
+		tables, ok := field.kind.match(val)
+		if !ok {
+			problems = append(problems, fmt.Sprintf("key '%s%s' must be %s", prefix, key, field.kind))
+			continue
+		}
+		for i, table := range tables {
+			problems = append(problems, field.elem.check(fmt.Sprintf("%s%s[%d].", prefix, key, i), table, checkRequired)...)
+		}
+	}
+	return problems
+}
+
+// match reports whether val has the kind, and returns its tables if it is an array of tables.
+func (k tomlKind) match(val interface{}) ([]map[string]interface{}, bool) {
+	switch k {
+	case tomlString:
+		_, ok := val.(string)
+		return nil, ok
+	case tomlBool:
+		_, ok := val.(bool)
+		return nil, ok
+	case tomlTable:
+		_, ok := val.(map[string]interface{})
+		return nil, ok
+	case tomlStringArray:
+		vals, ok := val.([]interface{})
+		if !ok {
+			return nil, false
+		}
+		for _, v := range vals {
+			if _, ok := v.(string); !ok {
+				return nil, false
+			}
+		}
+		return nil, true
+	case tomlTableArray:
+		switch vals := val.(type) {
+		case []map[string]interface{}:
+			return vals, true
+		case []interface{}:
+			var tables []map[string]interface{}
+			for _, v := range vals {
+				table, ok := v.(map[string]interface{})
+				if !ok {
+					return nil, false
+				}
+				tables = append(tables, table)
+			}
+			return tables, true
+		}
+	}
+	return nil, false
+}
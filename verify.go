@@ -0,0 +1,327 @@
+package lifecycle
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/BurntSushi/toml"
+	"github.com/buildpacks/imgutil"
+	"github.com/pkg/errors"
+
+	"github.com/buildpacks/lifecycle/cmd"
+)
+
+const (
+	FileAdded     = "added"
+	FileRemoved   = "removed"
+	FileContent   = "content"
+	FileMode      = "mode"
+	FileOwnership = "ownership"
+
+	runImageLayerID = "run-image"
+)
+
+// verifiedEnv are the env vars the exporter sets on the image, which are verified along with the labels.
+var verifiedEnv = []string{
+	cmd.EnvAppDir,
+	cmd.EnvDeprecationMode,
+	cmd.EnvLayersDir,
+	cmd.EnvPlatformAPI,
+	cmd.EnvProcessType,
+	"PATH",
+}
+
+// LayerReport is an exported layer. Layers are identified as in the lifecycle metadata label,
+// e.g. 'buildpack.id:layer-name', 'slice-1', 'launcher' or 'sbom'.
+type LayerReport struct {
+	ID     string `toml:"id"`
+	Digest string `toml:"digest"`
+}
+
+// VerifyReport lists the differences between an export and the reference it was verified against.
+type VerifyReport struct {
+	Reference string      `toml:"reference"`
+	Layers    []LayerDiff `toml:"layers,omitempty"`
+	Config    []string    `toml:"config,omitempty"` // the labels and env vars that differ, e.g. 'label:io.buildpacks.build.metadata'
+}
+
+// LayerDiff is a layer whose digest differs from the layer with the same ID in the reference.
+// The digest is empty if the layer is only in the reference, and the reference digest is empty if it is not in the reference.
+type LayerDiff struct {
+	ID              string     `toml:"id"`
+	Digest          string     `toml:"digest,omitempty"`
+	ReferenceDigest string     `toml:"reference-digest,omitempty"`
+	Files           []FileDiff `toml:"files,omitempty"`
+}
+
+// FileDiff is a file that differs between a layer and the reference layer, with how it differs,
+// i.e. FileAdded, FileRemoved or any of FileContent, FileMode and FileOwnership.
+type FileDiff struct {
+	Path        string   `toml:"path"`
+	Differences []string `toml:"differences"`
+}
+
+func (r *VerifyReport) Identical() bool {
+	return len(r.Layers) == 0 && len(r.Config) == 0
+}
+
+// VerifyReference is an earlier export that an export is verified against.
+type VerifyReference struct {
+	Name   string
+	Layers []LayerReport
+	Config map[string]string
+	Image  imgutil.Image // the image of the earlier export, if known, to find the files that differ in differing layers
+}
+
+// NewImageVerifyReference returns a reference to verify exports against the image.
+func NewImageVerifyReference(image imgutil.Image) (*VerifyReference, error) {
+	if !image.Found() {
+		return nil, fmt.Errorf("reference image '%s' not found", image.Name())
+	}
+	var meta LayersMetadata
+	if err := DecodeLabel(image, LayerMetadataLabel, &meta); err != nil {
+		return nil, err
+	}
+	config, err := configDigests(image)
+	if err != nil {
+		return nil, err
+	}
+	return &VerifyReference{
+		Name:   image.Name(),
+		Layers: meta.layerReports(),
+		Config: config,
+		Image:  image,
+	}, nil
+}
+
+// ReadVerifyReference returns a reference to verify exports against the export report at path.
+// Only the digests of layers and config are recorded in reports, so the files that differ are not listed.
+func ReadVerifyReference(path string) (*VerifyReference, error) {
+	var report ExportReport
+	if _, err := toml.DecodeFile(path, &report); err != nil {
+		return nil, errors.Wrapf(err, "read reference report '%s'", path)
+	}
+	if len(report.Layers) == 0 {
+		return nil, fmt.Errorf("reference report '%s' does not list any layers", path)
+	}
+	return &VerifyReference{
+		Name:   path,
+		Layers: report.Layers,
+		Config: report.Config,
+	}, nil
+}
+
+// layerReports returns the layers in the metadata in the order they are exported,
+// with the buildpack layers of each buildpack sorted by name.
+func (m LayersMetadata) layerReports() []LayerReport {
+	var reports []LayerReport
+	add := func(id, sha string) {
+		if sha != "" {
+			reports = append(reports, LayerReport{ID: id, Digest: sha})
+		}
+	}
+	add(runImageLayerID, m.RunImage.TopLayer)
+	for _, bp := range m.Buildpacks {
+		var names []string
+		for name := range bp.Layers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			add(bp.ID+":"+name, bp.Layers[name].SHA)
+		}
+	}
+	for i, slice := range m.App {
+		add(fmt.Sprintf("slice-%d", i+1), slice.SHA)
+	}
+	for i, merged := range m.Merged {
+		add(fmt.Sprintf("merged-%d", i+1), merged.SHA)
+	}
+	add("launcher", m.Launcher.SHA)
+	add("config", m.Config.SHA)
+	add("process-types", m.ProcessTypes.SHA)
+	add("sbom", m.SBOM.SHA)
+	return reports
+}
+
+// configDigests returns the SHA-256 of each label and each env var the exporter sets on the image.
+func configDigests(image imgutil.Image) (map[string]string, error) {
+	labels, err := image.Labels()
+	if err != nil {
+		return nil, errors.Wrap(err, "get labels")
+	}
+	digests := map[string]string{}
+	for key, value := range labels {
+		digests["label:"+key] = fmt.Sprintf("%x", sha256.Sum256([]byte(value)))
+	}
+	for _, key := range verifiedEnv {
+		value, err := image.Env(key)
+		if err != nil {
+			return nil, errors.Wrapf(err, "get env %s", key)
+		}
+		if value != "" {
+			digests["env:"+key] = fmt.Sprintf("%x", sha256.Sum256([]byte(value)))
+		}
+	}
+	return digests, nil
+}
+
+// verify compares the layers and config in the report with the reference. For layers that differ from
+// a layer in the reference image, the files in the layer tarballs are compared.
+func (e *Exporter) verify(report ExportReport, ref *VerifyReference) (*VerifyReport, error) {
+	verifyReport := &VerifyReport{Reference: ref.Name}
+
+	refDigests := map[string]string{}
+	for _, layer := range ref.Layers {
+		refDigests[layer.ID] = layer.Digest
+	}
+	seen := map[string]bool{}
+	for _, layer := range report.Layers {
+		seen[layer.ID] = true
+		if refDigests[layer.ID] != layer.Digest {
+			verifyReport.Layers = append(verifyReport.Layers, LayerDiff{ID: layer.ID, Digest: layer.Digest, ReferenceDigest: refDigests[layer.ID]})
+		}
+	}
+	for _, layer := range ref.Layers {
+		if !seen[layer.ID] {
+			verifyReport.Layers = append(verifyReport.Layers, LayerDiff{ID: layer.ID, ReferenceDigest: layer.Digest})
+		}
+	}
+
+	for i, diff := range verifyReport.Layers {
+		e.Logger.Infof("Layer '%s' differs from reference: '%s' != '%s'\n", diff.ID, diff.Digest, diff.ReferenceDigest)
+		tarPath, ok := e.tarPaths[diff.Digest]
+		if !ok || diff.ReferenceDigest == "" || ref.Image == nil {
+			continue
+		}
+		files, err := diffLayerFiles(tarPath, ref.Image, diff.ReferenceDigest)
+		if err != nil {
+			return nil, errors.Wrapf(err, "comparing files of layer '%s'", diff.ID)
+		}
+		for _, file := range files {
+			e.Logger.Infof("  %s: %v\n", file.Path, file.Differences)
+		}
+		verifyReport.Layers[i].Files = files
+	}
+
+	var keys []string
+	for key := range report.Config {
+		keys = append(keys, key)
+	}
+	for key := range ref.Config {
+		if _, ok := report.Config[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if report.Config[key] != ref.Config[key] {
+			e.Logger.Infof("Config '%s' differs from reference\n", key)
+			verifyReport.Config = append(verifyReport.Config, key)
+		}
+	}
+
+	if verifyReport.Identical() {
+		e.Logger.Infof("Export is identical to reference '%s'\n", ref.Name)
+	}
+	return verifyReport, nil
+}
+
+type tarFile struct {
+	typeflag byte
+	digest   string // the SHA-256 of the contents of regular files, or the target of links
+	mode     int64
+	uid, gid int
+}
+
+func diffLayerFiles(tarPath string, refImage imgutil.Image, refDigest string) ([]FileDiff, error) {
+	f, err := os.Open(tarPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	files, err := readTarFiles(f)
+	if err != nil {
+		return nil, err
+	}
+
+	rc, err := refImage.GetLayer(refDigest)
+	if err != nil {
+		return nil, errors.Wrap(err, "get reference layer")
+	}
+	defer rc.Close()
+	refFiles, err := readTarFiles(rc)
+	if err != nil {
+		return nil, errors.Wrap(err, "read reference layer")
+	}
+
+	var paths []string
+	for path := range files {
+		paths = append(paths, path)
+	}
+	for path := range refFiles {
+		if _, ok := files[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	var diffs []FileDiff
+	for _, path := range paths {
+		file, ok := files[path]
+		refFile, refOK := refFiles[path]
+		var differences []string
+		switch {
+		case !refOK:
+			differences = []string{FileAdded}
+		case !ok:
+			differences = []string{FileRemoved}
+		default:
+			if file.typeflag != refFile.typeflag || file.digest != refFile.digest {
+				differences = append(differences, FileContent)
+			}
+			if file.mode != refFile.mode {
+				differences = append(differences, FileMode)
+			}
+			if file.uid != refFile.uid || file.gid != refFile.gid {
+				differences = append(differences, FileOwnership)
+			}
+		}
+		if len(differences) > 0 {
+			diffs = append(diffs, FileDiff{Path: path, Differences: differences})
+		}
+	}
+	return diffs, nil
+}
+
+func readTarFiles(r io.Reader) (map[string]tarFile, error) {
+	files := map[string]tarFile{}
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		} else if err != nil {
+			return nil, err
+		}
+		file := tarFile{
+			typeflag: header.Typeflag,
+			digest:   header.Linkname,
+			mode:     header.Mode,
+			uid:      header.Uid,
+			gid:      header.Gid,
+		}
+		if header.Typeflag == tar.TypeReg {
+			hash := sha256.New()
+			if _, err := io.Copy(hash, tr); err != nil {
+				return nil, err
+			}
+			file.digest = fmt.Sprintf("%x", hash.Sum(nil))
+		}
+		files[header.Name] = file
+	}
+}